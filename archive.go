@@ -0,0 +1,252 @@
+package goque
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// archiveKind follows the metadata prefix of the queue in the keys
+// holding the archived items, which are followed by the position of
+// the item in the archive.
+const archiveKind = 'a'
+
+// ArchiveLength returns the number of items in the archive of the
+// queue. See WithArchive.
+func (q *Queue) ArchiveLength() uint64 {
+	q.rlock()
+	defer q.runlock()
+
+	return q.archiveTail - q.archiveHead
+}
+
+// ForEachArchived calls fn for each item in the archive of the queue,
+// oldest first, without removing them. Archived items keep the ID they
+// had in the queue, while their Key is that of the archived copy. If fn
+// returns an error, the iteration stops and that error is returned.
+//
+// As for ForEach, the read lock is held for the duration of the
+// iteration, so fn must not call any method that modifies the queue.
+func (q *Queue) ForEachArchived(fn func(*Item) error) error {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Iterate over the archived items from the oldest.
+	iter := q.db.NewIterator(q.archiveRange(), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		item := q.newArchivedItem(iter.Key(), iter.Value())
+		if err := checkItem(item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	return nil
+}
+
+// DrainArchive removes up to n of the oldest items from the archive of
+// the queue using a single atomic write and returns them, oldest first.
+// If the archive holds fewer than n items, all of them are returned.
+func (q *Queue) DrainArchive(n uint64) ([]*Item, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	// Get the oldest items and add their removal to a batch.
+	if length := q.archiveTail - q.archiveHead; n > length {
+		n = length
+	}
+	items := make([]*Item, 0, n)
+	batch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.archiveRange(), nil)
+	for uint64(len(items)) < n && iter.Next() {
+		item := q.newArchivedItem(iter.Key(), iter.Value())
+		if err := checkItem(item); err != nil {
+			iter.Release()
+			return nil, err
+		}
+		items = append(items, item)
+		batch.Delete(item.Key)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Remove these items from the archive.
+	if batch.Len() > 0 {
+		if err := q.db.Write(batch, q.writeOptions); err != nil {
+			return nil, fmt.Errorf("goque: write batch: %w", err)
+		}
+		q.archiveHead += uint64(len(items))
+	}
+
+	return items, nil
+}
+
+// TrimArchive removes the oldest items from the archive of the queue
+// until at most maxLen items are left, and returns the number of items
+// removed. The items are removed in batches, so if TrimArchive fails
+// part way through, some of them may already be gone.
+func (q *Queue) TrimArchive(maxLen uint64) (uint64, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return 0, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	// Check if there is anything to remove.
+	length := q.archiveTail - q.archiveHead
+	if length <= maxLen {
+		return 0, nil
+	}
+
+	// Remove the oldest items in batches.
+	var removed uint64
+	batch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.archiveRange(), nil)
+	defer iter.Release()
+
+	for removed < length-maxLen && iter.Next() {
+		batch.Delete(iter.Key())
+		removed++
+
+		// Write a full batch.
+		if batch.Len() >= importBatchSize {
+			if err := q.db.Write(batch, q.writeOptions); err != nil {
+				return removed - uint64(batch.Len()), fmt.Errorf("goque: write batch: %w", err)
+			}
+			q.archiveHead += uint64(batch.Len())
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return removed - uint64(batch.Len()), fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Write the remaining removals.
+	if batch.Len() > 0 {
+		if err := q.db.Write(batch, q.writeOptions); err != nil {
+			return removed - uint64(batch.Len()), fmt.Errorf("goque: write batch: %w", err)
+		}
+		q.archiveHead += uint64(batch.Len())
+	}
+
+	return removed, nil
+}
+
+// archiveItem adds storing a copy of the item with the given ID and
+// stored value in the archive to the batch, if the queue was opened
+// using WithArchive. The item is the nth one archived by the batch,
+// counting from 0, and the number of items archived by the batch after
+// it is returned, so the caller can move the archive tail by that much
+// once the batch is written.
+func (q *Queue) archiveItem(batch *leveldb.Batch, n, id uint64, data []byte) uint64 {
+	if !q.archive {
+		return n
+	}
+	batch.Put(q.archiveKey(q.archiveTail+n+1), encodeArchived(id, data))
+	return n + 1
+}
+
+// archiveStored is archiveItem for the given item, archiving the stored
+// value it was read from, or else encoding its value again.
+func (q *Queue) archiveStored(batch *leveldb.Batch, n uint64, item *Item) uint64 {
+	if !q.archive {
+		return n
+	}
+	data := item.data
+	if data == nil {
+		data = q.encodeValue(item.header(), item.Value)
+	}
+	return q.archiveItem(batch, n, item.ID, data)
+}
+
+// newArchivedItem creates an item from the given key and value of an
+// archived item.
+func (q *Queue) newArchivedItem(key, value []byte) *Item {
+	id, data := decodeArchived(value)
+	item := newItem(id, append([]byte(nil), key...), append([]byte(nil), data...))
+	item.codec = q.codec
+	return item
+}
+
+// encodeArchived returns the stored value of an archived item, which is
+// the ID the item had in the queue as a big-endian 8 byte integer
+// followed by the stored value of the item.
+func encodeArchived(id uint64, data []byte) []byte {
+	buf := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(buf, id)
+	return append(buf, data...)
+}
+
+// decodeArchived splits the stored value of an archived item into the
+// ID it had in the queue and the stored value of the item.
+func decodeArchived(data []byte) (uint64, []byte) {
+	if len(data) < 8 {
+		return 0, data
+	}
+	return binary.BigEndian.Uint64(data), data[8:]
+}
+
+// initArchive sets the head and tail of the archive of the queue from
+// its oldest and newest archived item.
+func (q *Queue) initArchive() error {
+	q.archiveHead, q.archiveTail = 0, 0
+
+	iter := q.db.NewIterator(q.archiveRange(), nil)
+	defer iter.Release()
+
+	if iter.First() {
+		q.archiveHead = keyToID(iter.Key()) - 1
+	}
+	if iter.Last() {
+		q.archiveTail = keyToID(iter.Key())
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	return nil
+}
+
+// archiveKey returns the key of the archived item at the given position.
+func (q *Queue) archiveKey(pos uint64) []byte {
+	key := append(append([]byte(nil), q.keys.meta...), archiveKind)
+	return appendKey(key, pos)
+}
+
+// archiveRange returns the key range of the archived items.
+func (q *Queue) archiveRange() *util.Range {
+	return util.BytesPrefix(append(append([]byte(nil), q.keys.meta...), archiveKind))
+}
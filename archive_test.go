@@ -0,0 +1,205 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueWithArchive(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithArchive())
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Each way of dequeuing archives the items, while Discard does not.
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.DequeueBatch(2); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.DequeueByID(5); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.Discard(1); err != nil {
+		t.Error(err)
+	}
+	_, token, err := q.PeekN(2)
+	if err != nil {
+		t.Error(err)
+	}
+	if err = q.Commit(token); err != nil {
+		t.Error(err)
+	}
+
+	if q.ArchiveLength() != 6 {
+		t.Errorf("Expected archive length of 6, got %d", q.ArchiveLength())
+	}
+
+	if q.Length() != 3 {
+		t.Errorf("Expected queue length of 3, got %d", q.Length())
+	}
+
+	// The archive is kept once reopened.
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	var ids []uint64
+	err = q.ForEachArchived(func(item *Item) error {
+		compStr := fmt.Sprintf("value for item %d", item.ID)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+
+		ids = append(ids, item.ID)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if fmt.Sprint(ids) != "[1 2 3 5 6 7]" {
+		t.Errorf("Expected archived items 1, 2, 3, 5, 6 and 7, got %v", ids)
+	}
+
+	items, err := q.DrainArchive(2)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(items) != 2 || items[0].ID != 1 || items[1].ID != 2 {
+		t.Errorf("Expected to drain archived items 1 and 2, got %d items", len(items))
+	}
+
+	n, err := q.TrimArchive(1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if n != 3 {
+		t.Errorf("Expected to trim 3 archived items, got %d", n)
+	}
+
+	items, err = q.DrainArchive(10)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(items) != 1 || items[0].ID != 7 {
+		t.Errorf("Expected to drain archived item 7, got %d items", len(items))
+	}
+
+	if q.ArchiveLength() != 0 {
+		t.Errorf("Expected archive length of 0, got %d", q.ArchiveLength())
+	}
+}
+
+func TestQueueWithoutArchive(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	if q.ArchiveLength() != 0 {
+		t.Errorf("Expected archive length of 0, got %d", q.ArchiveLength())
+	}
+
+	// The archive is emptied by Clear.
+	q.archive = true
+	if _, err = q.EnqueueString("value for item 2"); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+	if err = q.Clear(); err != nil {
+		t.Error(err)
+	}
+
+	if q.ArchiveLength() != 0 {
+		t.Errorf("Expected archive length of 0 after clear, got %d", q.ArchiveLength())
+	}
+}
+
+func TestQueueArchiveTxn(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithArchive())
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+
+	// Items dequeued within a transaction are archived once it is
+	// committed, including those it enqueued itself.
+	txn := q.Begin()
+	if _, err = txn.Enqueue([]byte("value for item 2")); err != nil {
+		t.Error(err)
+	}
+	for i := 1; i <= 2; i++ {
+		if _, err = txn.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+	if err = txn.Commit(); err != nil {
+		t.Error(err)
+	}
+
+	if q.ArchiveLength() != 2 {
+		t.Errorf("Expected archive length of 2, got %d", q.ArchiveLength())
+	}
+
+	var i int
+	err = q.ForEachArchived(func(item *Item) error {
+		i++
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// A rolled back transaction archives nothing.
+	if _, err = q.EnqueueString("value for item 3"); err != nil {
+		t.Error(err)
+	}
+	txn = q.Begin()
+	if _, err = txn.Dequeue(); err != nil {
+		t.Error(err)
+	}
+	txn.Rollback()
+
+	if q.ArchiveLength() != 2 {
+		t.Errorf("Expected archive length of 2, got %d", q.ArchiveLength())
+	}
+}
@@ -0,0 +1,189 @@
+package goque
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// backupMagic marks the start of a queue backup.
+var backupMagic = []byte("GOQUBAK")
+
+// backupVersion is the version of the backup format written by Backup.
+const backupVersion byte = 1
+
+// Backup writes a consistent backup of the queue to w, taken from a
+// snapshot so that the queue can be used while the backup is written.
+// Unlike Export, the backup holds every key of the queue as is,
+// including the index of unique items and the items in flight, and can
+// be turned back into a queue using RestoreBackup.
+//
+// The format starts with the magic bytes "GOQUBAK", a version byte and
+// the big-endian 8 byte head and tail positions, followed by each key
+// and value as its uvarint length and the bytes themselves. Keys are
+// written without the prefix of a queue opened using NewQueueFromDB.
+// A key length of zero marks the end of the backup, so a truncated
+// backup is detected on restore.
+func (q *Queue) Backup(w io.Writer) (err error) {
+	snap, err := q.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	// Always flush what has been written, keeping the first error.
+	bw := bufio.NewWriter(w)
+	defer func() {
+		if ferr := bw.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+
+	// Write the header.
+	var buf [binary.MaxVarintLen64]byte
+	bw.Write(backupMagic)
+	bw.WriteByte(backupVersion)
+	binary.BigEndian.PutUint64(buf[:], snap.head)
+	bw.Write(buf[:8])
+	binary.BigEndian.PutUint64(buf[:], snap.tail)
+	bw.Write(buf[:8])
+
+	// Write each key and value.
+	iter := snap.snap.NewIterator(q.keyRange(), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		key := iter.Key()[len(q.prefix):]
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(key)))])
+		bw.Write(key)
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(iter.Value())))])
+		if _, err := bw.Write(iter.Value()); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Mark the end of the backup.
+	_, err = bw.Write(buf[:binary.PutUvarint(buf[:], 0)])
+	return err
+}
+
+// RestoreBackup creates a new queue at dataDir from a backup written by
+// Backup to r and returns it opened with the given options, with the
+// same keys, IDs and head and tail positions as the backed up queue.
+// If dataDir already exists, ErrExists is returned. If r does not hold
+// a complete backup, ErrInvalidBackup is returned.
+//
+// If RestoreBackup fails, the new queue is removed again.
+func RestoreBackup(dataDir string, r io.Reader, opts ...QueueOption) (*Queue, error) {
+	// Check if the destination already exists.
+	if _, err := os.Stat(dataDir); err == nil {
+		return nil, ErrExists
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	q, err := OpenQueue(dataDir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.restore(r); err != nil {
+		if err := q.Drop(); err != nil {
+			q.log(LogError, "drop restored queue", "dataDir", dataDir, "error", err)
+		}
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// restore writes the keys read from the backup in r into the empty
+// queue in batches, and then sets up the queue from them.
+func (q *Queue) restore(r io.Reader) error {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	// Read the header.
+	br := bufio.NewReader(r)
+	header := make([]byte, len(backupMagic)+1+16)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return ErrInvalidBackup
+	}
+	if !bytes.Equal(header[:len(backupMagic)], backupMagic) || header[len(backupMagic)] != backupVersion {
+		return ErrInvalidBackup
+	}
+	head := binary.BigEndian.Uint64(header[len(backupMagic)+1:])
+	tail := binary.BigEndian.Uint64(header[len(backupMagic)+9:])
+
+	// Read each key and value and add them to a batch, up to the end
+	// marker.
+	batch := new(leveldb.Batch)
+	for {
+		key, err := readBackupField(br)
+		if err != nil {
+			return err
+		}
+		if len(key) == 0 {
+			break
+		}
+		value, err := readBackupField(br)
+		if err != nil {
+			return err
+		}
+		batch.Put(append(append([]byte(nil), q.prefix...), key...), value)
+
+		// Write a full batch.
+		if batch.Len() >= importBatchSize {
+			if err := q.db.Write(batch, q.writeOptions); err != nil {
+				return fmt.Errorf("goque: write batch: %w", err)
+			}
+			batch.Reset()
+		}
+	}
+
+	// Write the remaining keys.
+	if batch.Len() > 0 {
+		if err := q.db.Write(batch, q.writeOptions); err != nil {
+			return fmt.Errorf("goque: write batch: %w", err)
+		}
+	}
+
+	// Count the items and set the positions of the backed up queue.
+	if err := q.init(); err != nil {
+		return err
+	}
+	q.head, q.tail = head, tail
+	if err := q.writeState(); err != nil {
+		return err
+	}
+
+	// Wake any goroutines waiting for an item.
+	q.broadcast()
+
+	return nil
+}
+
+// readBackupField reads a uvarint length and that many bytes from br.
+func readBackupField(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, ErrInvalidBackup
+	}
+	field := make([]byte, n)
+	if _, err := io.ReadFull(br, field); err != nil {
+		return nil, ErrInvalidBackup
+	}
+	return field, nil
+}
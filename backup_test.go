@@ -0,0 +1,100 @@
+package goque
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueBackup(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, _, err = q.EnqueueUnique([]byte("unique value")); err != nil {
+		t.Error(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = q.Backup(&buf); err != nil {
+		t.Error(err)
+	}
+
+	file2 := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q2, err := RestoreBackup(file2, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q2.Drop()
+
+	// Restoring onto an existing directory should fail.
+	if _, err = RestoreBackup(file2, bytes.NewReader(buf.Bytes())); err != ErrExists {
+		t.Errorf("Expected to get exists error, got %v", err)
+	}
+
+	if q2.Length() != 8 {
+		t.Errorf("Expected queue length of 8, got %d", q2.Length())
+	}
+
+	// The index of unique items is restored as well.
+	if _, ok, err := q2.EnqueueUnique([]byte("unique value")); err != nil || ok {
+		t.Errorf("Expected unique value to be in the queue, got %v and %v", ok, err)
+	}
+
+	for i := 4; i <= 10; i++ {
+		deqItem, err := q2.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if deqItem.ID != uint64(i) {
+			t.Errorf("Expected item ID to be %d, got %d", i, deqItem.ID)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+}
+
+func TestQueueRestoreBackupTruncated(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = q.Backup(&buf); err != nil {
+		t.Error(err)
+	}
+
+	// Leave out the end marker.
+	file2 := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	if _, err = RestoreBackup(file2, bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err != ErrInvalidBackup {
+		t.Errorf("Expected to get invalid backup error, got %v", err)
+	}
+}
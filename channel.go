@@ -0,0 +1,122 @@
+package goque
+
+import (
+	"context"
+	"time"
+)
+
+// EnqueueFromChannel adds the values received from ch to the queue
+// until ch is closed or ctx is done, such as to feed the queue from an
+// upstream producer. Values that are already waiting in ch are added
+// together, up to batchSize at a time, using a single atomic write as
+// in EnqueueBatch, while a lone value is added right away.
+//
+// It returns nil once ch is closed, or the context's error once ctx is
+// done, after adding the values received so far. If adding values
+// fails, the error is returned right away and the values of the failed
+// batch are not added.
+func (q *Queue) EnqueueFromChannel(ctx context.Context, ch <-chan []byte, batchSize int) error {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	values := make([][]byte, 0, batchSize)
+	for {
+		// Wait for the next value.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case value, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			values = append(values[:0], value)
+		}
+
+		// Add the values already waiting, up to the batch size.
+		closed := false
+	fill:
+		for len(values) < batchSize {
+			select {
+			case value, ok := <-ch:
+				if !ok {
+					closed = true
+					break fill
+				}
+				values = append(values, value)
+			default:
+				break fill
+			}
+		}
+
+		if _, err := q.EnqueueBatch(values); err != nil {
+			return err
+		}
+		if closed {
+			return nil
+		}
+	}
+}
+
+// DequeueToChannel sends the items of the queue on ch in order, such as
+// to feed a pool of workers ranging over ch, until ctx is done or an
+// error occurs, which is then returned. Once the queue is empty, it
+// waits for new items using Watch rather than polling.
+//
+// Each item is only removed from the queue once it has been sent, so an
+// item in hand when ctx is done stays in the queue. As items are not
+// removed when they are taken, a queue should only be fed to a single
+// channel at a time; any number of workers may receive from it.
+func (q *Queue) DequeueToChannel(ctx context.Context, ch chan<- *Item) error {
+	watch := q.Watch()
+	defer q.unwatch(watch)
+
+	for {
+		item, err := q.Peek()
+		if err == nil {
+			// Send the item, then remove it unless it is gone.
+			select {
+			case ch <- item:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if _, err := q.DequeueByID(item.ID); err != nil && err != ErrOutOfBounds && err != ErrEmpty {
+				return err
+			}
+			continue
+		} else if err != ErrEmpty {
+			return err
+		}
+
+		// Also wake up once the next delayed item is ready, or the
+		// next in-flight item goes back into the queue.
+		q.rlock()
+		readyAt := q.wakeAt()
+		q.runlock()
+
+		var timer *time.Timer
+		var ready <-chan time.Time
+		if !readyAt.IsZero() {
+			timer = time.NewTimer(time.Until(readyAt))
+			ready = timer.C
+		}
+
+		// Wait for an Enqueue or for the context to be done. The
+		// watch channel is closed along with the queue.
+		closed := false
+		select {
+		case _, ok := <-watch:
+			closed = !ok
+		case <-ready:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if closed {
+			return ErrDBClosed
+		}
+	}
+}
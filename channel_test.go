@@ -0,0 +1,159 @@
+package goque
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueEnqueueFromChannel(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	// Values waiting in the channel are added in batches.
+	ch := make(chan []byte, 10)
+	for i := 1; i <= 10; i++ {
+		ch <- []byte(fmt.Sprintf("value for item %d", i))
+	}
+	close(ch)
+
+	if err = q.EnqueueFromChannel(context.Background(), ch, 4); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 10 {
+		t.Errorf("Expected queue length of 10, got %d", q.Length())
+	}
+
+	for i := 1; i <= 10; i++ {
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	// A done context returns after adding the values received.
+	ctx, cancel := context.WithCancel(context.Background())
+	ch = make(chan []byte)
+	done := make(chan error)
+	go func() {
+		done <- q.EnqueueFromChannel(ctx, ch, 4)
+	}()
+
+	ch <- []byte("value for item 11")
+	ch <- []byte("value for item 12")
+	cancel()
+
+	if err = <-done; err != context.Canceled {
+		t.Errorf("Expected to get context canceled error, got %v", err)
+	}
+
+	if q.Length() != 2 {
+		t.Errorf("Expected queue length of 2, got %d", q.Length())
+	}
+
+	// A failed write is returned right away.
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	ch = make(chan []byte, 1)
+	ch <- []byte("value for item 13")
+	if err = q.EnqueueFromChannel(context.Background(), ch, 4); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
+
+func TestQueueDequeueToChannel(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan *Item)
+	done := make(chan error)
+	go func() {
+		done <- q.DequeueToChannel(ctx, ch)
+	}()
+
+	// Items enqueued while it waits are sent as well.
+	for i := 1; i <= 10; i++ {
+		if i == 6 {
+			if _, err = q.EnqueueBatch([][]byte{
+				[]byte("value for item 6"), []byte("value for item 7"),
+				[]byte("value for item 8"), []byte("value for item 9"),
+				[]byte("value for item 10"),
+			}); err != nil {
+				t.Error(err)
+			}
+		}
+
+		item := <-ch
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	// An item in hand when the context is done stays in the queue.
+	if _, err = q.EnqueueString("value for item 11"); err != nil {
+		t.Error(err)
+	}
+	for q.Length() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	if err = <-done; err != context.Canceled {
+		t.Errorf("Expected to get context canceled error, got %v", err)
+	}
+
+	item, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 11"
+
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	// Closing the queue stops it.
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	go func() {
+		done <- q.DequeueToChannel(context.Background(), ch)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if err = <-done; err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
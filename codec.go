@@ -0,0 +1,49 @@
+package goque
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// ObjectCodec converts values of any type to and from the bytes stored
+// in a queue. It is used by the object helper functions of a queue, such
+// as EnqueueObject and Item.ToObject.
+type ObjectCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// GobObjectCodec is an ObjectCodec using encoding/gob. It is the default
+// codec of a queue.
+type GobObjectCodec struct{}
+
+// Marshal encodes the given value using encoding/gob.
+func (GobObjectCodec) Marshal(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	enc := gob.NewEncoder(&buffer)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Unmarshal decodes the given data into v using encoding/gob.
+func (GobObjectCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	return dec.Decode(v)
+}
+
+// JSONObjectCodec is an ObjectCodec using encoding/json, allowing the
+// stored values to be read by programs not written in Go.
+type JSONObjectCodec struct{}
+
+// Marshal encodes the given value using encoding/json.
+func (JSONObjectCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the given data into v using encoding/json.
+func (JSONObjectCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,80 @@
+package goque
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+// Equal returns true if the queue and other hold the same item values
+// in the same order, such as to check a migrated queue in a test. The
+// IDs and metadata of the items are not compared. Both queues are read
+// locked while they are compared, and the comparison stops at the
+// first difference.
+func (q *Queue) Equal(other *Queue) (bool, error) {
+	if q == other {
+		if !q.IsOpen() {
+			return false, ErrDBClosed
+		}
+		return true, nil
+	}
+
+	unlock := rlockPair(q, other)
+	defer unlock()
+
+	// Check if either queue is closed.
+	if !q.isOpen || !other.isOpen {
+		return false, ErrDBClosed
+	}
+
+	// Queues of different lengths cannot be equal.
+	if q.length() != other.length() {
+		return false, nil
+	}
+
+	// Iterate over the items of both queues from the head.
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	defer iter.Release()
+	otherIter := other.db.NewIterator(other.itemRange(), nil)
+	defer otherIter.Release()
+
+	for {
+		value, ok, err := q.nextValue(iter)
+		if err != nil {
+			return false, err
+		}
+		otherValue, otherOK, err := other.nextValue(otherIter)
+		if err != nil {
+			return false, err
+		}
+		if !ok || !otherOK {
+			return ok == otherOK, nil
+		}
+		if !bytes.Equal(value, otherValue) {
+			return false, nil
+		}
+	}
+}
+
+// nextValue moves the given iterator over the items of the queue to the
+// next item and returns its value, or false once past the tail. The
+// caller must hold the lock.
+func (q *Queue) nextValue(iter iterator.Iterator) ([]byte, bool, error) {
+	if !iter.Next() {
+		if err := iter.Error(); err != nil {
+			return nil, false, fmt.Errorf("goque: iterate items: %w", err)
+		}
+		return nil, false, nil
+	}
+
+	id := q.keyID(iter.Key())
+	if id > q.tail {
+		return nil, false, nil
+	}
+	h, value := decodeValue(iter.Value())
+	if h.err != nil {
+		return nil, false, fmt.Errorf("goque: get item %d: %w", id, h.err)
+	}
+	return value, true, nil
+}
@@ -0,0 +1,76 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueEqual(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	otherFile := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	other, err := OpenQueue(otherFile, WithCompression(Snappy))
+	if err != nil {
+		t.Error(err)
+	}
+	defer other.Drop()
+
+	// IDs and stored format are not compared.
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err = q.Discard(2); err != nil {
+		t.Error(err)
+	}
+	for i := 3; i <= 10; i++ {
+		if _, err = other.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if ok, err := q.Equal(other); err != nil || !ok {
+		t.Errorf("Expected queues to be equal, got %t and %v", ok, err)
+	}
+
+	if ok, err := other.Equal(q); err != nil || !ok {
+		t.Errorf("Expected queues to be equal, got %t and %v", ok, err)
+	}
+
+	// A different value is found.
+	if _, err = other.UpdateString(other.head+5, "new value"); err != nil {
+		t.Error(err)
+	}
+
+	if ok, err := q.Equal(other); err != nil || ok {
+		t.Errorf("Expected queues to differ, got %t and %v", ok, err)
+	}
+
+	// As is a different length.
+	if _, err = other.DequeueByID(other.head + 5); err != nil {
+		t.Error(err)
+	}
+
+	if ok, err := q.Equal(other); err != nil || ok {
+		t.Errorf("Expected queues to differ, got %t and %v", ok, err)
+	}
+
+	if ok, err := q.Equal(q); err != nil || !ok {
+		t.Errorf("Expected queue to equal itself, got %t and %v", ok, err)
+	}
+
+	if err = other.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.Equal(other); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
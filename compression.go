@@ -0,0 +1,69 @@
+package goque
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// gzipWriters holds unused gzip writers, as each one allocates large
+// buffers.
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// compressValue compresses the given value using c, returning it
+// prefixed with a byte holding c.
+func compressValue(c Compression, value []byte) []byte {
+	switch c {
+	case Snappy:
+		buf := make([]byte, 1+snappy.MaxEncodedLen(len(value)))
+		buf[0] = byte(Snappy)
+		return buf[:1+len(snappy.Encode(buf[1:], value))]
+	case Gzip:
+		var buf bytes.Buffer
+		buf.WriteByte(byte(Gzip))
+		zw := gzipWriters.Get().(*gzip.Writer)
+		zw.Reset(&buf)
+		zw.Write(value)
+		zw.Close()
+		gzipWriters.Put(zw)
+		return buf.Bytes()
+	}
+	return append([]byte{byte(NoCompression)}, value...)
+}
+
+// decompressValue decompresses a value returned by compressValue. If
+// the value cannot be decompressed, ErrDecompress is returned.
+func decompressValue(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrDecompress
+	}
+
+	switch Compression(data[0]) {
+	case NoCompression:
+		return data[1:], nil
+	case Snappy:
+		value, err := snappy.Decode(nil, data[1:])
+		if err != nil {
+			return nil, ErrDecompress
+		}
+		return value, nil
+	case Gzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return nil, ErrDecompress
+		}
+		value, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, ErrDecompress
+		}
+		return value, nil
+	}
+	return nil, ErrDecompress
+}
@@ -0,0 +1,166 @@
+package goque
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// compressionPayload returns a JSON value of about 3 KB, like the job
+// payloads typically stored in a queue.
+func compressionPayload(i int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"id":%d,"type":"resize","tasks":[`, i)
+	for j := 0; j < 40; j++ {
+		if j > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"path":"/images/%d/%d.jpg","width":%d,"height":%d,"format":"jpeg"}`, i, j, 100+j, 200+j)
+	}
+	buf.WriteString(`]}`)
+	return buf.Bytes()
+}
+
+func TestQueueWithCompression(t *testing.T) {
+	for _, c := range []Compression{Snappy, Gzip} {
+		file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+		q, err := OpenQueue(file)
+		if err != nil {
+			t.Error(err)
+		}
+
+		// Items written without compression still read with it on.
+		if _, err = q.Enqueue(compressionPayload(1)); err != nil {
+			t.Error(err)
+		}
+
+		q.Close()
+		q, err = OpenQueue(file, WithCompression(c))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if _, err = q.EnqueueBatch([][]byte{compressionPayload(2), compressionPayload(3)}); err != nil {
+			t.Error(err)
+		}
+
+		// The stored value is smaller, while the size is not.
+		data, err := q.db.Get(idToKey(2), nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(data) >= len(compressionPayload(2)) {
+			t.Errorf("Expected stored value to be compressed, got %d bytes", len(data))
+		}
+
+		size := uint64(len(compressionPayload(1)) + len(compressionPayload(2)) + len(compressionPayload(3)))
+		if q.SizeBytes() != size {
+			t.Errorf("Expected size of %d bytes, got %d", size, q.SizeBytes())
+		}
+
+		// Peek decompresses the value of the cached head item too.
+		for j := 0; j < 2; j++ {
+			item, err := q.Peek()
+			if err != nil {
+				t.Error(err)
+			}
+			if !bytes.Equal(item.Value, compressionPayload(1)) {
+				t.Errorf("Expected peeked value to be decompressed, got '%s'", item.Value)
+			}
+		}
+
+		// Updated values are compressed as well.
+		if _, err = q.Update(2, compressionPayload(2)); err != nil {
+			t.Error(err)
+		}
+
+		for i := 1; i <= 3; i++ {
+			item, err := q.Dequeue()
+			if err != nil {
+				t.Error(err)
+			}
+
+			if !bytes.Equal(item.Value, compressionPayload(i)) {
+				t.Errorf("Expected value of item %d to be decompressed, got '%s'", i, item.Value)
+			}
+		}
+
+		if q.SizeBytes() != 0 {
+			t.Errorf("Expected size of 0 bytes, got %d", q.SizeBytes())
+		}
+
+		q.Drop()
+	}
+}
+
+func TestQueueDecompressError(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithCompression(Gzip))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.Enqueue(compressionPayload(1)); err != nil {
+		t.Error(err)
+	}
+
+	// Cut off the end of the compressed value out of band.
+	data, err := q.db.Get(idToKey(1), nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if err = q.db.Put(idToKey(1), data[:len(data)-8], nil); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.Dequeue(); !errors.Is(err, ErrDecompress) {
+		t.Errorf("Expected to get decompress error, got %v", err)
+	}
+}
+
+func BenchmarkQueueEnqueueDequeueCompression(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		c    Compression
+	}{
+		{"None", NoCompression},
+		{"Snappy", Snappy},
+		{"Gzip", Gzip},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			// Open test database
+			file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+			q, err := OpenQueue(file, WithCompression(bc.c))
+			if err != nil {
+				b.Error(err)
+			}
+			defer q.Drop()
+
+			value := compressionPayload(1)
+
+			// Start benchmark
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(value)))
+
+			var stored int
+			for n := 0; n < b.N; n++ {
+				item, err := q.Enqueue(value)
+				if err != nil {
+					b.Error(err)
+				}
+				if n == 0 {
+					data, _ := q.db.Get(item.Key, nil)
+					stored = len(data)
+				}
+				_, _ = q.Dequeue()
+			}
+
+			b.ReportMetric(float64(stored), "stored-B")
+			b.ReportMetric(float64(stored)/float64(len(value)), "ratio")
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package goque
+
+import (
+	"fmt"
+)
+
+// Cursor is a position within a queue, used to page through its items
+// from head to tail without holding an iterator or lock between pages.
+// A cursor is not safe for concurrent use.
+type Cursor struct {
+	q    *Queue
+	last uint64
+}
+
+// NewCursor returns a cursor positioned at the head of the queue.
+func (q *Queue) NewCursor() *Cursor {
+	return &Cursor{q: q}
+}
+
+// CursorAt returns a cursor positioned after the item with the given ID,
+// such as the Position of an earlier cursor, so that paging continues
+// with the next item. The item itself need not still be in the queue.
+func (q *Queue) CursorAt(id uint64) *Cursor {
+	return &Cursor{q: q, last: id}
+}
+
+// Position returns the ID of the last item returned by the cursor, or
+// the ID the cursor was created at. It can be stored and passed to
+// CursorAt to resume paging later.
+func (c *Cursor) Position() uint64 {
+	return c.last
+}
+
+// Next returns up to limit items following the position of the cursor,
+// without removing them, and advances the cursor past them. Items that
+// were removed from the queue since the last page are skipped, and
+// items added to the tail are returned by later pages. Once the cursor
+// has reached the tail, an empty slice is returned.
+func (c *Cursor) Next(limit int) ([]*Item, error) {
+	q := c.q
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Start after the cursor or the head, whichever is further.
+	start := c.last
+	if start < q.head {
+		start = q.head
+	}
+	if limit <= 0 || start >= q.tail {
+		return []*Item{}, nil
+	}
+
+	// Iterate over the items from the start.
+	iter := q.db.NewIterator(q.rangeFrom(start+1), nil)
+	defer iter.Release()
+
+	items := make([]*Item, 0, limit)
+	for len(items) < limit && iter.Next() {
+		item := q.newItemFromIterator(iter)
+		if item.ID > q.tail {
+			break
+		}
+		if err := checkItem(item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	if len(items) > 0 {
+		c.last = items[len(items)-1].ID
+	}
+
+	return items, nil
+}
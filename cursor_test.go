@@ -0,0 +1,108 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCursorNext(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	c := q.NewCursor()
+	items, err := c.Next(4)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(items) != 4 {
+		t.Errorf("Expected 4 items, got %d", len(items))
+	}
+
+	if c.Position() != 4 {
+		t.Errorf("Expected position to be 4, got %d", c.Position())
+	}
+
+	// Items removed between pages are skipped.
+	if _, err = q.DequeueByID(5); err != nil {
+		t.Error(err)
+	}
+
+	items, err = q.CursorAt(c.Position()).Next(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i, id := range []uint64{6, 7, 8} {
+		if items[i].ID != id {
+			t.Errorf("Expected item ID to be %d, got %d", id, items[i].ID)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", id)
+		if items[i].ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, items[i].ToString())
+		}
+	}
+}
+
+func TestCursorNextEnd(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// A cursor behind the head starts at the head.
+	for i := 1; i <= 2; i++ {
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	c := q.CursorAt(1)
+	items, err := c.Next(10)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(items) != 3 || items[0].ID != 3 {
+		t.Errorf("Expected 3 items from ID 3, got %d", len(items))
+	}
+
+	// The cursor has reached the tail.
+	if items, err = c.Next(10); err != nil || len(items) != 0 {
+		t.Errorf("Expected no items, got %d and %v", len(items), err)
+	}
+
+	// Items added to the tail are returned by the next page.
+	if _, err = q.EnqueueString("value for item 6"); err != nil {
+		t.Error(err)
+	}
+
+	if items, err = c.Next(10); err != nil || len(items) != 1 {
+		t.Errorf("Expected 1 item, got %d and %v", len(items), err)
+	}
+
+	q.Close()
+	if _, err = c.Next(10); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package goque
 
 import (
 	"errors"
+	"fmt"
 
 	ldberrors "github.com/syndtr/goleveldb/leveldb/errors"
 )
@@ -22,10 +23,103 @@ var (
 	// been called, causing the stack or queue to close, as well as
 	// its underlying database.
 	ErrDBClosed = errors.New("goque: Database is closed")
+
+	// ErrClosing is returned when an item is added to a queue that is
+	// being shut down using Shutdown.
+	ErrClosing = errors.New("goque: Queue is closing")
+
+	// ErrTxnDone is returned when a transaction is used after it was
+	// committed or rolled back.
+	ErrTxnDone = errors.New("goque: Transaction has already been committed or rolled back")
+
+	// ErrFull is returned when an item is added to a queue that
+	// already holds its maximum number of items.
+	ErrFull = errors.New("goque: Queue is full")
+
+	// ErrValueTooLarge is returned when a value added to a queue is
+	// larger than the maximum value size of the queue.
+	ErrValueTooLarge = errors.New("goque: Value is too large")
+
+	// ErrIDExhausted is returned when an item is added to a queue
+	// whose tail has reached the largest possible ID.
+	ErrIDExhausted = errors.New("goque: No IDs left for new items")
+
+	// ErrNotEmpty is returned when an operation that requires an
+	// empty queue is used on a queue that holds items.
+	ErrNotEmpty = errors.New("goque: Queue is not empty")
+
+	// ErrNotFound is returned by FindFunc when no item in the queue
+	// matches.
+	ErrNotFound = errors.New("goque: No matching item found")
+
+	// ErrInvalidExport is returned when the data given to Import
+	// is not a valid queue export.
+	ErrInvalidExport = errors.New("goque: Invalid queue export")
+
+	// ErrInvalidBackup is returned when the data given to
+	// RestoreBackup is not a complete queue backup.
+	ErrInvalidBackup = errors.New("goque: Invalid queue backup")
+
+	// ErrSameQueue is returned when an operation between two
+	// queues is given the same queue twice.
+	ErrSameQueue = errors.New("goque: Source and destination queue are the same")
+
+	// ErrMaxReceives is returned when a queue is opened using
+	// WithDeadLetter with a maximum number of receives of 0.
+	ErrMaxReceives = errors.New("goque: Maximum number of receives must be at least 1")
+
+	// ErrPriorities is returned when an operation needs a queue
+	// opened using WithPriorities and the queue was opened without
+	// it, or the operation is not supported by a queue using
+	// priorities.
+	ErrPriorities = errors.New("goque: Operation does not match the priority mode of the queue")
+
+	// ErrNilObject is returned when a nil value is passed to one of
+	// the object helper functions, as there is nothing to encode.
+	ErrNilObject = errors.New("goque: Cannot encode nil object")
+
+	// ErrExists is returned when the destination directory of a
+	// copy of a queue already exists.
+	ErrExists = errors.New("goque: Destination already exists")
+
+	// ErrKeyNamespaces is returned when a queue opened using
+	// WithKeyNamespaces holds keys that sort after the start of the
+	// namespaces, so they cannot be moved into them.
+	ErrKeyNamespaces = errors.New("goque: Keys cannot be moved into namespaces")
+
+	// ErrNotInFlight is returned when an item acknowledged using Ack
+	// is not in flight.
+	ErrNotInFlight = errors.New("goque: Item is not in flight")
+
+	// ErrReadOnly is returned when an operation that modifies a
+	// queue is used on a queue opened read-only.
+	ErrReadOnly = errors.New("goque: Queue is read-only")
+
+	// ErrChecksum is returned when the checksum stored with an item
+	// of a queue opened using WithChecksums does not match its value.
+	ErrChecksum = errors.New("goque: Item checksum mismatch")
+
+	// ErrDecompress is returned when the compressed value of an item
+	// cannot be decompressed.
+	ErrDecompress = errors.New("goque: Cannot decompress item value")
+
+	// ErrStaleToken is returned when the items of a DeleteToken
+	// passed to Commit are no longer at the head of the queue.
+	ErrStaleToken = errors.New("goque: Items are no longer at the head of the queue")
+
+	// ErrCorrupt is returned when an item that should be in a queue
+	// is missing from its database, such as after disk corruption or
+	// an external modification. It wraps the LevelDB not found error.
+	ErrCorrupt = fmt.Errorf("goque: Item is missing from database: %w", ldberrors.ErrNotFound)
 )
 
 // IsCorrupted returns a boolean indicating whether the error is indicating
-// a corruption.
+// a corruption, including errors wrapping a corruption error.
 func IsCorrupted(err error) bool {
-	return ldberrors.IsCorrupted(err)
+	for ; err != nil; err = errors.Unwrap(err) {
+		if ldberrors.IsCorrupted(err) {
+			return true
+		}
+	}
+	return false
 }
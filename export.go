@@ -0,0 +1,261 @@
+package goque
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+// exportMagic marks the start of a queue export.
+var exportMagic = []byte("GOQUEXP")
+
+// exportVersion is the version of the export format written by Export.
+const exportVersion byte = 1
+
+// importBatchSize is the number of items Import writes per batch.
+const importBatchSize = 1000
+
+// Export writes the contents of the queue to w, in order from head to
+// tail, without removing them. The output can be read back into an
+// empty queue using Import.
+//
+// The format starts with the magic bytes "GOQUEXP" and a version byte,
+// followed by each item as its big-endian 8 byte ID, the uvarint length
+// of its stored value, and the stored value itself.
+//
+// Like ForEach, Export holds the read lock while writing, unless the
+// queue was opened using WithScanChunk.
+func (q *Queue) Export(w io.Writer) error {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Write the header.
+	bw := bufio.NewWriter(w)
+	bw.Write(exportMagic)
+	bw.WriteByte(exportVersion)
+
+	// Write each item from the head.
+	var buf [binary.MaxVarintLen64]byte
+	err := q.scanItems(func(iter iterator.Iterator) error {
+		bw.Write(idToKey(q.keyID(iter.Key())))
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(iter.Value())))])
+		_, err := bw.Write(iter.Value())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// CopyTo writes the contents of the queue into a new queue created at
+// dstDir, keeping the IDs and order of the items, without removing them.
+// Only the items themselves are copied, not the files of the underlying
+// database, so the copy does not hold any of the removed items that
+// LevelDB has yet to compact away. If dstDir already exists, ErrExists
+// is returned.
+//
+// The copy is closed once written, so it can be opened using OpenQueue.
+// If CopyTo fails, the copy is removed again.
+func (q *Queue) CopyTo(dstDir string) error {
+	// Check if the destination already exists.
+	if _, err := os.Stat(dstDir); err == nil {
+		return ErrExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	dst, err := OpenQueue(dstDir)
+	if err != nil {
+		return err
+	}
+	if err := q.copyItems(dst); err != nil {
+		if err := dst.Drop(); err != nil {
+			q.log(LogError, "drop copy", "dataDir", dstDir, "error", err)
+		}
+		return err
+	}
+
+	return dst.Close()
+}
+
+// copyItems writes the items of the queue into the empty queue dst in
+// batches. The caller must hold the read lock.
+func (q *Queue) copyItems(dst *Queue) error {
+	// Add each item from the head to a batch, along with the state
+	// of the copy so far.
+	var state queueState
+	batch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		id := q.keyID(iter.Key())
+		if id > q.tail {
+			break
+		}
+		batch.Put(dst.key(id), iter.Value())
+		h, value := decodeValue(iter.Value())
+		if h.unique {
+			batch.Put(dst.uniqueKey(value), nil)
+		}
+		if state.count == 0 {
+			state.head = id - 1
+		}
+		state.tail = id
+		state.count++
+		state.size += uint64(len(value))
+
+		// Write a full batch.
+		if batch.Len() >= importBatchSize {
+			dst.putState(batch, state)
+			if err := dst.db.Write(batch, dst.writeOptions); err != nil {
+				return fmt.Errorf("goque: write batch: %w", err)
+			}
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Write the remaining items.
+	if batch.Len() > 0 {
+		dst.putState(batch, state)
+		if err := dst.db.Write(batch, dst.writeOptions); err != nil {
+			return fmt.Errorf("goque: write batch: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Import reads items written by Export from r and adds them to the
+// queue, keeping their original IDs, including any gaps, and order. The queue must be
+// empty, otherwise ErrNotEmpty is returned. If r does not hold a valid
+// export, ErrInvalidExport is returned.
+//
+// Items are written in batches, so if Import fails part way through,
+// the items read up to that point may remain in the queue.
+func (q *Queue) Import(r io.Reader) error {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Check if queue is read-only or closing.
+	if q.readOnly {
+		return ErrReadOnly
+	} else if q.closing {
+		return ErrClosing
+	}
+
+	// Check if empty.
+	if q.length() != 0 {
+		return ErrNotEmpty
+	}
+
+	// Read the header.
+	br := bufio.NewReader(r)
+	header := make([]byte, len(exportMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return ErrInvalidExport
+	}
+	if !bytes.Equal(header[:len(exportMagic)], exportMagic) || header[len(exportMagic)] != exportVersion {
+		return ErrInvalidExport
+	}
+
+	// Read each item and add it to a batch.
+	batch := new(leveldb.Batch)
+	head, tail, count, size := q.head, q.tail, q.count, q.size
+	key := make([]byte, 8)
+	for first := true; ; first = false {
+		// Read the item ID.
+		if _, err := io.ReadFull(br, key); err == io.EOF {
+			break
+		} else if err != nil {
+			return ErrInvalidExport
+		}
+		id := keyToID(key)
+		if id == 0 {
+			return ErrInvalidExport
+		}
+
+		// Items must be in ascending order.
+		if first {
+			head, tail = id-1, id-1
+		}
+		if id <= tail {
+			return ErrInvalidExport
+		}
+
+		// Read the item value.
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrInvalidExport
+		}
+		value := make([]byte, n)
+		if _, err := io.ReadFull(br, value); err != nil {
+			return ErrInvalidExport
+		}
+		batch.Put(q.key(id), value)
+		tail = id
+		count++
+		h, decoded := decodeValue(value)
+		size += uint64(len(decoded))
+		if h.unique {
+			batch.Put(q.uniqueKey(decoded), nil)
+		}
+
+		// Write a full batch.
+		if batch.Len() >= importBatchSize {
+			q.putState(batch, queueState{head: head, tail: tail, count: count, size: size})
+			if err := q.db.Write(batch, q.writeOptions); err != nil {
+				return fmt.Errorf("goque: write batch: %w", err)
+			}
+			batch.Reset()
+			q.head, q.tail, q.count, q.size = head, tail, count, size
+		}
+	}
+
+	// Write the remaining items.
+	if batch.Len() > 0 {
+		q.putState(batch, queueState{head: head, tail: tail, count: count, size: size})
+		if err := q.db.Write(batch, q.writeOptions); err != nil {
+			return fmt.Errorf("goque: write batch: %w", err)
+		}
+		q.head, q.tail, q.count, q.size = head, tail, count, size
+	}
+	q.epoch++
+	if err := q.initPriorities(); err != nil {
+		return err
+	}
+
+	// Wake any goroutines waiting for an item.
+	q.broadcast()
+
+	return nil
+}
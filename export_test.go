@@ -0,0 +1,155 @@
+package goque
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueExportImport(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = q.Export(&buf); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 7 {
+		t.Errorf("Expected queue length of 7, got %d", q.Length())
+	}
+
+	// Importing into a non-empty queue should fail.
+	if err = q.Import(bytes.NewReader(buf.Bytes())); err != ErrNotEmpty {
+		t.Errorf("Expected to get not empty error, got %v", err)
+	}
+
+	file2 := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q2, err := OpenQueue(file2)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q2.Drop()
+
+	if err = q2.Import(&buf); err != nil {
+		t.Error(err)
+	}
+
+	if q2.Length() != 7 {
+		t.Errorf("Expected queue length of 7, got %d", q2.Length())
+	}
+
+	for i := 4; i <= 10; i++ {
+		deqItem, err := q2.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if deqItem.ID != uint64(i) || deqItem.ToString() != compStr {
+			t.Errorf("Expected item %d to be '%s', got item %d '%s'", i, compStr, deqItem.ID, deqItem.ToString())
+		}
+	}
+}
+
+func TestQueueImportInvalid(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if err = q.Import(bytes.NewReader([]byte("not an export"))); err != ErrInvalidExport {
+		t.Errorf("Expected to get invalid export error, got %v", err)
+	}
+
+	// A newer format version should be rejected.
+	data := append(append([]byte(nil), exportMagic...), exportVersion+1)
+	if err = q.Import(bytes.NewReader(data)); err != ErrInvalidExport {
+		t.Errorf("Expected to get invalid export error, got %v", err)
+	}
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
+}
+
+func TestQueueCopyTo(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	file2 := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	if err = q.CopyTo(file2); err != nil {
+		t.Error(err)
+	}
+
+	// Copying onto an existing directory should fail.
+	if err = q.CopyTo(file2); err != ErrExists {
+		t.Errorf("Expected to get exists error, got %v", err)
+	}
+
+	if q.Length() != 7 {
+		t.Errorf("Expected queue length of 7, got %d", q.Length())
+	}
+
+	q2, err := OpenQueue(file2)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q2.Drop()
+
+	if q2.Length() != 7 {
+		t.Errorf("Expected queue length of 7, got %d", q2.Length())
+	}
+
+	for i := 4; i <= 10; i++ {
+		deqItem, err := q2.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if deqItem.ID != uint64(i) {
+			t.Errorf("Expected item ID to be %d, got %d", i, deqItem.ID)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+}
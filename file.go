@@ -6,6 +6,7 @@ import (
 
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 )
 
 // goqueType defines the type of Goque data structure used.
@@ -18,12 +19,19 @@ const (
 	goqueQueue
 	goquePriorityQueue
 	goquePrefixQueue
+	goqueQueueGroup
 )
 
 // levelDbOpener is a function type matching both
 // leveldb.OpenFile() and leveldb.Recover().
 type levelDbOpener func(string, *opt.Options) (*leveldb.DB, error)
 
+// openMemDB is a levelDbOpener that opens a new database backed by
+// in-memory storage. The given path is ignored.
+func openMemDB(_ string, o *opt.Options) (*leveldb.DB, error) {
+	return leveldb.Open(storage.NewMemStorage(), o)
+}
+
 // checkGoqueType checks if the type of Goque data structure
 // trying to be opened is compatible with the opener type.
 //
@@ -0,0 +1,127 @@
+// Package goqueprom reports the metrics of a Goque queue to Prometheus.
+//
+// It is kept apart from package goque so that only programs importing it
+// depend on the Prometheus client library. To report the metrics of a
+// queue, register a collector for it, naming each queue differently:
+//
+//	q, err := goque.OpenQueue("data_dir")
+//	...
+//	prometheus.MustRegister(goqueprom.Collector(q, "myapp", "jobs"))
+package goqueprom
+
+import (
+	"context"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector is the prometheus.Collector returned by Collector.
+type collector struct {
+	q *goque.Queue
+
+	length    *prometheus.Desc
+	sizeBytes *prometheus.Desc
+	enqueued  prometheus.Counter
+	dequeued  prometheus.Counter
+	errors    *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+}
+
+// Collector returns a prometheus.Collector reporting the metrics of the
+// queue under the given namespace, with a constant "queue" label set to
+// name, so that collectors of several queues can be registered
+// together. It reports:
+//
+//   - <namespace>_queue_length, the length of the queue,
+//   - <namespace>_queue_size_bytes, the size of the values in the queue,
+//   - <namespace>_queue_enqueued_total and <namespace>_queue_dequeued_total,
+//     the number of items added to and removed from the queue,
+//   - <namespace>_queue_operation_duration_seconds, a histogram of the
+//     duration of Enqueue, Dequeue and Peek by "op" label,
+//   - <namespace>_queue_operation_errors_total, the number of these
+//     operations that failed by "op" label, not counting ErrEmpty.
+//
+// The length and size are read from the queue when the metrics are
+// collected. The counters and histogram are updated from the hooks and
+// tracer of the queue, which Collector sets, replacing any set before.
+func Collector(q *goque.Queue, namespace, name string) prometheus.Collector {
+	labels := prometheus.Labels{"queue": name}
+	c := &collector{
+		q: q,
+		length: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "length"),
+			"Number of items in the queue.",
+			nil, labels,
+		),
+		sizeBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "size_bytes"),
+			"Total size of the values of the items in the queue.",
+			nil, labels,
+		),
+		enqueued: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "queue",
+			Name:        "enqueued_total",
+			Help:        "Number of items added to the queue.",
+			ConstLabels: labels,
+		}),
+		dequeued: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "queue",
+			Name:        "dequeued_total",
+			Help:        "Number of items removed from the queue.",
+			ConstLabels: labels,
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "queue",
+			Name:        "operation_errors_total",
+			Help:        "Number of failed queue operations.",
+			ConstLabels: labels,
+		}, []string{"op"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   "queue",
+			Name:        "operation_duration_seconds",
+			Help:        "Duration of queue operations.",
+			ConstLabels: labels,
+		}, []string{"op"}),
+	}
+
+	q.SetHooks(func(*goque.Item) { c.enqueued.Inc() }, func(*goque.Item) { c.dequeued.Inc() })
+	q.SetTracer(c.trace)
+	return c
+}
+
+// trace is the tracer of the queue, which times each operation.
+func (c *collector) trace(ctx context.Context, op, dataDir string) func(uint64, error) {
+	start := time.Now()
+	return func(id uint64, err error) {
+		c.duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+		if err != nil && err != goque.ErrEmpty {
+			c.errors.WithLabelValues(op).Inc()
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.length
+	ch <- c.sizeBytes
+	c.enqueued.Describe(ch)
+	c.dequeued.Describe(ch)
+	c.errors.Describe(ch)
+	c.duration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.length, prometheus.GaugeValue, float64(c.q.Length()))
+	ch <- prometheus.MustNewConstMetric(c.sizeBytes, prometheus.GaugeValue, float64(c.q.SizeBytes()))
+	c.enqueued.Collect(ch)
+	c.dequeued.Collect(ch)
+	c.errors.Collect(ch)
+	c.duration.Collect(ch)
+}
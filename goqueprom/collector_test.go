@@ -0,0 +1,73 @@
+package goqueprom
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beeker1121/goque"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := goque.OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	dst, err := goque.OpenQueue(file + "_dst")
+	if err != nil {
+		t.Error(err)
+	}
+	defer dst.Drop()
+
+	// Collectors of several queues are registered together.
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(Collector(q, "test", "src"), Collector(dst, "test", "dst"))
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+	if _, err = dst.Dequeue(); err != goque.ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	expected := `
+# HELP test_queue_dequeued_total Number of items removed from the queue.
+# TYPE test_queue_dequeued_total counter
+test_queue_dequeued_total{queue="dst"} 0
+test_queue_dequeued_total{queue="src"} 1
+# HELP test_queue_enqueued_total Number of items added to the queue.
+# TYPE test_queue_enqueued_total counter
+test_queue_enqueued_total{queue="dst"} 0
+test_queue_enqueued_total{queue="src"} 3
+# HELP test_queue_length Number of items in the queue.
+# TYPE test_queue_length gauge
+test_queue_length{queue="dst"} 0
+test_queue_length{queue="src"} 2
+`
+	if err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "test_queue_dequeued_total", "test_queue_enqueued_total", "test_queue_length"); err != nil {
+		t.Error(err)
+	}
+
+	// Each operation is timed, and an empty queue is not an error.
+	if n, err := testutil.GatherAndCount(reg, "test_queue_operation_duration_seconds"); err != nil {
+		t.Error(err)
+	} else if n != 3 {
+		t.Errorf("Expected 3 histograms, got %d", n)
+	}
+	if n, err := testutil.GatherAndCount(reg, "test_queue_operation_errors_total"); err != nil {
+		t.Error(err)
+	} else if n != 0 {
+		t.Errorf("Expected no errors, got %d", n)
+	}
+}
@@ -0,0 +1,126 @@
+package goque
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// QueueGroup holds any number of named queues within a single LevelDB
+// database, sharing its file handles and block cache. Each queue is
+// stored under a key prefix derived from its name, and otherwise works
+// like a queue created using NewQueueFromDB.
+type QueueGroup struct {
+	sync.Mutex
+	DataDir string
+	db      *leveldb.DB
+	isOpen  bool
+
+	// queues holds the queues handed out by Queue, by name.
+	queues map[string]*Queue
+
+	// opts are the options applied to each queue.
+	opts []QueueOption
+
+	// shared is true if the database is not owned by the group.
+	shared bool
+}
+
+// OpenQueueGroup opens a queue group if one exists at the given
+// directory. If one does not already exist, a new queue group is
+// created. The given options are applied to each queue of the group.
+func OpenQueueGroup(dataDir string, opts ...QueueOption) (*QueueGroup, error) {
+	db, err := leveldb.OpenFile(dataDir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if this Goque type can open the requested data directory.
+	ok, err := checkGoqueType(dataDir, goqueQueueGroup)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if !ok {
+		db.Close()
+		return nil, ErrIncompatibleType
+	}
+
+	g := NewQueueGroup(db, opts...)
+	g.DataDir = dataDir
+	g.shared = false
+	return g, nil
+}
+
+// NewQueueGroup returns a queue group stored in the given LevelDB
+// database, which is managed by the caller. Closing the group does not
+// close the database.
+func NewQueueGroup(db *leveldb.DB, opts ...QueueOption) *QueueGroup {
+	return &QueueGroup{
+		db:     db,
+		isOpen: true,
+		queues: make(map[string]*Queue),
+		opts:   opts,
+		shared: true,
+	}
+}
+
+// Queue returns the queue with the given name, creating it if it does
+// not exist yet. Calls with the same name return the same queue, which
+// is reopened if it was closed or dropped.
+func (g *QueueGroup) Queue(name string) (*Queue, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	// Check if group is closed.
+	if !g.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Reuse the queue if it was handed out before.
+	if q, ok := g.queues[name]; ok {
+		return q, q.Open()
+	}
+
+	q, err := NewQueueFromDB(g.db, groupPrefix(name), g.opts...)
+	if err != nil {
+		return nil, err
+	}
+	g.queues[name] = q
+	return q, nil
+}
+
+// Close closes each queue of the group and then the LevelDB database,
+// unless it is managed by the caller. Any error closing the database is
+// returned. The group is closed either way.
+func (g *QueueGroup) Close() error {
+	g.Lock()
+	defer g.Unlock()
+
+	// Check if group is already closed.
+	if !g.isOpen {
+		return nil
+	}
+
+	// The queues share the database, so closing them cannot fail.
+	for _, q := range g.queues {
+		q.Close()
+	}
+	g.isOpen = false
+
+	// Close the LevelDB database, unless it is shared.
+	if g.shared {
+		return nil
+	}
+	return g.db.Close()
+}
+
+// groupPrefix returns the key prefix of the queue with the given name.
+// The name is preceded by its length, so that the prefix of one name is
+// never a prefix of another.
+func groupPrefix(name string) []byte {
+	prefix := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(name))
+	prefix = prefix[:binary.PutUvarint(prefix, uint64(len(name)))]
+	return append(prefix, name...)
+}
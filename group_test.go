@@ -0,0 +1,90 @@
+package goque
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestQueueGroup(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	g, err := OpenQueueGroup(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(file)
+
+	names := []string{"a", "ab", "b"}
+	queues := make([]*Queue, len(names))
+	for i, name := range names {
+		if queues[i], err = g.Queue(name); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for i, q := range queues {
+		for j := 1; j <= 5*(i+1); j++ {
+			if _, err = q.EnqueueString(fmt.Sprintf("value for %s item %d", names[i], j)); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	// Each queue holds only its own items.
+	for i, q := range queues {
+		if q.Length() != uint64(5*(i+1)) {
+			t.Errorf("Expected queue length of %d, got %d", 5*(i+1), q.Length())
+		}
+
+		deqItem, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for %s item 1", names[i])
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+
+	// Dropping one queue leaves the others.
+	if err = queues[1].Drop(); err != nil {
+		t.Error(err)
+	}
+
+	if queues[0].Length() != 4 || queues[2].Length() != 14 {
+		t.Errorf("Expected queue lengths of 4 and 14, got %d and %d", queues[0].Length(), queues[2].Length())
+	}
+
+	q, err := g.Queue("ab")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
+
+	// The queues are read back when the group is reopened.
+	if err = g.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = queues[0].Dequeue(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+
+	if g, err = OpenQueueGroup(file); err != nil {
+		t.Error(err)
+	}
+	defer g.Close()
+
+	if q, err = g.Queue("b"); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 14 {
+		t.Errorf("Expected queue length of 14, got %d", q.Length())
+	}
+}
@@ -0,0 +1,104 @@
+package goque
+
+import (
+	"time"
+)
+
+// cachedHead is a copy of the item at the head of a queue, which Peek
+// returns without taking any lock for as long as the item is valid.
+type cachedHead struct {
+	item *Item
+
+	// validUntil is the time the item expires or an in-flight item
+	// is due to go back into the queue, whichever is first, or the
+	// zero time if neither.
+	validUntil time.Time
+}
+
+// Lock takes the write lock of the queue. As the holder of the write
+// lock may change or remove any item, this also drops the head item
+// cached for Peek and the items prefetched for Dequeue.
+func (q *Queue) Lock() {
+	q.RWMutex.Lock()
+	q.dropHead()
+	q.dropPrefetched()
+}
+
+// dropHead drops the cached head item. The caller must hold the write
+// lock, or the lock of the head before changing it.
+func (q *Queue) dropHead() {
+	if c, _ := q.headCache.Load().(*cachedHead); c != nil {
+		q.headCache.Store((*cachedHead)(nil))
+	}
+}
+
+// cacheHead caches a copy of the given item at the head of the queue,
+// which was ready at the given time. The caller must hold the read lock
+// along with the locks of the head and tail, so that the head cannot
+// change while the item is cached.
+func (q *Queue) cacheHead(item *Item, now time.Time) {
+	c := &cachedHead{item: item.copy(), validUntil: item.ExpiresAt}
+	c.item.pooled = false
+	if !q.nextDeadline.IsZero() && (c.validUntil.IsZero() || q.nextDeadline.Before(c.validUntil)) {
+		c.validUntil = q.nextDeadline
+	}
+	if c.validUntil.IsZero() || now.Before(c.validUntil) {
+		q.headCache.Store(c)
+	}
+}
+
+// loadHead returns a copy of the cached head item, or nil if no item is
+// cached or it is no longer valid at the given time. It takes no lock.
+func (q *Queue) loadHead(now time.Time) *Item {
+	c, _ := q.headCache.Load().(*cachedHead)
+	if c == nil || !c.validUntil.IsZero() && !now.Before(c.validUntil) {
+		return nil
+	}
+	return q.copyHead(c)
+}
+
+// takeHead drops the cached head item and returns a copy of it, or nil
+// if no item is cached, so that Dequeue can remove the item at the head
+// without reading it again. The caller must hold the lock of the head.
+func (q *Queue) takeHead() *Item {
+	c, _ := q.headCache.Load().(*cachedHead)
+	if c == nil {
+		return nil
+	}
+	q.headCache.Store((*cachedHead)(nil))
+	if c.item.ID != q.head+1 {
+		return nil
+	}
+	return q.copyHead(c)
+}
+
+// copyHead returns a copy of the given cached head item, which Peek may
+// be copying at the same time, so it must not be handed out itself.
+func (q *Queue) copyHead(c *cachedHead) *Item {
+	// Items of a queue with an item pool come from the pool.
+	if q.pool == nil {
+		return c.item.copy()
+	}
+	item := q.pool.Get().(*Item)
+	key, value := item.Key, item.Value
+	*item = *c.item
+	item.Key = append(key[:0], c.item.Key...)
+	item.Value = append(value[:0], c.item.Value...)
+	item.pooled = true
+	return item
+}
+
+// unpackHead decompresses the value of the given copy of the cached head
+// item, if it is still packed, and caches the decompressed copy in its
+// place so that later calls to Peek do not decompress it again. It takes
+// no lock and returns the item or the error decompressing it.
+func (q *Queue) unpackHead(item *Item) (*Item, error) {
+	packed := item.packed
+	if err := item.unpack(); err != nil {
+		return nil, err
+	}
+	if c, _ := q.headCache.Load().(*cachedHead); c != nil && len(packed) > 0 && len(c.item.packed) > 0 && &c.item.packed[0] == &packed[0] {
+		q.headCache.CompareAndSwap(c, &cachedHead{item: item.copy(), validUntil: c.validUntil})
+	}
+	return item, nil
+}
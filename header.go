@@ -0,0 +1,217 @@
+package goque
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+// itemHeaderMagic marks the start of an item header within a stored
+// value. It begins with a null byte, which neither gob, JSON nor text
+// values start with, so values stored without a header are left as is.
+//
+// Values that start with the magic bytes themselves are stored behind
+// an empty header since item headers were added. Values stored before
+// that by older versions of Goque are not, so a binary value starting
+// with the magic bytes that was stored by an older version is read as
+// a header, and its first bytes are lost or it reads as corrupt.
+var itemHeaderMagic = []byte{0x00, 'G', 'Q', 0x01}
+
+// The flags of an item header, indicating which fields follow it.
+const (
+	headerExpiresAt byte = 1 << iota
+	headerEnqueuedAt
+	headerNotBefore
+	headerUnique
+	headerReceives
+	headerChecksum
+	headerCompressed
+)
+
+// headerKnownFlags holds all of the item header flags understood by
+// this version of Goque.
+const headerKnownFlags = headerExpiresAt | headerEnqueuedAt | headerNotBefore |
+	headerUnique | headerReceives | headerChecksum | headerCompressed
+
+// itemHeader holds the metadata stored in front of an item value.
+//
+// The stored layout is the magic bytes, a flags byte, and then each
+// field marked in the flags, in the order of the flag bits. Times are
+// stored as big-endian Unix nanoseconds and counts as big-endian 8 byte
+// integers. Flags without a field, such as headerUnique, only mark the
+// item.
+//
+// The checksum is the CRC-32 of the whole stored value, leaving out the
+// checksum field itself, stored in the lower 4 bytes of its field. As
+// it is the last field, it also covers the header fields before it.
+//
+// A compressed value starts with a byte holding the Compression it was
+// compressed with, followed by the compressed bytes.
+type itemHeader struct {
+	expiresAt  time.Time
+	enqueuedAt time.Time
+	notBefore  time.Time
+	unique     bool
+	receives   uint64
+
+	// checksum is whether the stored value has a checksum, and
+	// compressed whether the value is compressed.
+	checksum   bool
+	compressed bool
+
+	// err is set if the stored value could not be decoded, such as
+	// when its checksum does not match.
+	err error
+}
+
+// flags returns the flags for the fields set in the header.
+func (h itemHeader) flags() byte {
+	var flags byte
+	if !h.expiresAt.IsZero() {
+		flags |= headerExpiresAt
+	}
+	if !h.enqueuedAt.IsZero() {
+		flags |= headerEnqueuedAt
+	}
+	if !h.notBefore.IsZero() {
+		flags |= headerNotBefore
+	}
+	if h.unique {
+		flags |= headerUnique
+	}
+	if h.receives != 0 {
+		flags |= headerReceives
+	}
+	if h.checksum {
+		flags |= headerChecksum
+	}
+	if h.compressed {
+		flags |= headerCompressed
+	}
+	return flags
+}
+
+// encode returns the given value prefixed with the header. A value
+// for a compressed header must already be compressed.
+//
+// If the header has no fields set, the value is returned unchanged so
+// that the stored format stays the same for items without metadata.
+// The exception is a value that itself starts with the magic bytes,
+// which gets an empty header so it is not mistaken for one.
+func (h itemHeader) encode(value []byte) []byte {
+	flags := h.flags()
+	if flags == 0 && !bytes.HasPrefix(value, itemHeaderMagic) {
+		return value
+	}
+
+	// Write the magic bytes and flags.
+	buf := make([]byte, 0, len(itemHeaderMagic)+1+40+len(value))
+	buf = append(buf, itemHeaderMagic...)
+	buf = append(buf, flags)
+
+	// Write the header fields.
+	var field [8]byte
+	if flags&headerExpiresAt != 0 {
+		binary.BigEndian.PutUint64(field[:], uint64(h.expiresAt.UnixNano()))
+		buf = append(buf, field[:]...)
+	}
+	if flags&headerEnqueuedAt != 0 {
+		binary.BigEndian.PutUint64(field[:], uint64(h.enqueuedAt.UnixNano()))
+		buf = append(buf, field[:]...)
+	}
+	if flags&headerNotBefore != 0 {
+		binary.BigEndian.PutUint64(field[:], uint64(h.notBefore.UnixNano()))
+		buf = append(buf, field[:]...)
+	}
+	if flags&headerReceives != 0 {
+		binary.BigEndian.PutUint64(field[:], h.receives)
+		buf = append(buf, field[:]...)
+	}
+	if flags&headerChecksum != 0 {
+		crc := crc32.Update(crc32.ChecksumIEEE(buf), crc32.IEEETable, value)
+		binary.BigEndian.PutUint64(field[:], uint64(crc))
+		buf = append(buf, field[:]...)
+	}
+
+	return append(buf, value...)
+}
+
+// decodeValue splits the given stored value into its header and the
+// item value. Values without a header return an empty header.
+func decodeValue(data []byte) (itemHeader, []byte) {
+	h, rest := decodeHeader(data)
+
+	// Decompress the value.
+	if h.compressed {
+		value, err := decompressValue(rest)
+		if err != nil {
+			h.err = err
+			return h, rest
+		}
+		rest = value
+	}
+
+	return h, rest
+}
+
+// decodeHeader is decodeValue without decompressing the value, which is
+// returned as stored if the header is marked as compressed.
+func decodeHeader(data []byte) (itemHeader, []byte) {
+	var h itemHeader
+
+	// Check for the magic bytes and flags.
+	if len(data) <= len(itemHeaderMagic) || !bytes.HasPrefix(data, itemHeaderMagic) {
+		return h, data
+	}
+	flags := data[len(itemHeaderMagic)]
+	if flags&^headerKnownFlags != 0 {
+		return h, data
+	}
+	rest := data[len(itemHeaderMagic)+1:]
+
+	// Read the header fields.
+	if flags&headerExpiresAt != 0 {
+		if len(rest) < 8 {
+			return itemHeader{}, data
+		}
+		h.expiresAt = time.Unix(0, int64(binary.BigEndian.Uint64(rest)))
+		rest = rest[8:]
+	}
+	if flags&headerEnqueuedAt != 0 {
+		if len(rest) < 8 {
+			return itemHeader{}, data
+		}
+		h.enqueuedAt = time.Unix(0, int64(binary.BigEndian.Uint64(rest)))
+		rest = rest[8:]
+	}
+	if flags&headerNotBefore != 0 {
+		if len(rest) < 8 {
+			return itemHeader{}, data
+		}
+		h.notBefore = time.Unix(0, int64(binary.BigEndian.Uint64(rest)))
+		rest = rest[8:]
+	}
+	if flags&headerReceives != 0 {
+		if len(rest) < 8 {
+			return itemHeader{}, data
+		}
+		h.receives = binary.BigEndian.Uint64(rest)
+		rest = rest[8:]
+	}
+	if flags&headerChecksum != 0 {
+		if len(rest) < 8 {
+			return itemHeader{}, data
+		}
+		crc := crc32.Update(crc32.ChecksumIEEE(data[:len(data)-len(rest)]), crc32.IEEETable, rest[8:])
+		h.checksum = true
+		if binary.BigEndian.Uint64(rest) != uint64(crc) {
+			h.err = ErrChecksum
+		}
+		rest = rest[8:]
+	}
+	h.unique = flags&headerUnique != 0
+	h.compressed = flags&headerCompressed != 0
+
+	return h, rest
+}
@@ -0,0 +1,139 @@
+package goque
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestItemHeaderEncode(t *testing.T) {
+	value := []byte("value for item")
+
+	// Values without metadata are stored unchanged.
+	if data := (itemHeader{}).encode(value); !bytes.Equal(data, value) {
+		t.Errorf("Expected value to be stored unchanged, got %v", data)
+	}
+
+	expiresAt := time.Unix(0, time.Now().UnixNano())
+	h, decoded := decodeValue(itemHeader{expiresAt: expiresAt}.encode(value))
+
+	if !bytes.Equal(decoded, value) {
+		t.Errorf("Expected value to be '%s', got '%s'", value, decoded)
+	}
+
+	if !h.expiresAt.Equal(expiresAt) {
+		t.Errorf("Expected expiry time to be %s, got %s", expiresAt, h.expiresAt)
+	}
+}
+
+func TestItemHeaderMagicValue(t *testing.T) {
+	// A value that happens to start with the magic bytes must still
+	// decode to itself.
+	value := append(append([]byte(nil), itemHeaderMagic...), headerExpiresAt, 1, 2, 3)
+
+	h, decoded := decodeValue(itemHeader{}.encode(value))
+
+	if !bytes.Equal(decoded, value) {
+		t.Errorf("Expected value to be %v, got %v", value, decoded)
+	}
+
+	if !h.expiresAt.IsZero() {
+		t.Errorf("Expected no expiry time, got %s", h.expiresAt)
+	}
+}
+
+func TestItemHeaderLegacyValue(t *testing.T) {
+	value := []byte("legacy value")
+
+	h, decoded := decodeValue(value)
+
+	if !bytes.Equal(decoded, value) {
+		t.Errorf("Expected value to be '%s', got '%s'", value, decoded)
+	}
+
+	if h.flags() != 0 {
+		t.Errorf("Expected an empty header, got flags %b", h.flags())
+	}
+}
+
+func TestItemHeaderEnqueuedAt(t *testing.T) {
+	value := []byte("value for item")
+
+	expiresAt := time.Unix(0, time.Now().Add(time.Hour).UnixNano())
+	enqueuedAt := time.Unix(0, time.Now().UnixNano())
+	h, decoded := decodeValue(itemHeader{expiresAt: expiresAt, enqueuedAt: enqueuedAt}.encode(value))
+
+	if !bytes.Equal(decoded, value) {
+		t.Errorf("Expected value to be '%s', got '%s'", value, decoded)
+	}
+
+	if !h.expiresAt.Equal(expiresAt) {
+		t.Errorf("Expected expiry time to be %s, got %s", expiresAt, h.expiresAt)
+	}
+
+	if !h.enqueuedAt.Equal(enqueuedAt) {
+		t.Errorf("Expected enqueue time to be %s, got %s", enqueuedAt, h.enqueuedAt)
+	}
+}
+
+func TestItemHeaderNotBefore(t *testing.T) {
+	value := []byte("value for item")
+
+	notBefore := time.Unix(0, time.Now().Add(time.Hour).UnixNano())
+	h, decoded := decodeValue(itemHeader{notBefore: notBefore}.encode(value))
+
+	if !bytes.Equal(decoded, value) {
+		t.Errorf("Expected value to be '%s', got '%s'", value, decoded)
+	}
+
+	if !h.notBefore.Equal(notBefore) {
+		t.Errorf("Expected not-before time to be %s, got %s", notBefore, h.notBefore)
+	}
+
+	if !h.expiresAt.IsZero() || !h.enqueuedAt.IsZero() {
+		t.Errorf("Expected no other times, got %s and %s", h.expiresAt, h.enqueuedAt)
+	}
+}
+
+func TestItemHeaderReceives(t *testing.T) {
+	value := []byte("value for item")
+
+	notBefore := time.Unix(0, time.Now().Add(time.Hour).UnixNano())
+	h, decoded := decodeValue(itemHeader{notBefore: notBefore, receives: 3}.encode(value))
+
+	if !bytes.Equal(decoded, value) {
+		t.Errorf("Expected value to be '%s', got '%s'", value, decoded)
+	}
+
+	if h.receives != 3 {
+		t.Errorf("Expected receive count to be 3, got %d", h.receives)
+	}
+
+	if !h.notBefore.Equal(notBefore) {
+		t.Errorf("Expected not-before time to be %s, got %s", notBefore, h.notBefore)
+	}
+}
+
+func TestItemHeaderChecksum(t *testing.T) {
+	value := []byte("value for item")
+
+	data := itemHeader{receives: 2, checksum: true}.encode(value)
+	h, decoded := decodeValue(data)
+
+	if !bytes.Equal(decoded, value) {
+		t.Errorf("Expected value to be '%s', got '%s'", value, decoded)
+	}
+
+	if !h.checksum || h.err != nil {
+		t.Errorf("Expected a matching checksum, got %v and %v", h.checksum, h.err)
+	}
+
+	// Changing either the value or a header field is detected.
+	for _, i := range []int{len(data) - 1, len(itemHeaderMagic) + 1} {
+		changed := append([]byte(nil), data...)
+		changed[i] ^= 0xff
+		if h, _ := decodeValue(changed); h.err != ErrChecksum {
+			t.Errorf("Expected a checksum mismatch after changing byte %d", i)
+		}
+	}
+}
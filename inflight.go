@@ -0,0 +1,498 @@
+package goque
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// inFlightKind follows the metadata prefix of the queue in the keys
+// holding the items received using Receive, which are followed by the
+// receipt of the item.
+const inFlightKind = 'f'
+
+// Receive removes the next item from the queue and returns it, like
+// Dequeue, but keeps it in flight until it is acknowledged using Ack.
+// If it is not acknowledged within the given visibility timeout, the
+// item is put back into the queue to be received again, which gives
+// at-least-once processing.
+//
+// The item is moved out of the queue into a separate set of in-flight
+// keys using a single atomic write, so the head moves past it just as
+// for Dequeue, and in-flight items do not count towards Length. As the
+// in-flight keys are stored in the database, a crash does not lose the
+// items: once the queue is reopened, any item whose visibility timeout
+// has passed is put back.
+//
+// Items are put back lazily, by the next call to Receive, Dequeue or
+// Peek after their timeout. They are given the IDs in front of the head
+// of the queue, so they are received again before the other items, in
+// the order they were first received. If there are no IDs left in front
+// of the head, they are added to the tail instead.
+//
+// If the queue was opened using WithDeadLetter, items that have been
+// received too many times are moved to the dead-letter queue first, or
+// stay in flight if the dead-letter queue cannot take them.
+func (q *Queue) Receive(visibility time.Duration) (*Item, error) {
+	q.moveDeadLetters()
+
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	// Check if queue uses priorities.
+	if q.priorities {
+		return nil, ErrPriorities
+	}
+
+	// Try to get the next item in the queue.
+	item, err := q.nextItem()
+	if err != nil {
+		return nil, err
+	}
+
+	// Move the item to the in-flight keys. Receipts are taken from
+	// the clock, so they stay unique when the queue is reopened.
+	now := time.Now()
+	receipt := uint64(now.UnixNano())
+	if receipt <= q.receipt {
+		receipt = q.receipt + 1
+	}
+	deadline := now.Add(visibility)
+	item.Receives++
+	batch := new(leveldb.Batch)
+	batch.Delete(item.Key)
+	batch.Put(q.inFlightKey(receipt), encodeInFlight(deadline, q.encodeValue(item.header(), item.Value)))
+	q.putState(batch, q.state().remove(item))
+	if err := q.db.Write(batch, q.writeOptions); err != nil {
+		return nil, fmt.Errorf("goque: delete item %d: %w", item.ID, err)
+	}
+	item.receipt = receipt
+
+	// Update the in-flight count and the next deadline.
+	q.receipt = receipt
+	q.inFlight++
+	if q.nextDeadline.IsZero() || deadline.Before(q.nextDeadline) {
+		q.nextDeadline = deadline
+	}
+
+	return item, q.removed(item)
+}
+
+// DefaultLeaseTimeout is the lease timeout of ReceiveHead for a queue
+// opened without WithLeaseTimeout.
+const DefaultLeaseTimeout = 30 * time.Second
+
+// ReceiveHead removes the next item in the queue and returns it along
+// with a function committing it, for a consumer that should be the only
+// one processing the item. Until the item is committed, it is leased:
+// other calls to ReceiveHead and Dequeue get the items after it. If it
+// is not committed within the lease timeout set using WithLeaseTimeout,
+// it goes back into the queue.
+//
+// ReceiveHead is Receive with the lease timeout as visibility timeout,
+// and the commit function is Ack, so leases are kept when the queue is
+// closed and reopened.
+func (q *Queue) ReceiveHead() (*Item, func() error, error) {
+	timeout := q.leaseTimeout
+	if timeout <= 0 {
+		timeout = DefaultLeaseTimeout
+	}
+
+	item, err := q.Receive(timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	return item, func() error { return q.Ack(item) }, nil
+}
+
+// Ack acknowledges an item returned by Receive, removing it for good.
+// ErrNotInFlight is returned if the item was not returned by Receive,
+// has been acknowledged already, or was put back into the queue after
+// its visibility timeout.
+func (q *Queue) Ack(item *Item) error {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	// Check if the item is still in flight.
+	if item.receipt == 0 {
+		return ErrNotInFlight
+	}
+	key := q.inFlightKey(item.receipt)
+	data, err := q.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return ErrNotInFlight
+	} else if err != nil {
+		return fmt.Errorf("goque: get item %d: %w", item.ID, err)
+	}
+
+	// Remove it along with its entry in the index of unique items.
+	batch := new(leveldb.Batch)
+	batch.Delete(key)
+	_, data = decodeInFlight(data)
+	if h, value := decodeValue(data); h.unique {
+		batch.Delete(q.uniqueKey(value))
+	}
+	if err := q.db.Write(batch, q.writeOptions); err != nil {
+		return fmt.Errorf("goque: delete item %d: %w", item.ID, err)
+	}
+	q.inFlight--
+
+	return nil
+}
+
+// Nack gives up an item returned by Receive before its visibility
+// timeout, putting it back into the queue straight away to be received
+// again, or moving it to the dead-letter queue set using WithDeadLetter
+// if it has been received too many times. ErrNotInFlight is returned
+// if the item is no longer in flight, as for Ack.
+func (q *Queue) Nack(item *Item) error {
+	if err := q.nack(item); err != nil {
+		return err
+	}
+	q.moveDeadLetters()
+	return nil
+}
+
+// nack makes the visibility timeout of the given in-flight item pass
+// and puts it back into the queue.
+func (q *Queue) nack(item *Item) error {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	// Check if the item is still in flight.
+	if item.receipt == 0 {
+		return ErrNotInFlight
+	}
+	key := q.inFlightKey(item.receipt)
+	data, err := q.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return ErrNotInFlight
+	} else if err != nil {
+		return fmt.Errorf("goque: get item %d: %w", item.ID, err)
+	}
+
+	// Set its deadline to now.
+	now := time.Now()
+	_, data = decodeInFlight(data)
+	if err := q.db.Put(key, encodeInFlight(now, data), q.writeOptions); err != nil {
+		return fmt.Errorf("goque: put item %d: %w", item.ID, err)
+	}
+	if q.nextDeadline.IsZero() || now.Before(q.nextDeadline) {
+		q.nextDeadline = now
+	}
+
+	return q.requeueDue(now)
+}
+
+// moveDeadLetters moves the in-flight items that have passed their
+// visibility timeout after being received the maximum number of times
+// to the dead-letter queue, logging any error. Items that cannot be
+// moved, such as when the dead-letter queue is full or closed, stay in
+// flight until a later call, so that they do not keep the other items
+// from being received. The caller must not hold the lock.
+func (q *Queue) moveDeadLetters() {
+	if q.deadLetter == nil {
+		return
+	}
+	if err := q.moveDeadLettersTo(q.deadLetter); err != nil {
+		q.logError("move dead letters", fmt.Errorf("goque: move dead letters: %w", err))
+	}
+}
+
+// checkDeadLetter checks the dead-letter queue set using WithDeadLetter,
+// if any.
+func (q *Queue) checkDeadLetter() error {
+	switch {
+	case q.deadLetter == nil:
+		return nil
+	case q.maxReceives == 0:
+		return ErrMaxReceives
+	case q.deadLetter == q:
+		return ErrSameQueue
+	case q.deadLetter.priorities:
+		return ErrPriorities
+	}
+	return nil
+}
+
+// moveDeadLettersTo is moveDeadLetters for the dead-letter queue dst,
+// returning any error. Like TransferTo, the items are first added to
+// dst and only then removed.
+func (q *Queue) moveDeadLettersTo(dst *Queue) error {
+	unlock := lockPair(q, dst)
+	defer unlock()
+
+	// Check if either queue is closed.
+	if !q.isOpen || !dst.isOpen {
+		return ErrDBClosed
+	}
+
+	// Check if either queue is read-only.
+	if q.readOnly || dst.readOnly {
+		return ErrReadOnly
+	}
+
+	// Find the due items, putting back the ones that may be received
+	// again.
+	now := time.Now()
+	if err := q.requeueDue(now); err != nil {
+		return err
+	}
+	if !q.deadPending {
+		return nil
+	}
+
+	// Add the items received too many times to batches for both
+	// queues, starting their receive count over in the destination.
+	var moved, size uint64
+	srcBatch := new(leveldb.Batch)
+	dstBatch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.inFlightRange(), nil)
+	for iter.Next() {
+		deadline, data := decodeInFlight(iter.Value())
+		h, value := decodeValue(data)
+		if now.Before(deadline) || h.receives < q.maxReceives {
+			continue
+		}
+		if math.MaxUint64-dst.tail == moved {
+			iter.Release()
+			return ErrIDExhausted
+		}
+		moved++
+		h.receives = 0
+		dstBatch.Put(dst.key(dst.tail+moved), dst.encodeValue(h, value))
+		srcBatch.Delete(iter.Key())
+		size += uint64(len(value))
+
+		// Move the entries in the index of unique items as well.
+		if h.unique {
+			dstBatch.Put(dst.uniqueKey(value), nil)
+			srcBatch.Delete(q.uniqueKey(value))
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Check if the destination has room for the items.
+	if dst.isFull(moved) {
+		return ErrFull
+	}
+
+	// Add the items to the destination first.
+	dst.putState(dstBatch, dst.state().addItems(moved, size))
+	if err := dst.db.Write(dstBatch, dst.writeOptions); err != nil {
+		return fmt.Errorf("goque: write batch: %w", err)
+	}
+	dst.tail += moved
+	dst.count += moved
+	dst.size += size
+	dst.broadcast()
+
+	// Then remove them from the in-flight items.
+	if err := q.db.Write(srcBatch, q.writeOptions); err != nil {
+		return fmt.Errorf("goque: write batch: %w", err)
+	}
+	q.inFlight -= moved
+	q.deadPending = false
+
+	return nil
+}
+
+// InFlight returns the number of items received using Receive that
+// have not been acknowledged or put back into the queue yet.
+func (q *Queue) InFlight() uint64 {
+	q.RLock()
+	defer q.RUnlock()
+
+	return q.inFlight
+}
+
+// requeuePending returns true if the visibility timeout of an in-flight
+// item may have passed, so that requeueDue has work to do. The caller
+// must hold the lock.
+func (q *Queue) requeuePending(now time.Time) bool {
+	return !q.readOnly && !q.nextDeadline.IsZero() && !now.Before(q.nextDeadline)
+}
+
+// requeueDue puts the in-flight items whose visibility timeout has
+// passed back into the queue, in front of its head. Items that have
+// been received the maximum number of times set using WithDeadLetter
+// are left for moveDeadLetters instead. The caller must hold the write
+// lock.
+func (q *Queue) requeueDue(now time.Time) error {
+	if !q.requeuePending(now) {
+		return nil
+	}
+
+	// Find the items that are due, along with the next deadline of
+	// the items that are not.
+	var keys, due [][]byte
+	var next time.Time
+	iter := q.db.NewIterator(q.inFlightRange(), nil)
+	for iter.Next() {
+		deadline, data := decodeInFlight(iter.Value())
+		if now.Before(deadline) {
+			if next.IsZero() || deadline.Before(next) {
+				next = deadline
+			}
+			continue
+		}
+		if h, _ := decodeValue(data); q.deadLetter != nil && h.receives >= q.maxReceives {
+			q.deadPending = true
+			continue
+		}
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+		due = append(due, append([]byte(nil), data...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Put the items back in front of the head, so the last one
+	// received goes in first.
+	var size uint64
+	head, tail := q.head, q.tail
+	if q.priorities && ItemPriority(head) > 0 {
+		// Put the items after the last ID of priority 0 instead, which
+		// is still in front of the head.
+		if q.idsLeft(0) < uint64(len(due)) {
+			return ErrIDExhausted
+		}
+		head = q.levelTails[0] + uint64(len(due))
+	}
+	last := head
+	batch := new(leveldb.Batch)
+	for i := len(due) - 1; i >= 0; i-- {
+		var id uint64
+		if head > 0 {
+			id = head
+			head--
+		} else if tail < math.MaxUint64 {
+			tail++
+			id = tail
+		} else {
+			return ErrIDExhausted
+		}
+		batch.Delete(keys[i])
+		batch.Put(q.key(id), due[i])
+		_, value := decodeValue(due[i])
+		size += uint64(len(value))
+	}
+	q.putState(batch, queueState{head: head, tail: tail, count: q.count + uint64(len(due)), size: q.size + size})
+	if err := q.db.Write(batch, q.writeOptions); err != nil {
+		return fmt.Errorf("goque: write batch: %w", err)
+	}
+
+	// Update head and tail position, item count and size.
+	if q.priorities {
+		if p := ItemPriority(last); last > q.levelTails[p] {
+			q.levelTails[p] = last
+		}
+		q.levelTails[ItemPriority(tail)] = tail
+	}
+	q.head, q.tail = head, tail
+	q.epoch++
+	q.count += uint64(len(due))
+	q.size += size
+	q.inFlight -= uint64(len(due))
+	q.nextDeadline = next
+
+	// Wake any goroutines waiting for an item.
+	q.broadcast()
+
+	return nil
+}
+
+// initInFlight counts the in-flight items of the queue and finds their
+// next deadline and latest receipt.
+func (q *Queue) initInFlight() error {
+	q.inFlight = 0
+	q.nextDeadline = time.Time{}
+	q.deadPending = false
+
+	iter := q.db.NewIterator(q.inFlightRange(), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		deadline, _ := decodeInFlight(iter.Value())
+		if q.nextDeadline.IsZero() || deadline.Before(q.nextDeadline) {
+			q.nextDeadline = deadline
+		}
+		if receipt := keyToID(iter.Key()); receipt > q.receipt {
+			q.receipt = receipt
+		}
+		q.inFlight++
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	return nil
+}
+
+// inFlightKey returns the key of the in-flight item with the given
+// receipt.
+func (q *Queue) inFlightKey(receipt uint64) []byte {
+	key := append(append([]byte(nil), q.keys.meta...), inFlightKind)
+	return appendKey(key, receipt)
+}
+
+// inFlightRange returns the range of the keys of the in-flight items.
+func (q *Queue) inFlightRange() *util.Range {
+	return util.BytesPrefix(append(append([]byte(nil), q.keys.meta...), inFlightKind))
+}
+
+// encodeInFlight returns the stored value of an in-flight item, which
+// is its visibility deadline as big-endian Unix nanoseconds followed by
+// the stored value of the item.
+func encodeInFlight(deadline time.Time, data []byte) []byte {
+	buf := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(buf, uint64(deadline.UnixNano()))
+	return append(buf, data...)
+}
+
+// decodeInFlight splits the stored value of an in-flight item into its
+// deadline and the stored value of the item.
+func decodeInFlight(data []byte) (time.Time, []byte) {
+	if len(data) < 8 {
+		return time.Time{}, data
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data))), data[8:]
+}
@@ -0,0 +1,458 @@
+package goque
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestQueueReceiveAck(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, err := q.Receive(time.Minute)
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if q.Length() != 2 || q.InFlight() != 1 {
+		t.Errorf("Expected queue length of 2 and 1 item in flight, got %d and %d", q.Length(), q.InFlight())
+	}
+
+	if err = q.Ack(item); err != nil {
+		t.Error(err)
+	}
+
+	if q.InFlight() != 0 {
+		t.Errorf("Expected 0 items in flight, got %d", q.InFlight())
+	}
+
+	// An item can only be acknowledged once.
+	if err = q.Ack(item); err != ErrNotInFlight {
+		t.Errorf("Expected to get not in flight error, got %v", err)
+	}
+
+	// Items from Dequeue are not in flight.
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Ack(deqItem); err != ErrNotInFlight {
+		t.Errorf("Expected to get not in flight error, got %v", err)
+	}
+}
+
+func TestQueueReceiveTimeout(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Receive the first two items without acknowledging them.
+	var stale []*Item
+	for i := 1; i <= 2; i++ {
+		item, err := q.Receive(10 * time.Millisecond)
+		if err != nil {
+			t.Error(err)
+		}
+		stale = append(stale, item)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	// They are received again first, in their original order.
+	for i := 1; i <= 3; i++ {
+		item, err := q.Receive(time.Minute)
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	if err = q.Ack(stale[0]); err != ErrNotInFlight {
+		t.Errorf("Expected to get not in flight error, got %v", err)
+	}
+
+	if q.Length() != 0 || q.InFlight() != 3 {
+		t.Errorf("Expected queue length of 0 and 3 items in flight, got %d and %d", q.Length(), q.InFlight())
+	}
+}
+
+func TestQueueReceiveReopen(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.Receive(50 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+
+	// The item stays in flight when the queue is reopened.
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if q, err = OpenQueue(file); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 2 || q.InFlight() != 1 {
+		t.Errorf("Expected queue length of 2 and 1 item in flight, got %d and %d", q.Length(), q.InFlight())
+	}
+
+	// Once its timeout has passed, Dequeue returns it again.
+	time.Sleep(60 * time.Millisecond)
+
+	for i := 1; i <= 3; i++ {
+		deqItem, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+
+	if q.InFlight() != 0 {
+		t.Errorf("Expected 0 items in flight, got %d", q.InFlight())
+	}
+}
+
+func TestQueueNack(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 2; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// A nacked item is received again straight away.
+	item, err := q.Receive(time.Minute)
+	if err != nil {
+		t.Error(err)
+	}
+	if err = q.Nack(item); err != nil {
+		t.Error(err)
+	}
+	if err = q.Nack(item); err != ErrNotInFlight {
+		t.Errorf("Expected to get not in flight error, got %v", err)
+	}
+
+	item, err = q.Receive(time.Minute)
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+	if item.Receives != 2 {
+		t.Errorf("Expected item to be received 2 times, got %d", item.Receives)
+	}
+}
+
+func TestQueueDeadLetter(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	dlq, err := OpenQueue(file + "_dlq")
+	if err != nil {
+		t.Error(err)
+	}
+	defer dlq.Drop()
+
+	q, err := OpenQueue(file, WithDeadLetter(dlq, 3))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 2; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Nack the first item until it has been received 3 times.
+	for i := 1; i <= 3; i++ {
+		item, err := q.Receive(time.Minute)
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := "value for item 1"
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+		if err = q.Nack(item); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// It is now in the dead-letter queue instead.
+	if q.Length() != 1 || q.InFlight() != 0 {
+		t.Errorf("Expected queue length of 1 and 0 items in flight, got %d and %d", q.Length(), q.InFlight())
+	}
+	if dlq.Length() != 1 {
+		t.Errorf("Expected dead-letter queue length of 1, got %d", dlq.Length())
+	}
+
+	item, err := dlq.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+	if item.Receives != 0 {
+		t.Errorf("Expected item to be received 0 times, got %d", item.Receives)
+	}
+
+	// The next item is received as usual.
+	item, err = q.Receive(time.Minute)
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr = "value for item 2"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+}
+
+func TestQueueDeadLetterTimeout(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	dlq, err := OpenQueue(file + "_dlq")
+	if err != nil {
+		t.Error(err)
+	}
+	defer dlq.Drop()
+
+	q, err := OpenQueue(file, WithDeadLetter(dlq, 1))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+
+	// Let the visibility timeout pass once.
+	if _, err = q.Receive(10 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err = q.Receive(time.Minute); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+	if q.InFlight() != 0 || dlq.Length() != 1 {
+		t.Errorf("Expected 0 items in flight and dead-letter queue length of 1, got %d and %d", q.InFlight(), dlq.Length())
+	}
+}
+
+func TestQueueDeadLetterUnavailable(t *testing.T) {
+	tests := []struct {
+		name           string
+		block, unblock func(dlq *Queue) error
+	}{
+		{
+			name:    "Full",
+			block:   func(dlq *Queue) error { _, err := dlq.EnqueueString("value for other item"); return err },
+			unblock: func(dlq *Queue) error { _, err := dlq.Dequeue(); return err },
+		},
+		{
+			name:    "Closed",
+			block:   func(dlq *Queue) error { return dlq.Close() },
+			unblock: func(dlq *Queue) error { return dlq.Open() },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+			dlq, err := OpenQueue(file+"_dlq", WithMaxLength(1))
+			if err != nil {
+				t.Error(err)
+			}
+			defer dlq.Drop()
+
+			q, err := OpenQueue(file, WithDeadLetter(dlq, 1))
+			if err != nil {
+				t.Error(err)
+			}
+			defer q.Drop()
+
+			for i := 1; i <= 2; i++ {
+				if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+					t.Error(err)
+				}
+			}
+			if err = tt.block(dlq); err != nil {
+				t.Error(err)
+			}
+
+			// The first item cannot be moved, so it stays in flight
+			// while the next item is received.
+			item, err := q.Receive(time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = q.Nack(item); err != nil {
+				t.Error(err)
+			}
+			item, err = q.Receive(time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compStr := "value for item 2"
+			if item.ToString() != compStr {
+				t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+			}
+			if q.InFlight() != 2 {
+				t.Errorf("Expected 2 items in flight, got %d", q.InFlight())
+			}
+
+			// It is moved once the dead-letter queue can take it.
+			if err = tt.unblock(dlq); err != nil {
+				t.Error(err)
+			}
+			if _, err = q.Receive(time.Minute); err != ErrEmpty {
+				t.Errorf("Expected to get empty error, got %v", err)
+			}
+			if q.InFlight() != 1 || dlq.Length() != 1 {
+				t.Errorf("Expected 1 item in flight and dead-letter queue length of 1, got %d and %d", q.InFlight(), dlq.Length())
+			}
+
+			item, err = dlq.Dequeue()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compStr = "value for item 1"
+			if item.ToString() != compStr {
+				t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+			}
+		})
+	}
+}
+
+func TestQueueDeadLetterMaxReceives(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	dlq, err := OpenQueue(file + "_dlq")
+	if err != nil {
+		t.Error(err)
+	}
+	defer dlq.Drop()
+
+	defer os.RemoveAll(file)
+	if _, err = OpenQueue(file, WithDeadLetter(dlq, 0)); err != ErrMaxReceives {
+		t.Errorf("Expected to get max receives error, got %v", err)
+	}
+}
+
+func TestQueueReceiveHead(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithLeaseTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// A leased item is not received again.
+	first, commit, err := q.ReceiveHead()
+	if err != nil {
+		t.Error(err)
+	}
+	second, _, err := q.ReceiveHead()
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i, item := range []*Item{first, second} {
+		compStr := fmt.Sprintf("value for item %d", i+1)
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	if err = commit(); err != nil {
+		t.Error(err)
+	}
+
+	// The uncommitted item comes back after the lease timeout.
+	time.Sleep(20 * time.Millisecond)
+
+	item, commit, err := q.ReceiveHead()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 2"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if err = commit(); err != nil {
+		t.Error(err)
+	}
+	if q.Length() != 1 || q.InFlight() != 0 {
+		t.Errorf("Expected queue length of 1 and 0 items in flight, got %d and %d", q.Length(), q.InFlight())
+	}
+}
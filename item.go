@@ -4,6 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 )
 
 // Item represents an entry in either a stack or queue.
@@ -11,6 +16,134 @@ type Item struct {
 	ID    uint64
 	Key   []byte
 	Value []byte
+
+	// ExpiresAt is the time after which the item is discarded
+	// from a queue, or the zero time if the item does not expire.
+	ExpiresAt time.Time
+
+	// EnqueuedAt is the time the item was added to a queue opened
+	// with WithEnqueueTime, or the zero time if it was not recorded.
+	EnqueuedAt time.Time
+
+	// NotBefore is the time before which the item is not removed
+	// from a queue by Dequeue, or the zero time if it is not delayed.
+	NotBefore time.Time
+
+	// Receives is the number of times the item was returned by
+	// Receive, including this one.
+	Receives uint64
+
+	// unique is whether the item was added using EnqueueUnique.
+	unique bool
+
+	// err is set if the stored value of the item could not be
+	// decoded, such as when its checksum did not match.
+	err error
+
+	// pooled is whether the item was taken from an item pool and
+	// owns its key and value buffers.
+	pooled bool
+
+	// receipt identifies the item while it is in flight, if it was
+	// returned by Receive, otherwise it is 0.
+	receipt uint64
+
+	// codec is the codec used by ToObject, or nil to use
+	// encoding/gob.
+	codec ObjectCodec
+
+	// data is the stored value the item was read from, so that it can
+	// be archived as stored, or nil for items not read from a queue
+	// and for pooled items, which do not keep it.
+	data []byte
+
+	// packed is the compressed value of an item read using
+	// newPackedItem until unpack decompresses it into Value, so that
+	// it can be decompressed once the lock of the queue is released.
+	packed []byte
+}
+
+// newItem creates an item for the given ID and key from its stored
+// value, decoding the item header if one is present.
+func newItem(id uint64, key, data []byte) *Item {
+	h, value := decodeValue(data)
+	return &Item{
+		ID:         id,
+		Key:        key,
+		Value:      value,
+		ExpiresAt:  h.expiresAt,
+		EnqueuedAt: h.enqueuedAt,
+		NotBefore:  h.notBefore,
+		Receives:   h.receives,
+		unique:     h.unique,
+		err:        h.err,
+		data:       data,
+	}
+}
+
+// newPackedItem is newItem without decompressing the value, which is left
+// for unpack.
+func newPackedItem(id uint64, key, data []byte) *Item {
+	h, value := decodeHeader(data)
+	if !h.compressed || h.err != nil {
+		return newItem(id, key, data)
+	}
+	item := newItem(id, key, nil)
+	item.ExpiresAt = h.expiresAt
+	item.EnqueuedAt = h.enqueuedAt
+	item.NotBefore = h.notBefore
+	item.Receives = h.receives
+	item.unique = h.unique
+	item.data = data
+	item.packed = value
+	return item
+}
+
+// unpack decompresses the value of an item read using newPackedItem,
+// returning an error wrapping ErrDecompress if it cannot be
+// decompressed.
+func (i *Item) unpack() error {
+	if i.packed == nil {
+		return nil
+	}
+	value, err := decompressValue(i.packed)
+	if err != nil {
+		return fmt.Errorf("goque: get item %d: %w", i.ID, err)
+	}
+	i.Value, i.packed = value, nil
+	return nil
+}
+
+// header returns the item header for the metadata of the item.
+func (i *Item) header() itemHeader {
+	return itemHeader{
+		expiresAt:  i.ExpiresAt,
+		enqueuedAt: i.EnqueuedAt,
+		notBefore:  i.NotBefore,
+		unique:     i.unique,
+		receives:   i.Receives,
+	}
+}
+
+// copy returns a copy of the item that does not share its key or
+// value with the original.
+func (i *Item) copy() *Item {
+	c := *i
+	c.Key = append([]byte(nil), i.Key...)
+	c.Value = append([]byte(nil), i.Value...)
+	return &c
+}
+
+// isExpired returns true if the item has an expiry time that is not
+// after the given time.
+func (i *Item) isExpired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt)
+}
+
+// isReady returns true if the item has no not-before time or it is not
+// after the given time.
+func (i *Item) isReady(now time.Time) bool {
+	return !now.Before(i.NotBefore)
 }
 
 // ToString returns the item value as a string.
@@ -19,17 +152,33 @@ func (i *Item) ToString() string {
 }
 
 // ToObject decodes the item value into the given value type using
-// encoding/gob.
+// the codec of the queue the item came from, which is encoding/gob
+// unless changed using SetCodec.
 //
 // The value passed to this method should be a pointer to a variable
 // of the type you wish to decode into. The variable pointed to will
 // hold the decoded object.
 func (i *Item) ToObject(value interface{}) error {
+	if i.codec != nil {
+		return i.codec.Unmarshal(i.Value, value)
+	}
+
 	buffer := bytes.NewBuffer(i.Value)
 	dec := gob.NewDecoder(buffer)
 	return dec.Decode(value)
 }
 
+// ToObjectFromJSON decodes the item value into the given value type
+// using encoding/json, whatever the codec of the queue, such as for an
+// item added using EnqueueObjectAsJSON.
+//
+// JSON does not keep all of the Go types of a value. Numbers decoded
+// into an interface{} become float64, and times keep their instant but
+// not their monotonic clock reading.
+func (i *Item) ToObjectFromJSON(value interface{}) error {
+	return json.Unmarshal(i.Value, value)
+}
+
 // PriorityItem represents an entry in a priority queue.
 type PriorityItem struct {
 	ID       uint64
@@ -55,14 +204,40 @@ func (pi *PriorityItem) ToObject(value interface{}) error {
 	return dec.Decode(value)
 }
 
+// newItemFromIterator creates an item from the current position of the
+// given iterator. The key and value are copied, as the iterator's
+// buffers are only valid until it is moved.
+func newItemFromIterator(iter iterator.Iterator) *Item {
+	key := append([]byte(nil), iter.Key()...)
+	return newItem(keyToID(key), key, append([]byte(nil), iter.Value()...))
+}
+
+// checkItem returns an error wrapping ErrChecksum or ErrDecompress if
+// the stored value of the given item could not be decoded.
+func checkItem(item *Item) error {
+	if item.err != nil {
+		return fmt.Errorf("goque: get item %d: %w", item.ID, item.err)
+	}
+	return nil
+}
+
 // idToKey converts and returns the given ID to a key.
 func idToKey(id uint64) []byte {
-	key := make([]byte, 8)
-	binary.BigEndian.PutUint64(key, id)
-	return key
+	return appendKey(make([]byte, 0, 8), id)
+}
+
+// appendKey appends the key for the given ID to dst and returns the
+// extended buffer, allowing the buffer of an existing key to be reused.
+// Keys are big-endian, so that their byte order matches the order of
+// the IDs.
+func appendKey(dst []byte, id uint64) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], id)
+	return append(dst, key[:]...)
 }
 
-// keyToID converts and returns the given key to an ID.
+// keyToID converts and returns the given key to an ID. The ID is held
+// by the last 8 bytes of the key, following any prefix.
 func keyToID(key []byte) uint64 {
-	return binary.BigEndian.Uint64(key)
+	return binary.BigEndian.Uint64(key[len(key)-8:])
 }
@@ -0,0 +1,106 @@
+package goque
+
+import (
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+// Iterator steps through the items of a queue from head to tail without
+// removing them, such as for a consumer that pulls items lazily and may
+// stop early. Unlike ForEach, no lock is held while it is used.
+//
+// An iterator is a point-in-time view of the queue as of when it was
+// created. It covers the items between the head and tail at that time,
+// and items removed or added afterwards may or may not be visible. An
+// iterator holds on to the state of the underlying database, so it
+// must be released using Release once it is no longer needed. An
+// iterator is not safe for concurrent use.
+type Iterator struct {
+	q    *Queue
+	iter iterator.Iterator
+	tail uint64
+	item *Item
+	err  error
+}
+
+// NewIterator returns an iterator positioned before the first item of
+// the queue.
+func (q *Queue) NewIterator() (*Iterator, error) {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	return &Iterator{
+		q:    q,
+		iter: q.db.NewIterator(q.rangeFrom(q.head+1), nil),
+		tail: q.tail,
+	}, nil
+}
+
+// Next moves the iterator to the next item, and returns false once
+// there are no more items or an error occurred, which is then returned
+// by Error.
+func (it *Iterator) Next() bool {
+	if it.err != nil || !it.iter.Next() {
+		it.item = nil
+		return false
+	}
+	return it.load()
+}
+
+// Seek moves the iterator to the first item with an ID of at least the
+// given ID, and returns false if there is no such item or an error
+// occurred. Next then continues with the item after it.
+func (it *Iterator) Seek(id uint64) bool {
+	if it.err != nil || !it.iter.Seek(it.q.key(id)) {
+		it.item = nil
+		return false
+	}
+	return it.load()
+}
+
+// load decodes the item at the current position of the iterator, and
+// returns false if it is past the tail or cannot be decoded.
+func (it *Iterator) load() bool {
+	it.item = nil
+	if it.q.keyID(it.iter.Key()) > it.tail {
+		return false
+	}
+
+	item := it.q.newItemFromIterator(it.iter)
+	if err := checkItem(item); err != nil {
+		it.err = err
+		return false
+	}
+	it.item = item
+	return true
+}
+
+// Item returns the item at the current position of the iterator, or nil
+// if the iterator is not positioned at an item.
+func (it *Iterator) Item() *Item {
+	return it.item
+}
+
+// Error returns the error that stopped the iterator, if any.
+func (it *Iterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	if err := it.iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+	return nil
+}
+
+// Release releases the iterator. It must be called once the iterator is
+// no longer needed, and the iterator must not be used afterwards.
+func (it *Iterator) Release() {
+	it.item = nil
+	it.iter.Release()
+}
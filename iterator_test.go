@@ -0,0 +1,96 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIterator(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	it, err := q.NewIterator()
+	if err != nil {
+		t.Error(err)
+	}
+	defer it.Release()
+
+	// Items added after the iterator was created are not visible.
+	if _, err = q.EnqueueString("value for item 11"); err != nil {
+		t.Error(err)
+	}
+
+	var ids []uint64
+	for it.Next() {
+		if it.Item().ID == 4 {
+			break
+		}
+		ids = append(ids, it.Item().ID)
+	}
+
+	if len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+		t.Errorf("Expected item IDs 2 and 3, got %v", ids)
+	}
+
+	if !it.Seek(8) {
+		t.Error("Expected to seek to item 8")
+	}
+
+	compStr := "value for item 8"
+
+	if it.Item().ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, it.Item().ToString())
+	}
+
+	for it.Next() {
+		ids = append(ids, it.Item().ID)
+	}
+
+	if len(ids) != 4 || ids[3] != 10 {
+		t.Errorf("Expected item IDs 2, 3, 9 and 10, got %v", ids)
+	}
+
+	if it.Item() != nil {
+		t.Error("Expected no item past the tail")
+	}
+
+	if it.Seek(11) {
+		t.Error("Expected seek past the tail to fail")
+	}
+
+	if err = it.Error(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIteratorClosed(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.NewIterator(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
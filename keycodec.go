@@ -0,0 +1,34 @@
+package goque
+
+// KeyCodec converts the IDs of the items in a queue to and from the
+// keys they are stored under, following the prefix of the queue, if
+// any. It allows the default 8 byte big-endian keys to be replaced,
+// such as to add a prefix or checksum to each key.
+//
+// The keys must sort in the order of their IDs, none may be a prefix of
+// another, and all must sort after the metadata keys of the queue, which
+// start with eight zero bytes, unless the queue was opened using
+// WithKeyNamespaces. FromKey must return the ID given to ToKey
+// for any key it returned, and an ID of 0 for keys it does not recognize.
+type KeyCodec interface {
+	ToKey(id uint64) []byte
+	FromKey(key []byte) uint64
+}
+
+// BigEndianKeyCodec is a KeyCodec storing each ID as an 8 byte
+// big-endian key. It is the default key codec of a queue.
+type BigEndianKeyCodec struct{}
+
+// ToKey returns the 8 byte big-endian key for the given ID.
+func (BigEndianKeyCodec) ToKey(id uint64) []byte {
+	return idToKey(id)
+}
+
+// FromKey returns the ID held by the given 8 byte big-endian key, or 0
+// if the key is not 8 bytes long.
+func (BigEndianKeyCodec) FromKey(key []byte) uint64 {
+	if len(key) != 8 {
+		return 0
+	}
+	return keyToID(key)
+}
@@ -0,0 +1,76 @@
+package goque
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+// checksumKeyCodec is a KeyCodec appending a CRC-32 checksum of the ID
+// to each key.
+type checksumKeyCodec struct{}
+
+func (checksumKeyCodec) ToKey(id uint64) []byte {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key, id)
+	binary.BigEndian.PutUint32(key[8:], crc32.ChecksumIEEE(key[:8]))
+	return key
+}
+
+func (checksumKeyCodec) FromKey(key []byte) uint64 {
+	if len(key) != 12 || binary.BigEndian.Uint32(key[8:]) != crc32.ChecksumIEEE(key[:8]) {
+		return 0
+	}
+	return binary.BigEndian.Uint64(key)
+}
+
+func TestQueueKeyCodec(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithKeyCodec(checksumKeyCodec{}))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(item.Key) != 12 {
+		t.Errorf("Expected key length of 12, got %d", len(item.Key))
+	}
+
+	// The head and tail are read back using the key codec.
+	q.Close()
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 9 {
+		t.Errorf("Expected queue length of 9, got %d", q.Length())
+	}
+
+	for i := 2; i <= 10; i++ {
+		deqItem, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if deqItem.ID != uint64(i) {
+			t.Errorf("Expected item ID to be %d, got %d", i, deqItem.ID)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package goque
+
+// The levels passed to a Logger.
+const (
+	// LogDebug is the level of the messages for each item added to
+	// or removed from a queue.
+	LogDebug = "debug"
+
+	// LogInfo is the level of the messages for opening and closing
+	// a queue.
+	LogInfo = "info"
+
+	// LogError is the level of the messages for failed operations.
+	LogError = "error"
+)
+
+// Logger receives the log messages of a queue, such as to pass them on
+// to a structured logger. The level is one of LogDebug, LogInfo and
+// LogError, and kv holds alternating keys and values describing the
+// message, such as "id" and the ID of an item.
+type Logger func(level, msg string, kv ...interface{})
+
+// SetLogger sets the logger of the queue, replacing the one set using
+// WithLogger, if any. A nil logger turns logging off.
+//
+// Messages for each item, and for operations that fail, are logged
+// once the queue lock has been released, so a slow logger does not
+// hold up other goroutines. Messages for opening and closing the queue,
+// and for errors on those paths, may be logged while the lock is held,
+// so the logger must not call back into the queue. Errors that only
+// report the state of the queue, such as ErrEmpty or ErrFull, are not
+// logged.
+func (q *Queue) SetLogger(logger Logger) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.logger = logger
+}
+
+// log passes a message to the logger, if any. The caller must hold the
+// lock, or hold the only reference to the queue.
+func (q *Queue) log(level, msg string, kv ...interface{}) {
+	if q.logger != nil {
+		q.logger(level, msg, kv...)
+	}
+}
+
+// logOpen logs the opening of the queue, or the given error if opening
+// it failed, and returns the error. The caller must hold the lock, or
+// hold the only reference to the queue.
+func (q *Queue) logOpen(err error) error {
+	if err != nil {
+		q.log(LogError, "open queue", "dataDir", q.DataDir, "error", err)
+		return err
+	}
+	q.log(LogInfo, "open queue", "dataDir", q.DataDir, "length", q.length())
+	return nil
+}
+
+// closeDB closes the database of the queue on a path that is already
+// failing with another error, logging any error from closing it. The
+// caller must hold the lock, or hold the only reference to the queue.
+func (q *Queue) closeDB() {
+	if err := q.db.Close(); err != nil {
+		q.log(LogError, "close queue", "dataDir", q.DataDir, "error", err)
+	}
+}
+
+// logError logs the given error of a failed operation and returns it.
+// Nil errors and errors reporting the state of the queue are not
+// logged. The caller must not hold the lock.
+func (q *Queue) logError(msg string, err error) error {
+	switch err {
+	case nil, ErrEmpty, ErrFull, ErrOutOfBounds, ErrNotFound, ErrNotInFlight:
+		return err
+	}
+
+	q.RLock()
+	logger := q.logger
+	q.RUnlock()
+
+	if logger != nil {
+		logger(LogError, msg, "dataDir", q.DataDir, "error", err)
+	}
+	return err
+}
+
+// logItems logs a debug message for each of the given items using the
+// given logger, if not nil.
+func logItems(logger Logger, msg string, items []*Item) {
+	if logger == nil {
+		return
+	}
+	for _, item := range items {
+		logger(LogDebug, msg, "id", item.ID)
+	}
+}
@@ -0,0 +1,96 @@
+package goque
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueLogger(t *testing.T) {
+	var mu sync.Mutex
+	var logs []string
+	logger := func(level, msg string, kv ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		logs = append(logs, strings.TrimSpace(fmt.Sprintln(append([]interface{}{level, msg}, kv...)...)))
+	}
+
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithLogger(logger))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	// An empty queue is not an error worth logging.
+	if _, err = q.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	// Close the database behind the back of the queue.
+	q.db.Close()
+	if _, err = q.EnqueueString("value for item 2"); err == nil {
+		t.Error("Expected enqueue to fail")
+	}
+	if err = q.Close(); err == nil {
+		t.Error("Expected close to fail")
+	}
+
+	expected := []string{
+		"info open queue dataDir " + file + " length 0",
+		"debug enqueue id 1",
+		"debug dequeue id 1",
+		"error enqueue dataDir " + file + " error goque: put item 2: leveldb: closed",
+		"error close queue dataDir " + file + " error leveldb: closed",
+	}
+	if len(logs) != len(expected) {
+		t.Fatalf("Expected %d log messages, got %q", len(expected), logs)
+	}
+	for i := range expected {
+		if logs[i] != expected[i] {
+			t.Errorf("Expected log message to be '%s', got '%s'", expected[i], logs[i])
+		}
+	}
+}
+
+func TestQueueSetLogger(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	var ids []interface{}
+	q.SetLogger(func(level, msg string, kv ...interface{}) {
+		if level == LogDebug {
+			ids = append(ids, kv[1])
+		}
+	})
+
+	if _, err = q.EnqueueBatch([][]byte{[]byte("value for item 1"), []byte("value for item 2")}); err != nil {
+		t.Error(err)
+	}
+
+	if len(ids) != 2 || ids[0] != uint64(1) || ids[1] != uint64(2) {
+		t.Errorf("Expected to log items 1 and 2, got %v", ids)
+	}
+
+	// A nil logger turns logging off.
+	q.SetLogger(nil)
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+	if len(ids) != 2 {
+		t.Errorf("Expected no more log messages, got %v", ids)
+	}
+}
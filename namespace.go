@@ -0,0 +1,152 @@
+package goque
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// The namespace bytes that follow the prefix of a queue using key
+// namespaces, in front of the keys of its items and its metadata.
+const (
+	itemSpace byte = 'i'
+	metaSpace byte = 'm'
+)
+
+// keySpace holds the prefixes of the keys of a queue, which include the
+// prefix of the queue, along with the key of its stored state.
+type keySpace struct {
+	item  []byte
+	meta  []byte
+	state []byte
+}
+
+// setKeys sets the prefixes of the keys of the queue for its layout.
+func (q *Queue) setKeys() {
+	item := append([]byte(nil), q.prefix...)
+	meta := append([]byte(nil), q.prefix...)
+	if q.namespaced {
+		item = append(item, itemSpace)
+		meta = append(meta, metaSpace)
+	} else {
+		meta = append(meta, metaPrefix...)
+	}
+	q.keys = keySpace{
+		item:  item,
+		meta:  meta,
+		state: append(append([]byte(nil), meta...), stateKind),
+	}
+}
+
+// initKeys sets up the keys of the queue. A queue whose stored state is
+// in the metadata namespace uses key namespaces, as does a queue opened
+// using WithKeyNamespaces, which then has any keys stored without them
+// moved into the namespaces.
+func (q *Queue) initKeys() error {
+	key := append(append(append([]byte(nil), q.prefix...), metaSpace), stateKind)
+	started, err := q.db.Has(key, nil)
+	if err != nil {
+		return fmt.Errorf("goque: get state: %w", err)
+	}
+	q.namespaced = q.namespaced || started
+	q.storedState = q.storedState || q.namespaced
+	q.setKeys()
+	if !q.namespaced {
+		return nil
+	}
+
+	// Before the namespaces are started, check that no key stored
+	// without namespaces sorts after the start of them, where it could
+	// not be told apart from the keys in the namespaces.
+	if !started {
+		iter := q.db.NewIterator(q.keyRange(), nil)
+		ok := iter.Last() && bytes.Compare(iter.Key(), q.legacyRange().Limit) >= 0
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return fmt.Errorf("goque: iterate items: %w", err)
+		}
+		if ok {
+			return ErrKeyNamespaces
+		}
+	}
+
+	// Check for keys stored without namespaces.
+	iter := q.db.NewIterator(q.legacyRange(), nil)
+	ok := iter.First()
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	return q.migrateKeys(!started)
+}
+
+// legacyRange returns the range of the keys of the queue that sort in
+// front of the namespaces, which holds all keys stored without them.
+func (q *Queue) legacyRange() *util.Range {
+	return &util.Range{
+		Start: q.prefix,
+		Limit: append(append([]byte(nil), q.prefix...), itemSpace),
+	}
+}
+
+// migrateKeys moves the keys of the queue stored without namespaces
+// into them, in batches. If mark is true, the first batch also stores
+// an empty state in the metadata namespace, so that the queue uses the
+// namespaces from then on and a migration cut short is picked up again
+// the next time the queue is opened. The stored state is moved along
+// with the other keys, and until then the empty state does not fit the
+// items, so they are counted instead.
+func (q *Queue) migrateKeys(mark bool) error {
+	batch := new(leveldb.Batch)
+	if mark {
+		q.putState(batch, queueState{})
+	}
+
+	iter := q.db.NewIterator(q.legacyRange(), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		batch.Delete(iter.Key())
+		batch.Put(q.namespacedKey(iter.Key()), iter.Value())
+
+		// Write a full batch.
+		if batch.Len() >= importBatchSize {
+			if err := q.db.Write(batch, q.writeOptions); err != nil {
+				return fmt.Errorf("goque: write batch: %w", err)
+			}
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Write the remaining keys.
+	if batch.Len() > 0 {
+		if err := q.db.Write(batch, q.writeOptions); err != nil {
+			return fmt.Errorf("goque: write batch: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// namespacedKey returns the key that the given key stored without
+// namespaces is moved to.
+func (q *Queue) namespacedKey(key []byte) []byte {
+	key = key[len(q.prefix):]
+	ns := append([]byte(nil), q.prefix...)
+	if len(key) > len(metaPrefix) && bytes.HasPrefix(key, metaPrefix) {
+		return append(append(ns, metaSpace), key[len(metaPrefix):]...)
+	}
+	return append(append(ns, itemSpace), key...)
+}
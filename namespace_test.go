@@ -0,0 +1,284 @@
+package goque
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// countLegacyKeys returns the number of keys of the queue stored without
+// namespaces.
+func countLegacyKeys(q *Queue) int {
+	var n int
+	iter := q.db.NewIterator(q.legacyRange(), nil)
+	defer iter.Release()
+	for iter.Next() {
+		n++
+	}
+	return n
+}
+
+// textKeyCodec stores each ID as "x" followed by its 20 digit decimal
+// form, so its keys sort after the start of the namespaces.
+type textKeyCodec struct{}
+
+func (textKeyCodec) ToKey(id uint64) []byte {
+	return []byte(fmt.Sprintf("x%020d", id))
+}
+
+func (textKeyCodec) FromKey(key []byte) uint64 {
+	if len(key) != 21 || key[0] != 'x' {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(key[1:]), 10, 64)
+	return id
+}
+
+func TestQueueWithKeyNamespaces(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithKeyNamespaces())
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, _, err = q.EnqueueUnique([]byte("unique value")); err != nil {
+		t.Error(err)
+	}
+
+	if countLegacyKeys(q) != 0 {
+		t.Errorf("Expected no keys without namespaces, got %d", countLegacyKeys(q))
+	}
+
+	// The queue keeps its namespaces once reopened without the option.
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+	q, err = OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 6 {
+		t.Errorf("Expected queue length of 6, got %d", q.Length())
+	}
+
+	item, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.Key[0] != itemSpace {
+		t.Errorf("Expected key in item namespace, got %x", item.Key)
+	}
+
+	if _, ok, err := q.EnqueueUnique([]byte("unique value")); err != nil || ok {
+		t.Errorf("Expected unique value to be in the index, got %v, %v", ok, err)
+	}
+}
+
+func TestQueueMigrateKeys(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	db, err := leveldb.OpenFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(file)
+	defer db.Close()
+
+	q, err := NewQueueFromDB(db, []byte("a:"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err = q.DequeueByID(3); err != nil {
+		t.Error(err)
+	}
+	if _, _, err = q.EnqueueUnique([]byte("unique value")); err != nil {
+		t.Error(err)
+	}
+	received, err := q.Receive(time.Minute)
+	if err != nil {
+		t.Error(err)
+	}
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	q, err = NewQueueFromDB(db, []byte("a:"), WithKeyNamespaces())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if countLegacyKeys(q) != 0 {
+		t.Errorf("Expected no keys without namespaces, got %d", countLegacyKeys(q))
+	}
+
+	if q.Length() != 4 {
+		t.Errorf("Expected queue length of 4, got %d", q.Length())
+	}
+
+	// The items, the index of unique items and the in-flight items are
+	// all moved.
+	for _, i := range []int{2, 4, 5} {
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	if _, ok, err := q.EnqueueUnique([]byte("unique value")); err != nil || ok {
+		t.Errorf("Expected unique value to be in the index, got %v, %v", ok, err)
+	}
+
+	if err = q.Ack(received); err != nil {
+		t.Error(err)
+	}
+
+	// The keys of other data in the database are left as they are.
+	other, err := NewQueueFromDB(db, []byte("b:"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if other.namespaced {
+		t.Error("Expected other queue to be stored without namespaces")
+	}
+}
+
+func TestQueueMigrateKeysResume(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithStoredState())
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	// Move the state and the first few items into the namespaces out of
+	// band, as if the migration was cut short, leaving old and new keys
+	// mixed.
+	batch := new(leveldb.Batch)
+	for _, key := range [][]byte{q.stateKey(), q.key(2), q.key(3), q.key(4)} {
+		value, err := q.db.Get(key, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		batch.Delete(key)
+		batch.Put(q.namespacedKey(key), value)
+	}
+	if err = q.db.Write(batch, nil); err != nil {
+		t.Error(err)
+	}
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// Opening the queue moves the rest of the keys, without the option.
+	q, err = OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if countLegacyKeys(q) != 0 {
+		t.Errorf("Expected no keys without namespaces, got %d", countLegacyKeys(q))
+	}
+
+	if q.Length() != 9 {
+		t.Errorf("Expected queue length of 9, got %d", q.Length())
+	}
+
+	for i := 2; i <= 10; i++ {
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+}
+
+func TestQueueMigrateKeysReadOnly(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = OpenQueueReadOnly(file, WithKeyNamespaces()); err != ErrReadOnly {
+		t.Errorf("Expected to get read-only error, got %v", err)
+	}
+
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueMigrateKeysCodec(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithKeyCodec(textKeyCodec{}))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// The keys of the items sort after the start of the namespaces.
+	if _, err = OpenQueue(file, WithKeyCodec(textKeyCodec{}), WithKeyNamespaces()); err != ErrKeyNamespaces {
+		t.Errorf("Expected to get key namespaces error, got %v", err)
+	}
+
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+}
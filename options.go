@@ -0,0 +1,370 @@
+package goque
+
+import (
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// QueueOption configures optional behavior of a queue when it is opened.
+type QueueOption func(*Queue)
+
+// WithMaxLength limits the queue to hold at most maxLen items. Once the
+// queue is full, Enqueue returns ErrFull until items are dequeued. A
+// maxLen of 0 means the queue is unbounded, which is the default.
+func WithMaxLength(maxLen uint64) QueueOption {
+	return func(q *Queue) {
+		q.maxLength = maxLen
+	}
+}
+
+// WithLogger sets the logger of the queue, so that opening the queue is
+// logged as well. See SetLogger.
+func WithLogger(logger Logger) QueueOption {
+	return func(q *Queue) {
+		q.logger = logger
+	}
+}
+
+// WithTracer sets the tracer of the queue. See SetTracer.
+func WithTracer(tracer Tracer) QueueOption {
+	return func(q *Queue) {
+		q.tracer.Store(tracer)
+	}
+}
+
+// WithMaxValueSize limits the values added to the queue, or set using
+// Update, to at most maxSize bytes. Larger values are rejected with
+// ErrValueTooLarge. A maxSize of 0 means values are not limited, which
+// is the default.
+func WithMaxValueSize(maxSize int) QueueOption {
+	return func(q *Queue) {
+		q.maxValueSize = maxSize
+	}
+}
+
+// WithRetryPolicy retries the reads and writes of the queue that fail
+// with an error that may be transient, according to the given policy.
+// The lock of the queue is held while waiting between attempts. By
+// default, operations are not retried.
+func WithRetryPolicy(policy RetryPolicy) QueueOption {
+	return func(q *Queue) {
+		q.retryPolicy = policy
+	}
+}
+
+// WithResetOnEmpty makes the IDs of the queue start over from 1 when an
+// item is added to the empty queue, rather than continuing from the
+// last ID, so that a long-lived queue does not run out of IDs. The IDs
+// of removed items are then reused, so positions such as those of a
+// Cursor are only valid until the queue is next empty. Enqueue takes
+// the write lock of a queue opened with this option, as it may move
+// the head.
+func WithResetOnEmpty() QueueOption {
+	return func(q *Queue) {
+		q.resetOnEmpty = true
+	}
+}
+
+// OverflowPolicy determines what happens when an item is added to a
+// queue that already holds its maximum number of items.
+type OverflowPolicy int
+
+const (
+	// Reject rejects the new item with ErrFull. It is the default.
+	Reject OverflowPolicy = iota
+
+	// DropOldest evicts the oldest items of the queue to make room
+	// for the new item. The evicted items are passed to the hook set
+	// using SetEvictHook.
+	DropOldest
+)
+
+// WithOverflowPolicy sets the policy used when an item is added to a
+// queue limited using WithMaxLength that is full.
+func WithOverflowPolicy(policy OverflowPolicy) QueueOption {
+	return func(q *Queue) {
+		q.overflow = policy
+	}
+}
+
+// WithLeaseTimeout sets the time after which an item returned by
+// ReceiveHead goes back into the queue if it has not been committed.
+// The default is DefaultLeaseTimeout.
+func WithLeaseTimeout(timeout time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.leaseTimeout = timeout
+	}
+}
+
+// WithDeadLetter moves items received using Receive to the dead-letter
+// queue dlq instead of back into the queue once they have been received
+// maxReceives times without being acknowledged, so that an item that
+// cannot be processed does not come back forever.
+//
+// Items are moved by the following calls to Receive and Nack. Each item
+// is added to dlq before it is removed from the queue, so a crash in
+// between may leave it in both. If dlq is full, closed or read-only,
+// the items stay in flight until they can be moved, and the error is
+// logged using the logger set using WithLogger.
+//
+// Opening the queue fails with ErrMaxReceives if maxReceives is 0, with
+// ErrSameQueue if dlq is the queue itself, and with ErrPriorities if
+// dlq was opened using WithPriorities.
+func WithDeadLetter(dlq *Queue, maxReceives uint64) QueueOption {
+	return func(q *Queue) {
+		q.deadLetter = dlq
+		q.maxReceives = maxReceives
+	}
+}
+
+// Compression is the compression applied to the values of a queue.
+type Compression byte
+
+const (
+	// NoCompression stores values as they are. It is the default.
+	NoCompression Compression = iota
+
+	// Snappy compresses values using Snappy, which is fast but
+	// compresses less than Gzip.
+	Snappy
+
+	// Gzip compresses values using gzip at the default level.
+	Gzip
+)
+
+// WithCompression compresses the values of items added to or updated
+// in the queue using the given compression. Values are compressed
+// before the queue is locked. Peek decompresses values once its locks
+// are released, and Dequeue while holding only the lock of the head, so
+// neither holds up Enqueue; other reads decompress values under the
+// lock. Compressed values are marked, so items stored with any
+// compression, or none, can still be read whichever compression the
+// queue is opened with.
+//
+// Length is not affected by compression, and SizeBytes reports the
+// size of the decompressed values, not the space they take on disk.
+// Items are stored in a different format when compressed, which older
+// versions of Goque cannot read.
+func WithCompression(c Compression) QueueOption {
+	return func(q *Queue) {
+		q.compression = c
+	}
+}
+
+// WithChecksums stores a CRC-32 checksum with each item added to or
+// updated in the queue, which is verified whenever the item is read.
+// If the item has changed on disk, reading it returns an error wrapping
+// ErrChecksum. An item with a bad checksum at the head stops the queue
+// until it is removed using Discard.
+//
+// Items are stored in a different format with checksums, which older
+// versions of Goque cannot read. Items already in the queue keep their
+// format, and items stored with checksums are still verified if the
+// queue is later opened without this option.
+func WithChecksums() QueueOption {
+	return func(q *Queue) {
+		q.checksums = true
+	}
+}
+
+// WithKeyCodec stores the items of the queue under the keys given by
+// codec instead of the default 8 byte big-endian keys. A queue must
+// always be opened with the same key codec, as the keys of its items
+// are not converted. Exports are written with 8 byte IDs regardless of
+// the key codec.
+func WithKeyCodec(codec KeyCodec) QueueOption {
+	return func(q *Queue) {
+		q.keyCodec = codec
+	}
+}
+
+// WithKeyNamespaces stores the keys of the items of the queue after a
+// namespace byte 'i' and the keys of its metadata after a namespace
+// byte 'm', so that the two cannot collide, whatever keys the items
+// are given by a KeyCodec.
+//
+// A queue stored without namespaces has its keys moved into them when
+// it is opened with this option, keeping the IDs and order of its
+// items. The keys are moved in batches, so this may take a while for a
+// large queue; if it is cut short, the rest of the keys are moved the
+// next time the queue is opened. Once moved, the queue always uses the
+// namespaces, with or without the option, and can no longer be opened
+// by older versions of Goque. A read-only queue cannot be moved, so
+// ErrReadOnly is returned instead.
+func WithKeyNamespaces() QueueOption {
+	return func(q *Queue) {
+		q.namespaced = true
+	}
+}
+
+// WithSyncWrites makes every write to the queue synchronous, so that
+// added and removed items are flushed from the operating system
+// buffer cache to disk before the call returns. This guards against
+// losing data on a machine crash at the cost of much slower writes.
+func WithSyncWrites() QueueOption {
+	return func(q *Queue) {
+		q.writeOptions = &opt.WriteOptions{Sync: true}
+	}
+}
+
+// WithEnqueueTime stores the time each item is enqueued with the item,
+// exposed as Item.EnqueuedAt when it is read back, so callers can tell
+// how long an item sat in the queue.
+//
+// The time is stored in a header in front of the value, so enabling
+// it changes the stored format of new items only. Items
+// enqueued without it, including those stored by older versions of
+// Goque, decode with a zero EnqueuedAt, so a queue may be opened with
+// and without this option at different times.
+func WithEnqueueTime() QueueOption {
+	return func(q *Queue) {
+		q.enqueueTime = true
+	}
+}
+
+// WithSkipMissing makes Dequeue and Peek skip items missing from the
+// database of the queue instead of returning ErrCorrupt. The items
+// are recounted when a missing item is found, so the queue continues
+// with the next item that is stored.
+//
+// Other methods accessing a missing item still return ErrCorrupt.
+func WithSkipMissing() QueueOption {
+	return func(q *Queue) {
+		q.skipMissing = true
+	}
+}
+
+// WithStoredState stores the head, tail, item count and size of the
+// queue along with each change to its items, so that opening the queue
+// does not have to count its items, and the IDs of removed items are
+// not reused once the queue is reopened. Without it, the items are
+// counted each time the queue is opened.
+//
+// The state is stored under a key that versions of Goque without this
+// option do not expect, so they cannot open the queue once it has been
+// opened with it. Opening the queue without this option deletes the
+// stored state again. Storing the state adds a few microseconds and
+// allocations to each Enqueue.
+func WithStoredState() QueueOption {
+	return func(q *Queue) {
+		q.storedState = true
+	}
+}
+
+// WithPriorities lets items be added to the queue with a priority using
+// EnqueueWithPriority. Dequeue and Peek return the oldest item of the
+// lowest priority number holding items, so items of the same priority
+// stay in FIFO order, and all other ways of adding items use priority
+// 0, the most urgent.
+//
+// The priority is the top byte of the ID of an item, followed by its
+// sequence within the priority, so the keys of the items sort by
+// priority first. The items of a queue opened without this option all
+// have priority 0, so such a queue can be opened with and without it,
+// as long as only priority 0 is used. As the IDs of the items in the
+// queue are no longer consecutive, PeekTail and PeekFromTail count from
+// the newest item of the highest priority number, and ErrIDExhausted is
+// returned once a priority runs out of its 2^56 IDs.
+//
+// Enqueue takes the write lock of a queue opened with this option, as
+// it may move the head. Receive, ReceiveHead and Begin return
+// ErrPriorities, as do TransferTo and WithDeadLetter into such a queue,
+// as they do not keep the priority of the items.
+func WithPriorities() QueueOption {
+	return func(q *Queue) {
+		q.priorities = true
+	}
+}
+
+// WithArchive keeps the items removed by Dequeue, DequeueBatch,
+// DequeueByID, Commit and Txn.Dequeue in an archive of the queue
+// instead of deleting them, for an audit trail or to replay the
+// processed items. Each item is added to the archive in the same atomic
+// write that removes it from the queue. The archive can be read using
+// ForEachArchived and emptied using DrainArchive or TrimArchive. Items
+// removed in other ways, such as expired items, acknowledged in-flight
+// items or those dropped by Discard, are not archived.
+//
+// The archive is stored in the database of the queue and only shrinks
+// when drained or trimmed, so the queue keeps growing on disk with every
+// dequeued item until then, and dequeuing writes a full copy of each
+// item. The archive does not count towards Length or SizeBytes.
+func WithArchive() QueueOption {
+	return func(q *Queue) {
+		q.archive = true
+	}
+}
+
+// WithScanChunk makes ForEach and Export, along with CountFunc and
+// FindFunc, which use ForEach, read the items of the queue in chunks of
+// at most n items. After each chunk, the iterator over the database and
+// the read lock are released, and the scan picks up again after the
+// last item read, which lets LevelDB compact the files the iterator
+// held on to and other operations on the queue proceed.
+//
+// Smaller chunks bound the resources held by a scan of a large queue,
+// but the scan no longer sees the queue at a single point in time:
+// items added or removed between chunks may or may not be visited, and
+// ErrDBClosed is returned if the queue is closed between them. An n of
+// 0 reads all items using a single iterator while holding the read
+// lock, which is the default.
+func WithScanChunk(n int) QueueOption {
+	return func(q *Queue) {
+		q.scanChunk = n
+	}
+}
+
+// WithItemPool makes the queue reuse the memory of items returned to it
+// using ReleaseItem for the items it returns later on, reducing the
+// load on the garbage collector when many items are dequeued.
+//
+// This changes the ownership of the items returned by the queue: once
+// an item is released, it and its key and value may be overwritten at
+// any time, so they must not be used anymore.
+func WithItemPool() QueueOption {
+	return func(q *Queue) {
+		q.pool = &sync.Pool{
+			New: func() interface{} {
+				return new(Item)
+			},
+		}
+	}
+}
+
+// inMemory marks the queue as being backed by in-memory storage.
+func inMemory() QueueOption {
+	return func(q *Queue) {
+		q.memory = true
+	}
+}
+
+// readOnly makes the queue read-only.
+func readOnly() QueueOption {
+	return func(q *Queue) {
+		q.readOnly = true
+	}
+}
+
+// withRepair makes the queue remove invalid keys when it is opened.
+func withRepair() QueueOption {
+	return func(q *Queue) {
+		q.repair = true
+	}
+}
+
+// recovered makes the queue count its items when it is opened rather
+// than using its stored state.
+func recovered() QueueOption {
+	return func(q *Queue) {
+		q.recovered = true
+	}
+}
+
+// withDBOptions sets the options used to open the LevelDB database.
+func withDBOptions(o *opt.Options) QueueOption {
+	return func(q *Queue) {
+		q.dbOptions = o
+	}
+}
@@ -0,0 +1,185 @@
+package goque
+
+// Prefetch makes a background goroutine read up to n items ahead of the
+// head of the queue into memory, so that Dequeue can remove them without
+// reading them first, such as for a consumer dequeuing continuously from
+// a slow disk. The goroutine reads ahead again as items are dequeued or
+// enqueued. An n of 0 or less stops prefetching, which is the default.
+//
+// The prefetched items are dropped whenever the queue is changed other
+// than by Enqueue or Dequeue, such as by Update or DequeueByID, so that
+// Dequeue never returns an item that has since been changed or removed.
+// Prefetching stops when the queue is closed.
+func (q *Queue) Prefetch(n int) error {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	q.stopPrefetch()
+	if n <= 0 {
+		return nil
+	}
+
+	// Start reading ahead.
+	q.prefetchN = n
+	q.prefetchWake = make(chan struct{}, 1)
+	q.prefetchStop = make(chan struct{})
+	go q.prefetchLoop(n, q.prefetchWake, q.prefetchStop)
+	q.wakePrefetch()
+
+	return nil
+}
+
+// stopPrefetch stops the prefetch goroutine, if any, without waiting for
+// it. The caller must hold the write lock.
+func (q *Queue) stopPrefetch() {
+	if q.prefetchStop != nil {
+		close(q.prefetchStop)
+	}
+	q.prefetchN = 0
+	q.prefetchWake = nil
+	q.prefetchStop = nil
+}
+
+// wakePrefetch makes the prefetch goroutine, if any, read ahead. The
+// caller must hold the lock.
+func (q *Queue) wakePrefetch() {
+	select {
+	case q.prefetchWake <- struct{}{}:
+	default:
+	}
+}
+
+// prefetchLoop reads ahead up to n items each time it is woken, until
+// stop is closed.
+func (q *Queue) prefetchLoop(n int, wake, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-wake:
+		}
+		if err := q.fillPrefetched(n, stop); err != nil {
+			q.log(LogError, "prefetch items", "dataDir", q.DataDir, "error", err)
+		}
+	}
+}
+
+// fillPrefetched reads the items following the prefetched items, or the
+// head, until n items are prefetched.
+//
+// Only the read lock is held while reading, so that Enqueue and Dequeue
+// are not held up by the disk. As the write lock drops the prefetched
+// items, Dequeue is then the only other way they can change, by taking
+// them or moving the head past them.
+func (q *Queue) fillPrefetched(n int, stop <-chan struct{}) error {
+	q.RLock()
+	defer q.RUnlock()
+
+	// Check if prefetching was stopped in the meantime.
+	select {
+	case <-stop:
+		return nil
+	default:
+	}
+
+	// Find the items to read, up to the current tail.
+	q.headMu.RLock()
+	q.tailMu.RLock()
+	q.prefetchMu.Lock()
+	want := n - len(q.prefetched)
+	after := q.lastPrefetched()
+	q.prefetchMu.Unlock()
+	tail := q.tail
+	q.tailMu.RUnlock()
+	q.headMu.RUnlock()
+	if want <= 0 || after >= tail {
+		return nil
+	}
+
+	// Read the items after the last one prefetched.
+	items := make([]*Item, 0, want)
+	iter := q.db.NewIterator(q.rangeFrom(after+1), nil)
+	for len(items) < want && iter.Next() {
+		item := q.newItemFromIterator(iter)
+		if item.ID > tail {
+			q.ReleaseItem(item)
+			break
+		}
+		if err := checkItem(item); err != nil {
+			q.ReleaseItem(item)
+			break
+		}
+		items = append(items, item)
+	}
+	iter.Release()
+
+	// Add the items that are still ahead of the head and the items
+	// prefetched in the meantime.
+	q.headMu.RLock()
+	q.prefetchMu.Lock()
+	last := q.lastPrefetched()
+	for _, item := range items {
+		if item.ID <= last {
+			q.ReleaseItem(item)
+			continue
+		}
+		q.prefetched = append(q.prefetched, item)
+	}
+	q.prefetchMu.Unlock()
+	q.headMu.RUnlock()
+
+	return iter.Error()
+}
+
+// lastPrefetched returns the ID of the last prefetched item, or the
+// head if there is none. The caller must hold the lock of the head and
+// prefetchMu.
+func (q *Queue) lastPrefetched() uint64 {
+	if len(q.prefetched) == 0 {
+		return q.head
+	}
+	return q.prefetched[len(q.prefetched)-1].ID
+}
+
+// takePrefetched removes the prefetched item at the head of the queue
+// and returns it, or nil if it was not prefetched. The caller must hold
+// the lock of the head.
+func (q *Queue) takePrefetched() *Item {
+	if q.prefetchN == 0 {
+		return nil
+	}
+
+	q.prefetchMu.Lock()
+	defer q.prefetchMu.Unlock()
+
+	q.wakePrefetch()
+	if len(q.prefetched) == 0 || q.prefetched[0].ID != q.head+1 {
+		return nil
+	}
+	item := q.prefetched[0]
+	q.prefetched[0] = nil
+	q.prefetched = q.prefetched[1:]
+	return item
+}
+
+// dropPrefetched drops the prefetched items, which are then read again.
+// The caller must hold the write lock.
+func (q *Queue) dropPrefetched() {
+	if q.prefetchN == 0 {
+		return
+	}
+
+	q.prefetchMu.Lock()
+	for _, item := range q.prefetched {
+		q.ReleaseItem(item)
+	}
+	q.prefetched = nil
+	q.prefetchMu.Unlock()
+
+	q.wakePrefetch()
+}
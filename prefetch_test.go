@@ -0,0 +1,104 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// waitPrefetched waits until the queue has prefetched n items.
+func waitPrefetched(t *testing.T, q *Queue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		q.prefetchMu.Lock()
+		length := len(q.prefetched)
+		q.prefetchMu.Unlock()
+		if length == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("Expected %d prefetched items, got %d", n, length)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueuePrefetch(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err = q.Prefetch(4); err != nil {
+		t.Error(err)
+	}
+	waitPrefetched(t, q, 4)
+
+	dequeue := func(compStr string) {
+		t.Helper()
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		} else if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	// Dequeue takes the prefetched items, which are then read ahead
+	// again.
+	dequeue("value for item 1")
+	dequeue("value for item 2")
+	waitPrefetched(t, q, 4)
+
+	// Prefetched items that are changed or removed are read again.
+	if _, err = q.UpdateString(3, "new value for item 3"); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.DequeueByID(4); err != nil {
+		t.Error(err)
+	}
+	dequeue("new value for item 3")
+	dequeue("value for item 5")
+
+	for i := 6; i <= 10; i++ {
+		dequeue(fmt.Sprintf("value for item %d", i))
+	}
+
+	if _, err = q.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	// Items added later are read ahead as well.
+	if _, err = q.EnqueueString("value for item 11"); err != nil {
+		t.Error(err)
+	}
+	waitPrefetched(t, q, 1)
+	dequeue("value for item 11")
+
+	if q.Length() != 0 || q.SizeBytes() != 0 {
+		t.Errorf("Expected queue length and size of 0, got %d and %d", q.Length(), q.SizeBytes())
+	}
+
+	// Closing the queue stops prefetching.
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if q.prefetchStop != nil || q.prefetchN != 0 {
+		t.Error("Expected prefetching to be stopped")
+	}
+
+	if err = q.Prefetch(4); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
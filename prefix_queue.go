@@ -296,6 +296,9 @@ func (pq *PrefixQueue) UpdateObject(prefix []byte, id uint64, newValue interface
 
 // Length returns the total number of items in the prefix queue.
 func (pq *PrefixQueue) Length() uint64 {
+	pq.RLock()
+	defer pq.RUnlock()
+
 	return pq.size
 }
 
@@ -309,16 +312,12 @@ func (pq *PrefixQueue) Close() error {
 		return nil
 	}
 
-	// Close the LevelDB database.
-	if err := pq.db.Close(); err != nil {
-		return err
-	}
-
 	// Reset size and set isOpen to false.
 	pq.size = 0
 	pq.isOpen = false
 
-	return nil
+	// Close the LevelDB database.
+	return pq.db.Close()
 }
 
 // Drop closes and deletes the LevelDB database of the prefix queue.
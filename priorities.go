@@ -0,0 +1,113 @@
+package goque
+
+import (
+	"fmt"
+	"math"
+)
+
+// prioritySeqBits is the number of bits of an item ID holding the
+// sequence of the item within its priority in a queue opened using
+// WithPriorities. The priority is held by the bits above them.
+const prioritySeqBits = 56
+
+// EnqueueWithPriority adds an item with the given priority to a queue
+// opened using WithPriorities, returning ErrPriorities otherwise. Items
+// with a lower priority number are dequeued first, and items with the
+// same priority in the order they were added.
+func (q *Queue) EnqueueWithPriority(value []byte, priority uint8) (*Item, error) {
+	if !q.priorities {
+		return nil, q.logError("enqueue", ErrPriorities)
+	}
+
+	item, evicted, _, err := q.enqueuePriority(value, itemHeader{}, priority)
+	if err != nil {
+		return nil, q.logError("enqueue", err)
+	}
+	q.evicted(evicted...)
+	q.enqueued(item)
+	return item, nil
+}
+
+// ItemPriority returns the priority of the item with the given ID, as
+// added using EnqueueWithPriority. Items of a queue opened without
+// WithPriorities have priority 0.
+func ItemPriority(id uint64) uint8 {
+	return uint8(id >> prioritySeqBits)
+}
+
+// lastID returns the ID of the last item added with the given priority,
+// which is the tail unless the queue uses priorities. The caller must
+// hold the write lock, or the read lock and the lock of the tail.
+func (q *Queue) lastID(priority uint8) uint64 {
+	if !q.priorities {
+		return q.tail
+	}
+	return q.levelTails[priority]
+}
+
+// idsLeft returns the number of IDs left for new items with the given
+// priority. The caller must hold the lock, as for lastID.
+func (q *Queue) idsLeft(priority uint8) uint64 {
+	if !q.priorities {
+		return math.MaxUint64 - q.tail
+	}
+	return uint64(priority)<<prioritySeqBits | (1<<prioritySeqBits - 1) - q.levelTails[priority]
+}
+
+// addedPriority updates the head, tail, item count and size of a queue
+// using priorities after the given item has been added, which may be
+// in front of the head. The caller must hold the write lock.
+func (q *Queue) addedPriority(item *Item) {
+	if q.count == 0 || item.ID <= q.head {
+		q.head = item.ID - 1
+	}
+	if item.ID > q.tail {
+		q.tail = item.ID
+	}
+	q.levelTails[ItemPriority(item.ID)] = item.ID
+	q.count++
+	q.size += uint64(len(item.Value))
+}
+
+// initPriorities sets the last ID of each priority of a queue using
+// priorities from its items, stepping back from the last item to the
+// last item of each lower priority in turn. The tail counts as the last
+// ID of its priority, so the ID of a removed item at the tail is not
+// reused.
+func (q *Queue) initPriorities() error {
+	if !q.priorities {
+		return nil
+	}
+	q.resetPriorities()
+	q.levelTails[ItemPriority(q.tail)] = q.tail
+
+	iter := q.db.NewIterator(q.rangeFrom(1), nil)
+	defer iter.Release()
+
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		id := q.keyID(iter.Key())
+		p := ItemPriority(id)
+		if id > q.levelTails[p] {
+			q.levelTails[p] = id
+		}
+		if p == 0 || !iter.Seek(q.key(uint64(p)<<prioritySeqBits)) {
+			break
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	return nil
+}
+
+// resetPriorities starts each priority of a queue using priorities
+// over from its first ID.
+func (q *Queue) resetPriorities() {
+	if q.levelTails == nil {
+		q.levelTails = make([]uint64, 1<<8)
+	}
+	for p := range q.levelTails {
+		q.levelTails[p] = uint64(p) << prioritySeqBits
+	}
+}
@@ -0,0 +1,149 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueEnqueueWithPriority(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithPriorities())
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	// Add the items of a few priorities in mixed order.
+	for i := 1; i <= 3; i++ {
+		for _, p := range []uint8{2, 0, 255, 1} {
+			if _, err = q.EnqueueWithPriority([]byte(fmt.Sprintf("value for priority %d item %d", p, i)), p); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	if _, err = q.EnqueueString("value for priority 0 item 4"); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 13 {
+		t.Errorf("Expected queue length of 13, got %d", q.Length())
+	}
+
+	// The IDs are kept once reopened, so newer items still go after
+	// the older ones of their priority.
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.EnqueueWithPriority([]byte("value for priority 1 item 4"), 1); err != nil {
+		t.Error(err)
+	}
+
+	for _, p := range []uint8{0, 1, 2, 255} {
+		n := 3
+		if p <= 1 {
+			n = 4
+		}
+		for i := 1; i <= n; i++ {
+			item, err := q.Dequeue()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compStr := fmt.Sprintf("value for priority %d item %d", p, i)
+			if item.ToString() != compStr {
+				t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+			}
+			if ItemPriority(item.ID) != p {
+				t.Errorf("Expected priority to be %d, got %d", p, ItemPriority(item.ID))
+			}
+		}
+	}
+
+	if _, err = q.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+}
+
+func TestQueueEnqueueWithPriorityFront(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithPriorities())
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueWithPriority([]byte("low"), 5); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.Peek(); err != nil {
+		t.Error(err)
+	}
+
+	// Items of a lower priority number go in front of the head, and
+	// RequeueHead moves the head behind the other items of its priority.
+	if _, err = q.EnqueueWithPriority([]byte("high"), 3); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.EnqueueWithPriority([]byte("higher"), 3); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.RequeueHead(); err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Compact(); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.EnqueueWithPriority([]byte("lowest"), 5); err != nil {
+		t.Error(err)
+	}
+
+	for _, compStr := range []string{"higher", "high", "low", "lowest"} {
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+}
+
+func TestQueueWithPrioritiesErrors(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueWithPriority([]byte("value"), 1); err != ErrPriorities {
+		t.Errorf("Expected to get priorities error, got %v", err)
+	}
+
+	dst, err := OpenQueue(file+"_dst", WithPriorities())
+	if err != nil {
+		t.Error(err)
+	}
+	defer dst.Drop()
+
+	if _, err = dst.Receive(time.Minute); err != ErrPriorities {
+		t.Errorf("Expected to get priorities error, got %v", err)
+	}
+	txn := dst.Begin()
+	if _, err = txn.Enqueue([]byte("value")); err != ErrPriorities {
+		t.Errorf("Expected to get priorities error, got %v", err)
+	}
+	txn.Rollback()
+
+	if _, err = q.EnqueueString("value"); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.TransferTo(dst, 1); err != ErrPriorities {
+		t.Errorf("Expected to get priorities error, got %v", err)
+	}
+}
@@ -36,6 +36,11 @@ func (pl *priorityLevel) length() uint64 {
 
 // PriorityQueue is a standard FIFO (first in, first out) queue with
 // priority levels.
+//
+// Items are stored under keys made of the priority level followed by
+// their ID within that level, and each level keeps its own head and
+// tail. Dequeue returns the oldest item of the most important level
+// holding items, so items of the same priority stay in FIFO order.
 type PriorityQueue struct {
 	sync.RWMutex
 	DataDir  string
@@ -329,11 +334,6 @@ func (pq *PriorityQueue) Close() error {
 		return nil
 	}
 
-	// Close the LevelDB database.
-	if err := pq.db.Close(); err != nil {
-		return err
-	}
-
 	// Reset head and tail of each priority level
 	// and set isOpen to false.
 	for i := 0; i <= 255; i++ {
@@ -342,7 +342,8 @@ func (pq *PriorityQueue) Close() error {
 	}
 	pq.isOpen = false
 
-	return nil
+	// Close the LevelDB database.
+	return pq.db.Close()
 }
 
 // Drop closes and deletes the LevelDB database of the priority queue.
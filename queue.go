@@ -2,11 +2,21 @@ package goque
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 // Queue is a standard FIFO (first in, first out) queue.
@@ -17,27 +27,345 @@ type Queue struct {
 	head    uint64
 	tail    uint64
 	isOpen  bool
+
+	// count is the number of items stored in the queue. It is less
+	// than tail - head if items were removed from within the queue.
+	count uint64
+
+	// size is the total length of the values of the items stored.
+	size uint64
+
+	// memory is true if the queue is kept in memory rather than
+	// in its data directory.
+	memory bool
+
+	// prefix is the prefix of every key of the queue, and shared is
+	// true if the database is not owned by the queue; see
+	// NewQueueFromDB.
+	prefix []byte
+	shared bool
+
+	// dbOptions holds the options used to open the LevelDB database.
+	dbOptions *opt.Options
+
+	// writeOptions holds the options used for every write to the
+	// LevelDB database.
+	writeOptions *opt.WriteOptions
+
+	// maxLength is the maximum number of items the queue may hold,
+	// or 0 if the queue is unbounded.
+	maxLength uint64
+
+	// maxValueSize is the maximum size of a value in bytes, or 0 if
+	// values are not limited.
+	maxValueSize int
+
+	// resetOnEmpty is whether the IDs of an empty queue start over
+	// from 1, set using WithResetOnEmpty.
+	resetOnEmpty bool
+
+	// priorities is whether the queue was opened using WithPriorities,
+	// and levelTails holds the ID of the last item added to each of its
+	// priority levels.
+	priorities bool
+	levelTails []uint64
+
+	// notify is closed and reset by Enqueue to wake any goroutines
+	// blocked in DequeueCtx. It is nil while nobody is waiting.
+	notify chan struct{}
+
+	// watchers holds the channels returned by Watch, which are
+	// signalled along with notify and closed by Close.
+	watchers []chan struct{}
+
+	// readyAt is the earliest time an item that was skipped by the
+	// last scan for the next item becomes ready, or the zero time.
+	readyAt time.Time
+
+	// inFlight is the number of items received using Receive that
+	// are in flight, nextDeadline is the earliest of their visibility
+	// deadlines, or the zero time, and receipt is the latest receipt
+	// handed out.
+	inFlight     uint64
+	nextDeadline time.Time
+	receipt      uint64
+
+	// epoch changes whenever the IDs of removed items may be given to
+	// other items, such as by Compact or once the queue is reopened,
+	// so that a DeleteToken cannot match those items.
+	epoch uint64
+
+	// closing is true while the queue is shut down using Shutdown.
+	closing bool
+
+	// retryPolicy determines how often failed reads and writes are
+	// retried.
+	retryPolicy RetryPolicy
+
+	// leaseTimeout is the lease timeout of ReceiveHead, or 0 for
+	// DefaultLeaseTimeout.
+	leaseTimeout time.Duration
+
+	// deadLetter and maxReceives are set using WithDeadLetter, and
+	// deadPending is true once in-flight items are due to be moved
+	// to the dead-letter queue.
+	deadLetter  *Queue
+	maxReceives uint64
+	deadPending bool
+
+	// checksums is whether items are stored with a checksum, and
+	// compression the compression used for their values.
+	checksums   bool
+	compression Compression
+
+	// keyCodec converts item IDs to keys, or is nil for the default
+	// 8 byte big-endian keys.
+	keyCodec KeyCodec
+
+	// namespaced is whether the item and metadata keys of the queue
+	// are kept apart under namespaces, and keys holds the prefixes of
+	// its keys; see WithKeyNamespaces.
+	namespaced bool
+	keys       keySpace
+
+	// readOnly is whether the queue was opened read-only.
+	readOnly bool
+
+	// skipMissing is whether items missing from the database are
+	// skipped when reached by Dequeue and Peek.
+	skipMissing bool
+
+	// enqueueTime is whether the time each item is enqueued is
+	// stored with it.
+	enqueueTime bool
+
+	// archive is whether dequeued items are kept in the archive of the
+	// queue, set using WithArchive, and archiveHead and archiveTail are
+	// the positions in front of the oldest and at the newest archived
+	// item.
+	archive     bool
+	archiveHead uint64
+	archiveTail uint64
+
+	// scanChunk is the number of items read by ForEach and Export
+	// before releasing the iterator and the read lock, or 0 to read
+	// all items using a single iterator.
+	scanChunk int
+
+	// onEnqueue and onDequeue are the hooks set using SetHooks.
+	onEnqueue func(*Item)
+	onDequeue func(*Item)
+
+	// overflow is the overflow policy of the queue, and onEvict is
+	// the hook set using SetEvictHook.
+	overflow OverflowPolicy
+	onEvict  func(*Item)
+
+	// logger is the logger set using WithLogger or SetLogger.
+	logger Logger
+
+	// tracer holds the Tracer set using WithTracer or SetTracer, which
+	// is read without taking the lock.
+	tracer atomic.Value
+
+	// pool holds the released items if the queue was opened with
+	// WithItemPool, otherwise it is nil.
+	pool *sync.Pool
+
+	// codec is the codec used by the object helper functions, or
+	// nil to use encoding/gob.
+	codec ObjectCodec
+
+	// repair is whether invalid keys are removed when the queue is
+	// opened, and repaired is the number of keys removed. recovered
+	// is whether the queue is being recovered, so its stored state
+	// is not used.
+	repair    bool
+	repaired  int
+	recovered bool
+
+	// storedState is whether the state of the queue is stored along
+	// with each change to its items. It is set using WithStoredState,
+	// and for queues using key namespaces, which mark the namespaces
+	// using the stored state.
+	storedState bool
+
+	// lockOrder is a unique number used to lock several queues in a
+	// consistent order.
+	lockOrder uint64
+
+	// headMu and tailMu let Dequeue and Enqueue change the head and
+	// the tail of the queue while holding only the read lock, so that
+	// they do not block each other while reading items. The item count
+	// and size are guarded by tailMu, which Dequeue also takes while
+	// writing, as each write stores the state of both the head and the
+	// tail. Code holding the write lock needs neither,
+	// while other code holding the read lock takes both using rlock.
+	// The locks are taken after the queue lock, headMu before tailMu.
+	headMu sync.RWMutex
+	tailMu sync.RWMutex
+
+	// headCache holds the *cachedHead that Peek returns without taking
+	// any lock. It is dropped whenever the write lock or the lock of
+	// the head is taken.
+	headCache atomic.Value
+
+	// prefetched holds the items read ahead of the head of the queue
+	// by the goroutine started using Prefetch, guarded by prefetchMu,
+	// which is taken after the other locks. The goroutine reads up to
+	// prefetchN items, is woken using prefetchWake and stopped by
+	// closing prefetchStop, all of which are set under the write lock.
+	prefetched   []*Item
+	prefetchMu   sync.Mutex
+	prefetchN    int
+	prefetchWake chan struct{}
+	prefetchStop chan struct{}
+}
+
+// queueCount is the number of queues created, used to hand out the
+// lock order of each queue.
+var queueCount uint64
+
+// QueueStats is a point-in-time snapshot of the internal state
+// of a queue.
+type QueueStats struct {
+	// Head is the ID of the last item removed from the queue.
+	Head uint64
+
+	// Tail is the ID of the last item added to the queue.
+	Tail uint64
+
+	// Length is the total number of items in the queue.
+	Length uint64
+
+	// DataDir is the data directory of the queue.
+	DataDir string
 }
 
 // OpenQueue opens a queue if one exists at the given directory. If one
 // does not already exist, a new queue is created.
 // If the underlying database is corrupt, an error for which
 // IsCorrupted() returns true is returned.
-func OpenQueue(dataDir string) (*Queue, error) {
-	return openQueue(dataDir, leveldb.OpenFile)
+//
+// Once a queue has been opened using WithStoredState, older versions of
+// Goque cannot open it until it is opened once without that option.
+func OpenQueue(dataDir string, opts ...QueueOption) (*Queue, error) {
+	return openQueue(dataDir, leveldb.OpenFile, opts)
+}
+
+// OpenQueueCtx is like OpenQueue, but returns ctx.Err() if the context
+// is done before the queue is open, such as while the directory is
+// still locked by another process. The open itself cannot be cancelled,
+// so it carries on in the background, and the queue is closed again
+// once it has been opened.
+func OpenQueueCtx(ctx context.Context, dataDir string, opts ...QueueOption) (*Queue, error) {
+	return openQueueCtx(ctx, dataDir, leveldb.OpenFile, opts)
+}
+
+// openQueueCtx opens a queue like openQueue, returning early if the
+// context is done first.
+func openQueueCtx(ctx context.Context, dataDir string, open levelDbOpener, opts []QueueOption) (*Queue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		q   *Queue
+		err error
+	}
+	opened := make(chan result)
+	go func() {
+		q, err := openQueue(dataDir, open, opts)
+		select {
+		case opened <- result{q, err}:
+		case <-ctx.Done():
+			// Nobody is waiting for the queue anymore.
+			if err == nil {
+				q.Close()
+			}
+		}
+	}()
+
+	select {
+	case r := <-opened:
+		return r.q, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// OpenQueueWithOptions is like OpenQueue, but opens the underlying
+// LevelDB database using the given options, such as the block cache
+// size or write buffer size. Nil options use the LevelDB defaults.
+func OpenQueueWithOptions(dataDir string, o *opt.Options, opts ...QueueOption) (*Queue, error) {
+	return openQueue(dataDir, leveldb.OpenFile, append([]QueueOption{withDBOptions(o)}, opts...))
 }
 
 // RecoverQueue attempts to recover a corrupt queue.
-func RecoverQueue(dataDir string) (*Queue, error) {
-	return openQueue(dataDir, leveldb.RecoverFile)
+func RecoverQueue(dataDir string, opts ...QueueOption) (*Queue, error) {
+	return openQueue(dataDir, leveldb.RecoverFile, append([]QueueOption{recovered()}, opts...))
+}
+
+// OpenQueueRecover is like RecoverQueue, but also repairs the keys of
+// the queue after a bad shutdown or a bug. Every key is checked, and
+// those that are not valid item keys are removed, after which the head
+// and tail are set from the remaining items. It returns the number of
+// keys removed.
+func OpenQueueRecover(dataDir string, opts ...QueueOption) (*Queue, int, error) {
+	q, err := openQueue(dataDir, leveldb.RecoverFile, append([]QueueOption{recovered(), withRepair()}, opts...))
+	return q, q.repaired, err
+}
+
+// OpenQueueReadOnly opens an existing queue at the given directory in
+// read-only mode, such as for a reporting tool. Methods that would
+// modify the queue return ErrReadOnly, while Peek, ForEach, Length and
+// the other read methods work as usual. Expired items are skipped, but
+// not removed.
+func OpenQueueReadOnly(dataDir string, opts ...QueueOption) (*Queue, error) {
+	return openQueue(dataDir, leveldb.OpenFile, append([]QueueOption{readOnly()}, opts...))
+}
+
+// OpenMemQueue opens a new, empty queue that is kept in memory rather
+// than on disk. It behaves like a queue opened with OpenQueue, but its
+// contents are lost when it is closed, and it has no data directory.
+func OpenMemQueue(opts ...QueueOption) (*Queue, error) {
+	return openQueue("", openMemDB, append([]QueueOption{inMemory()}, opts...))
+}
+
+// NewQueueFromDB returns a queue stored in the given LevelDB database,
+// which is managed by the caller, under the given key prefix. Several
+// queues, as well as other data, can be stored in the same database
+// using different prefixes, none of which may be a prefix of another.
+// If the database already holds items under the prefix, the queue
+// continues from them.
+//
+// The queue has no data directory. Closing the queue does not close
+// the database, and dropping the queue only deletes the keys under
+// its prefix.
+func NewQueueFromDB(db *leveldb.DB, keyPrefix []byte, opts ...QueueOption) (*Queue, error) {
+	// Create a new Queue.
+	q := &Queue{
+		db:     db,
+		prefix: append([]byte(nil), keyPrefix...),
+		shared: true,
+
+		lockOrder: atomic.AddUint64(&queueCount, 1),
+	}
+
+	// Apply the queue options.
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	// Set isOpen and initialize.
+	q.isOpen = true
+	return q, q.logOpen(q.init())
 }
 
 // openQueue opens a queue if one exists at the given directory
 // using the specified opener. If one
 // does not already exist, a new queue is created.
-func openQueue(dataDir string, open levelDbOpener) (*Queue, error) {
-	var err error
-
+func openQueue(dataDir string, open levelDbOpener, opts []QueueOption) (*Queue, error) {
 	// Create a new Queue.
 	q := &Queue{
 		DataDir: dataDir,
@@ -45,261 +373,2902 @@ func openQueue(dataDir string, open levelDbOpener) (*Queue, error) {
 		head:    0,
 		tail:    0,
 		isOpen:  false,
-	}
 
-	// Open database for the queue.
-	q.db, err = open(dataDir, nil)
-	if err != nil {
-		return q, err
+		lockOrder: atomic.AddUint64(&queueCount, 1),
 	}
 
-	// Check if this Goque type can open the requested data directory.
-	ok, err := checkGoqueType(dataDir, goqueQueue)
-	if err != nil {
-		return q, err
-	}
-	if !ok {
-		return q, ErrIncompatibleType
+	// Apply the queue options.
+	for _, opt := range opts {
+		opt(q)
 	}
 
-	// Set isOpen and return.
-	q.isOpen = true
-	return q, q.init()
+	return q, q.logOpen(q.open(open))
 }
 
-// Enqueue adds an item to the queue.
-func (q *Queue) Enqueue(value []byte) (*Item, error) {
+// Open reopens a queue that has been closed, using the same data
+// directory and options it was originally opened with. If the queue
+// is already open, Open does nothing and returns nil.
+//
+// Reopening an in-memory queue gives a new, empty queue. A queue
+// created using NewQueueFromDB is read again from its database, which
+// must still be open.
+func (q *Queue) Open() error {
 	q.Lock()
 	defer q.Unlock()
 
-	// Check if queue is closed.
-	if !q.isOpen {
-		return nil, ErrDBClosed
+	// Check if queue is already open.
+	if q.isOpen {
+		return nil
 	}
 
-	// Create new Item.
-	item := &Item{
-		ID:    q.tail + 1,
-		Key:   idToKey(q.tail + 1),
-		Value: value,
+	if q.shared {
+		q.isOpen = true
+		return q.logOpen(q.init())
 	}
 
-	// Add it to the queue.
-	if err := q.db.Put(item.Key, item.Value, nil); err != nil {
-		return nil, err
+	if q.memory {
+		return q.logOpen(q.open(openMemDB))
 	}
-
-	// Increment tail position.
-	q.tail++
-
-	return item, nil
+	return q.logOpen(q.open(leveldb.OpenFile))
 }
 
-// EnqueueString is a helper function for Enqueue that accepts a
-// value as a string rather than a byte slice.
-func (q *Queue) EnqueueString(value string) (*Item, error) {
-	return q.Enqueue([]byte(value))
-}
+// open opens the database of the queue using the specified opener and
+// initializes the queue data.
+func (q *Queue) open(open levelDbOpener) error {
+	var err error
 
-// EnqueueObject is a helper function for Enqueue that accepts any
-// value type, which is then encoded into a byte slice using
-// encoding/gob.
-func (q *Queue) EnqueueObject(value interface{}) (*Item, error) {
-	var buffer bytes.Buffer
-	enc := gob.NewEncoder(&buffer)
-	if err := enc.Encode(value); err != nil {
-		return nil, err
+	// Open database for the queue.
+	o := q.dbOptions
+	if q.readOnly {
+		o = &opt.Options{}
+		if q.dbOptions != nil {
+			*o = *q.dbOptions
+		}
+		o.ReadOnly = true
+	}
+	q.db, err = open(q.DataDir, o)
+	if err != nil {
+		return err
 	}
-	return q.Enqueue(buffer.Bytes())
-}
-
-// Dequeue removes the next item in the queue and returns it.
-func (q *Queue) Dequeue() (*Item, error) {
-	q.Lock()
-	defer q.Unlock()
 
-	// Check if queue is closed.
-	if !q.isOpen {
-		return nil, ErrDBClosed
+	// A read-only queue must not create the type file.
+	if q.readOnly && !q.memory {
+		if _, err := os.Stat(filepath.Join(q.DataDir, "GOQUE")); err != nil {
+			q.closeDB()
+			return err
+		}
 	}
 
-	// Try to get the next item in the queue.
-	item, err := q.getItemByID(q.head + 1)
-	if err != nil {
-		return nil, err
+	// Check if this Goque type can open the requested data directory.
+	if !q.memory {
+		ok, err := checkGoqueType(q.DataDir, goqueQueue)
+		if err != nil {
+			q.closeDB()
+			return err
+		}
+		if !ok {
+			q.closeDB()
+			return ErrIncompatibleType
+		}
 	}
 
-	// Remove this item from the queue.
-	if err := q.db.Delete(item.Key, nil); err != nil {
-		return nil, err
+	// Set isOpen and initialize.
+	q.isOpen = true
+	if err := q.init(); err != nil {
+		q.isOpen = false
+		q.closeDB()
+		return err
 	}
+	return nil
+}
 
-	// Increment head position.
-	q.head++
+// Enqueue adds an item to the queue. If the queue has reached its
+// maximum length, ErrFull is returned, unless the queue was opened
+// with the DropOldest overflow policy. If the tail of the queue has
+// reached the largest possible ID, ErrIDExhausted is returned; see
+// Compact.
+func (q *Queue) Enqueue(value []byte) (*Item, error) {
+	if end := q.startSpan(context.Background(), "enqueue"); end != nil {
+		item, err := q.enqueueValue(value)
+		endSpan(end, item, err)
+		return item, err
+	}
+	return q.enqueueValue(value)
+}
 
+// enqueueValue is Enqueue without tracing.
+func (q *Queue) enqueueValue(value []byte) (*Item, error) {
+	item, evicted, _, err := q.enqueue(value, itemHeader{})
+	if err != nil {
+		return nil, q.logError("enqueue", err)
+	}
+	q.evicted(evicted...)
+	q.enqueued(item)
 	return item, nil
 }
 
-// Peek returns the next item in the queue without removing it.
-func (q *Queue) Peek() (*Item, error) {
-	q.RLock()
-	defer q.RUnlock()
+// EnqueueWithLength is like Enqueue, but also returns the length of the
+// queue right after the item was added, as seen under the same lock, so
+// that no other Enqueue or Dequeue can change it in between, such as
+// for a producer applying back-pressure.
+func (q *Queue) EnqueueWithLength(value []byte) (*Item, uint64, error) {
+	item, evicted, length, err := q.enqueue(value, itemHeader{})
+	if err != nil {
+		return nil, 0, q.logError("enqueue", err)
+	}
+	q.evicted(evicted...)
+	q.enqueued(item)
+	return item, length, nil
+}
 
-	// Check if queue is closed.
-	if !q.isOpen {
-		return nil, ErrDBClosed
+// EnqueueWithTTL adds an item to the queue that expires after the
+// given duration. Expired items are skipped and removed by Dequeue
+// and Peek once they reach the head of the queue.
+//
+// Expiry is evaluated lazily when items are accessed, not by a
+// background sweeper, so expired items keep using disk space and
+// count towards Length until then.
+func (q *Queue) EnqueueWithTTL(value []byte, ttl time.Duration) (*Item, error) {
+	item, evicted, _, err := q.enqueue(value, itemHeader{expiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return nil, q.logError("enqueue", err)
 	}
+	q.evicted(evicted...)
+	q.enqueued(item)
+	return item, nil
+}
 
-	return q.getItemByID(q.head + 1)
+// EnqueueAt adds an item to the queue that is not removed by Dequeue
+// before the given time. Until then, Dequeue and Peek skip the item and
+// return the first item after it that is ready, so items may leave the
+// queue out of order.
+//
+// Finding a ready item means scanning past all of the items in front of
+// it that are not ready yet, so Dequeue takes O(n) time in the number
+// of such items.
+func (q *Queue) EnqueueAt(value []byte, notBefore time.Time) (*Item, error) {
+	item, evicted, _, err := q.enqueue(value, itemHeader{notBefore: notBefore})
+	if err != nil {
+		return nil, q.logError("enqueue", err)
+	}
+	q.evicted(evicted...)
+	q.enqueued(item)
+	return item, nil
 }
 
-// PeekByOffset returns the item located at the given offset,
-// starting from the head of the queue, without removing it.
-func (q *Queue) PeekByOffset(offset uint64) (*Item, error) {
-	q.RLock()
-	defer q.RUnlock()
+// enqueue adds an item with the given header to the queue, and returns
+// it along with any items evicted to make room for it and the length of
+// the queue right after adding it.
+func (q *Queue) enqueue(value []byte, h itemHeader) (*Item, []*Item, uint64, error) {
+	return q.enqueuePriority(value, h, 0)
+}
 
-	// Check if queue is closed.
-	if !q.isOpen {
-		return nil, ErrDBClosed
+// enqueuePriority is enqueue for an item with the given priority, which
+// must be 0 unless the queue uses priorities.
+func (q *Queue) enqueuePriority(value []byte, h itemHeader, priority uint8) (*Item, []*Item, uint64, error) {
+	// Check the size of the value.
+	if err := q.checkValueSize(value); err != nil {
+		return nil, nil, 0, err
 	}
 
-	return q.getItemByID(q.head + offset + 1)
-}
+	// Record the enqueue time and encode the value before taking the
+	// lock, as compressing it may take a while.
+	if q.enqueueTime {
+		h.enqueuedAt = time.Now()
+	}
+	data := q.encodeValue(h, value)
 
-// PeekByID returns the item with the given ID without removing it.
-func (q *Queue) PeekByID(id uint64) (*Item, error) {
-	q.RLock()
-	defer q.RUnlock()
+	// Only the tail is locked, unless the oldest items may have to be
+	// evicted from the head, or the head may be reset or moved in front
+	// of the new item.
+	if q.overflow == DropOldest || q.resetOnEmpty || q.priorities {
+		q.Lock()
+		defer q.Unlock()
+	} else {
+		q.RLock()
+		defer q.RUnlock()
+		q.tailMu.Lock()
+		defer q.tailMu.Unlock()
+	}
 
 	// Check if queue is closed.
 	if !q.isOpen {
-		return nil, ErrDBClosed
+		return nil, nil, 0, ErrDBClosed
 	}
 
-	return q.getItemByID(id)
-}
-
-// Update updates an item in the queue without changing its position.
-func (q *Queue) Update(id uint64, newValue []byte) (*Item, error) {
-	q.Lock()
-	defer q.Unlock()
+	// Check if queue is read-only or closing.
+	if q.readOnly {
+		return nil, nil, 0, ErrReadOnly
+	} else if q.closing {
+		return nil, nil, 0, ErrClosing
+	}
 
-	// Check if queue is closed.
-	if !q.isOpen {
-		return nil, ErrDBClosed
+	// Check if there is an ID left for the item.
+	q.resetIfEmpty()
+	if q.idsLeft(priority) == 0 {
+		return nil, nil, 0, ErrIDExhausted
 	}
 
-	// Check if item exists in queue.
-	if id <= q.head || id > q.tail {
-		return nil, ErrOutOfBounds
+	// Check if queue is full, and evict the oldest item if allowed.
+	batch := new(leveldb.Batch)
+	evicted, err := q.evict(batch, 1)
+	if err != nil {
+		return nil, nil, 0, err
 	}
 
 	// Create new Item.
+	id := q.lastID(priority) + 1
 	item := &Item{
-		ID:    id,
-		Key:   idToKey(id),
-		Value: newValue,
+		ID:         id,
+		Key:        q.key(id),
+		Value:      value,
+		ExpiresAt:  h.expiresAt,
+		EnqueuedAt: h.enqueuedAt,
+		NotBefore:  h.notBefore,
+		unique:     h.unique,
+		codec:      q.codec,
 	}
 
-	// Update this item in the queue.
-	if err := q.db.Put(item.Key, item.Value, nil); err != nil {
-		return nil, err
+	// Check if an identical unique item is in the queue. The item is
+	// added to the index in the same batch as the item itself.
+	if h.unique {
+		var ok bool
+		if err := q.retry(func() (err error) {
+			ok, err = q.db.Has(q.uniqueKey(value), nil)
+			return err
+		}); err != nil {
+			return nil, nil, 0, fmt.Errorf("goque: get unique key: %w", err)
+		} else if ok {
+			return nil, nil, 0, errDuplicate
+		}
+		batch.Put(q.uniqueKey(value), nil)
 	}
 
-	return item, nil
-}
+	// Add it to the queue.
+	batch.Put(item.Key, data)
+	q.putState(batch, q.state().removeAll(evicted).add(item))
+	if err := q.retry(func() error { return q.db.Write(batch, q.writeOptions) }); err != nil {
+		return nil, nil, 0, fmt.Errorf("goque: put item %d: %w", item.ID, err)
+	}
 
-// UpdateString is a helper function for Update that accepts a value
-// as a string rather than a byte slice.
-func (q *Queue) UpdateString(id uint64, newValue string) (*Item, error) {
-	return q.Update(id, []byte(newValue))
+	// Increment tail position, item count and size, and move head
+	// position past the evicted item. With priorities, the item may
+	// go in front of the head, so it is added once the evicted item is
+	// removed.
+	if q.priorities {
+		if err := q.removeEvicted(evicted); err != nil {
+			return nil, nil, 0, err
+		}
+		q.addedPriority(item)
+	} else {
+		q.tail++
+		q.count++
+		q.size += uint64(len(item.Value))
+		if err := q.removeEvicted(evicted); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	// Wake any goroutines waiting for an item.
+	q.broadcast()
+
+	return item, evicted, q.length(), nil
 }
 
-// UpdateObject is a helper function for Update that accepts any
-// value type, which is then encoded into a byte slice using
-// encoding/gob.
-func (q *Queue) UpdateObject(id uint64, newValue interface{}) (*Item, error) {
-	var buffer bytes.Buffer
-	enc := gob.NewEncoder(&buffer)
-	if err := enc.Encode(newValue); err != nil {
-		return nil, err
+// EnqueueBatch adds the given values to the queue using a single
+// atomic write. Either all of the items are added or none are. If the
+// queue does not have room for all of the items, ErrFull is returned,
+// unless the queue was opened with the DropOldest overflow policy and
+// the items fit into the queue once its oldest items are evicted.
+func (q *Queue) EnqueueBatch(values [][]byte) ([]*Item, error) {
+	items, evicted, err := q.enqueueBatch(values)
+	if err != nil {
+		return nil, q.logError("enqueue", err)
 	}
-	return q.Update(id, buffer.Bytes())
+	q.evicted(evicted...)
+	q.enqueued(items...)
+	return items, nil
 }
 
-// Length returns the total number of items in the queue.
-func (q *Queue) Length() uint64 {
-	return q.tail - q.head
-}
+// enqueueBatch is EnqueueBatch without calling the hooks. It returns
+// the added items along with any items evicted to make room for them.
+func (q *Queue) enqueueBatch(values [][]byte) ([]*Item, []*Item, error) {
+	// Check the size of the values.
+	for _, value := range values {
+		if err := q.checkValueSize(value); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Record the enqueue time and encode the values before taking the
+	// lock, as compressing them may take a while.
+	var h itemHeader
+	if q.enqueueTime {
+		h.enqueuedAt = time.Now()
+	}
+	data := make([][]byte, len(values))
+	for i, value := range values {
+		data[i] = q.encodeValue(h, value)
+	}
 
-// Close closes the LevelDB database of the queue.
-func (q *Queue) Close() error {
 	q.Lock()
 	defer q.Unlock()
 
-	// Check if queue is already closed.
+	// Check if queue is closed.
 	if !q.isOpen {
-		return nil
+		return nil, nil, ErrDBClosed
 	}
 
-	// Close the LevelDB database.
-	if err := q.db.Close(); err != nil {
-		return err
+	// Check if queue is read-only or closing.
+	if q.readOnly {
+		return nil, nil, ErrReadOnly
+	} else if q.closing {
+		return nil, nil, ErrClosing
 	}
 
-	// Reset queue head and tail and set
-	// isOpen to false.
-	q.head = 0
-	q.tail = 0
-	q.isOpen = false
+	// Check if there are enough IDs left for the items.
+	q.resetIfEmpty()
+	if q.idsLeft(0) < uint64(len(values)) {
+		return nil, nil, ErrIDExhausted
+	}
 
-	return nil
-}
+	// Check if queue has room for the items, and evict the oldest
+	// items if allowed.
+	batch := new(leveldb.Batch)
+	evicted, err := q.evict(batch, uint64(len(values)))
+	if err != nil {
+		return nil, nil, err
+	}
 
-// Drop closes and deletes the LevelDB database of the queue.
-func (q *Queue) Drop() error {
-	if err := q.Close(); err != nil {
-		return err
+	// Create the new Items and add them to the batch.
+	var size uint64
+	state := q.state().removeAll(evicted)
+	items := make([]*Item, len(values))
+	for i, value := range values {
+		size += uint64(len(value))
+		id := q.lastID(0) + uint64(i) + 1
+		items[i] = &Item{
+			ID:         id,
+			Key:        q.key(id),
+			Value:      value,
+			EnqueuedAt: h.enqueuedAt,
+			codec:      q.codec,
+		}
+		batch.Put(items[i].Key, data[i])
+		state = state.add(items[i])
 	}
 
-	return os.RemoveAll(q.DataDir)
+	// Nothing to write.
+	if batch.Len() == 0 {
+		return items, nil, nil
+	}
+	q.putState(batch, state)
+
+	// Add them to the queue.
+	if err := q.retry(func() error { return q.db.Write(batch, q.writeOptions) }); err != nil {
+		return nil, nil, fmt.Errorf("goque: write batch: %w", err)
+	}
+
+	// Move tail position past the new items, and head position past
+	// the evicted items, adding the items once those are removed if
+	// they may go in front of the head, as for Enqueue.
+	if q.priorities {
+		if err := q.removeEvicted(evicted); err != nil {
+			return nil, nil, err
+		}
+		for _, item := range items {
+			q.addedPriority(item)
+		}
+	} else {
+		q.tail += uint64(len(items))
+		q.count += uint64(len(items))
+		q.size += size
+		if err := q.removeEvicted(evicted); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Wake any goroutines waiting for an item.
+	q.broadcast()
+
+	return items, evicted, nil
 }
 
-// getItemByID returns an item, if found, for the given ID.
-func (q *Queue) getItemByID(id uint64) (*Item, error) {
-	// Check if empty or out of bounds.
-	if q.Length() == 0 {
-		return nil, ErrEmpty
-	} else if id <= q.head || id > q.tail {
-		return nil, ErrOutOfBounds
+// EnqueueString is a helper function for Enqueue that accepts a
+// value as a string rather than a byte slice.
+func (q *Queue) EnqueueString(value string) (*Item, error) {
+	return q.Enqueue([]byte(value))
+}
+
+// EnqueueObject is a helper function for Enqueue that accepts any
+// value type, which is then encoded into a byte slice using the codec
+// of the queue, which is encoding/gob unless changed using SetCodec.
+//
+// A nil value returns ErrNilObject.
+func (q *Queue) EnqueueObject(value interface{}) (*Item, error) {
+	if value == nil {
+		return nil, ErrNilObject
 	}
 
-	// Get item from database.
-	var err error
-	item := &Item{ID: id, Key: idToKey(id)}
-	if item.Value, err = q.db.Get(item.Key, nil); err != nil {
+	data, err := q.marshalObject(value)
+	if err != nil {
 		return nil, err
 	}
-
-	return item, nil
+	return q.Enqueue(data)
 }
 
-// init initializes the queue data.
-func (q *Queue) init() error {
-	// Create a new LevelDB Iterator.
-	iter := q.db.NewIterator(nil, nil)
-	defer iter.Release()
+// EnqueueObjectAsJSON is like EnqueueObject, but always encodes the
+// value using encoding/json, whatever the codec of the queue, so that
+// the value can be read by programs not written in Go. Its value can
+// be decoded using Item.ToObjectFromJSON.
+//
+// A nil value returns ErrNilObject.
+func (q *Queue) EnqueueObjectAsJSON(value interface{}) (*Item, error) {
+	if value == nil {
+		return nil, ErrNilObject
+	}
 
-	// Set queue head to the first item.
-	if iter.First() {
-		q.head = keyToID(iter.Key()) - 1
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
 	}
+	return q.Enqueue(data)
+}
 
-	// Set queue tail to the last item.
-	if iter.Last() {
-		q.tail = keyToID(iter.Key())
+// Dequeue removes the next item in the queue and returns it. If the
+// queue is empty, ErrEmpty is returned, including when another
+// goroutine removed the last item since the length was checked.
+func (q *Queue) Dequeue() (*Item, error) {
+	if end := q.startSpan(context.Background(), "dequeue"); end != nil {
+		item, err := q.dequeueNext()
+		endSpan(end, item, err)
+		return item, err
 	}
+	return q.dequeueNext()
+}
+
+// dequeueNext is Dequeue without tracing.
+func (q *Queue) dequeueNext() (*Item, error) {
+	q.RLock()
 
-	return iter.Error()
+	// Check if queue is closed.
+	if !q.isOpen {
+		q.RUnlock()
+		return nil, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		q.RUnlock()
+		return nil, ErrReadOnly
+	}
+
+	// Remove the item at the head without the write lock if possible.
+	item, ok, err := q.dequeueHead()
+	q.RUnlock()
+	if !ok {
+		q.Lock()
+
+		// Check if queue is closed.
+		if !q.isOpen {
+			q.Unlock()
+			return nil, ErrDBClosed
+		}
+
+		item, err = q.dequeue()
+		q.Unlock()
+	}
+	if err != nil {
+		return nil, q.logError("dequeue", err)
+	}
+	q.dequeued(item)
+	return item, nil
+}
+
+// TryDequeue is like Dequeue, but returns a nil item and a nil error if
+// the queue is empty, so only actual failures return an error.
+func (q *Queue) TryDequeue() (*Item, error) {
+	item, err := q.Dequeue()
+	if err == ErrEmpty {
+		return nil, nil
+	}
+	return item, err
+}
+
+// DequeueBatch removes up to max items from the head of the queue
+// using a single atomic write and returns them. If the queue holds
+// fewer than max items, all remaining items are returned. ErrEmpty
+// is returned only if the queue is empty.
+//
+// Expired items in front of or between the returned items are removed
+// as well, but are not returned.
+func (q *Queue) DequeueBatch(max uint64) ([]*Item, error) {
+	items, err := q.dequeueBatch(max)
+	if err != nil {
+		return nil, q.logError("dequeue", err)
+	}
+	q.dequeued(items...)
+	return items, nil
+}
+
+// dequeueBatch is DequeueBatch without calling the dequeue hook.
+func (q *Queue) dequeueBatch(max uint64) ([]*Item, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	// Put back any due in-flight items first.
+	if err := q.requeueDue(time.Now()); err != nil {
+		return nil, err
+	}
+
+	// Check if empty.
+	if q.length() == 0 {
+		return nil, ErrEmpty
+	}
+
+	// Limit to the number of items available.
+	if max > q.length() {
+		max = q.length()
+	}
+
+	// Get the items and add their removal to a batch, skipping the
+	// items that are not ready yet.
+	var last, keep, removed, size, archived uint64
+	now := time.Now()
+	state := q.state()
+	batch := new(leveldb.Batch)
+	items := make([]*Item, 0, max)
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	for uint64(len(items)) < max && iter.Next() {
+		item := q.newItemFromIterator(iter)
+		if item.ID > q.tail {
+			break
+		}
+		if err := checkItem(item); err != nil {
+			iter.Release()
+			return nil, err
+		}
+		if !item.isExpired(now) && !item.isReady(now) {
+			if keep == 0 {
+				keep = item.ID
+			}
+			continue
+		}
+		if !item.isExpired(now) {
+			items = append(items, item)
+			archived = q.archiveItem(batch, archived, item.ID, iter.Value())
+		}
+		batch.Delete(item.Key)
+		q.unindex(batch, item)
+		state = state.remove(item)
+		removed++
+		last = item.ID
+		size += uint64(len(item.Value))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Remove these items from the queue.
+	if batch.Len() > 0 {
+		q.putState(batch, state)
+		if err := q.db.Write(batch, q.writeOptions); err != nil {
+			return nil, fmt.Errorf("goque: write batch: %w", err)
+		}
+		q.archiveTail += archived
+
+		// Move head position past the removed items, or to the
+		// first item kept.
+		q.count -= removed
+		q.size -= size
+		if keep == 0 {
+			q.head = last
+			if err := q.skipGaps(); err != nil {
+				return nil, err
+			}
+		} else if err := q.fixBounds(keep - 1); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every item was expired or not ready.
+	if len(items) == 0 {
+		return nil, ErrEmpty
+	}
+
+	return items, nil
+}
+
+// Trim removes the oldest items from the head of the queue until it
+// holds at most maxLen items, and returns the number of items removed.
+// The items are removed using a single atomic write.
+//
+// Unlike a queue limited using WithMaxLength, which rejects new items,
+// this keeps only the most recent items, such as for a rolling log.
+func (q *Queue) Trim(maxLen uint64) (uint64, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return 0, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	// Check if there is anything to remove.
+	if q.length() <= maxLen {
+		return 0, nil
+	}
+
+	return q.removeHead(q.length() - maxLen)
+}
+
+// Drain calls fn for each item in the queue, from the head up to the
+// tail at the time Drain is called, and removes each item once fn
+// returns nil. It returns the number of items removed. If fn returns
+// an error, the item is left at the head of the queue to be retried,
+// and Drain stops and returns the error along with the number of items
+// removed so far. Items added while Drain runs are left in the queue.
+//
+// No lock is held while fn runs, so fn may use the queue. An item taken
+// by another consumer while fn processes it is not removed again, but
+// has then been processed twice; use Receive where several consumers
+// must not process the same item.
+func (q *Queue) Drain(fn func(*Item) error) (uint64, error) {
+	q.rlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		q.runlock()
+		return 0, ErrDBClosed
+	}
+
+	// Stop at the current tail.
+	tail := q.tail
+	q.runlock()
+
+	return q.drain(context.Background(), tail, fn)
+}
+
+// drain passes the items of the queue up to the given tail to fn and
+// removes them, until there are none left, fn fails or the context is
+// done, and returns the number of items removed.
+func (q *Queue) drain(ctx context.Context, tail uint64, fn func(*Item) error) (uint64, error) {
+	var n uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		item, err := q.Peek()
+		if err == ErrEmpty || err == nil && item.ID > tail {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		// Process the item, then remove it unless it is gone.
+		if err := fn(item); err != nil {
+			return n, err
+		}
+		if _, err := q.DequeueByID(item.ID); err != nil && err != ErrOutOfBounds && err != ErrEmpty {
+			return n, err
+		}
+		n++
+	}
+}
+
+// Discard removes up to n items from the head of the queue without
+// returning them, such as to skip records known to be bad, and returns
+// the number of items removed. The items are removed using a single
+// atomic write. Expired items and items that are not ready yet count
+// towards n like any other item.
+//
+// As the items are not decoded or copied into Items, this is much
+// faster than calling Dequeue n times.
+func (q *Queue) Discard(n uint64) (uint64, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return 0, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	// Check if there is anything to remove.
+	if n == 0 || q.length() == 0 {
+		return 0, nil
+	}
+
+	return q.removeHead(n)
+}
+
+// removeHead removes up to n items from the head of the queue using a
+// single atomic write, and returns the number of items removed. The
+// caller must hold the write lock.
+func (q *Queue) removeHead(n uint64) (uint64, error) {
+	// Add the removal of the oldest items to a batch.
+	var removed, last, size uint64
+	batch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	for removed < n && iter.Next() {
+		id := q.keyID(iter.Key())
+		if id > q.tail {
+			break
+		}
+		batch.Delete(iter.Key())
+		h, value := decodeValue(iter.Value())
+		if h.unique {
+			batch.Delete(q.uniqueKey(value))
+		}
+		removed++
+		last = id
+		size += uint64(len(value))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Remove these items from the queue.
+	q.putState(batch, q.state().removeHead(last, removed, size))
+	if err := q.db.Write(batch, q.writeOptions); err != nil {
+		return 0, fmt.Errorf("goque: write batch: %w", err)
+	}
+
+	// Move head position past the removed items.
+	q.head = last
+	q.count -= removed
+	q.size -= size
+	return removed, q.skipGaps()
+}
+
+// DequeueCtx removes the next item in the queue and returns it. If the
+// queue is empty, DequeueCtx blocks until an item is enqueued or the
+// given context is done, in which case the context's error is returned
+// and the queue is left untouched.
+func (q *Queue) DequeueCtx(ctx context.Context) (*Item, error) {
+	if end := q.startSpan(ctx, "dequeue"); end != nil {
+		item, err := q.dequeueCtx(ctx)
+		endSpan(end, item, err)
+		return item, err
+	}
+	return q.dequeueCtx(ctx)
+}
+
+// dequeueCtx is DequeueCtx without tracing.
+func (q *Queue) dequeueCtx(ctx context.Context) (*Item, error) {
+	for {
+		q.Lock()
+
+		// Check if queue is closed.
+		if !q.isOpen {
+			q.Unlock()
+			return nil, ErrDBClosed
+		}
+
+		// Check if queue is read-only.
+		if q.readOnly {
+			q.Unlock()
+			return nil, ErrReadOnly
+		}
+
+		// Try to dequeue the next item.
+		item, err := q.dequeue()
+		if err == nil {
+			q.Unlock()
+			q.dequeued(item)
+			return item, nil
+		} else if err != ErrEmpty {
+			q.Unlock()
+			return nil, q.logError("dequeue", err)
+		}
+
+		// Register as a waiter before releasing the lock so no
+		// Enqueue can slip in unnoticed.
+		if q.notify == nil {
+			q.notify = make(chan struct{})
+		}
+		notify := q.notify
+		readyAt := q.wakeAt()
+		q.Unlock()
+
+		// Also wake up once the next delayed item is ready, or the
+		// next in-flight item goes back into the queue.
+		var timer *time.Timer
+		var ready <-chan time.Time
+		if !readyAt.IsZero() {
+			timer = time.NewTimer(time.Until(readyAt))
+			ready = timer.C
+		}
+
+		// Wait for an Enqueue or for the context to be done. All
+		// waiters are woken on each Enqueue, but since they retry
+		// under the lock only one of them receives the new item.
+		select {
+		case <-notify:
+		case <-ready:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Watch returns a channel that receives a value whenever items are
+// added to the queue, so a consumer can wait for it instead of polling
+// Dequeue. Each call returns a new channel. The channel is closed when
+// the queue is closed, or right away if it is already closed.
+//
+// Signals are coalesced: the channel buffers a single value, and items
+// added while it is full do not send another, so Enqueue never waits
+// for a watcher. After each signal, the consumer should therefore
+// dequeue until ErrEmpty is returned.
+func (q *Queue) Watch() <-chan struct{} {
+	q.Lock()
+	defer q.Unlock()
+
+	ch := make(chan struct{}, 1)
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		close(ch)
+		return ch
+	}
+
+	q.watchers = append(q.watchers, ch)
+	return ch
+}
+
+// DequeueByID removes the item with the given ID from the queue and
+// returns it, wherever it is in the queue. Removing an item other than
+// the one at the head leaves a gap in the IDs of the queue. The ID of a
+// removed item is not given to a new item, even if it was at the tail,
+// so IDs held onto by callers stay unique. Once the queue is reopened,
+// this only holds if it uses WithStoredState.
+func (q *Queue) DequeueByID(id uint64) (*Item, error) {
+	item, err := q.dequeueByID(id)
+	if err != nil {
+		return nil, q.logError("dequeue", err)
+	}
+	q.dequeued(item)
+	return item, nil
+}
+
+// dequeueByID is DequeueByID without calling the dequeue hook.
+func (q *Queue) dequeueByID(id uint64) (*Item, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	// Try to get the item.
+	item, err := q.getItemByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove this item from the queue.
+	if err := q.deleteItem(item); err != nil {
+		return nil, err
+	}
+
+	return item, q.removed(item)
+}
+
+// RequeueHead moves the next item in the queue to its tail and returns
+// it with its new ID, for a consumer that cannot process the item yet
+// but should not hold up the items behind it. The old key is deleted
+// and the new one added in a single atomic write under the write lock,
+// so no other consumer can dequeue the item while it is being moved.
+func (q *Queue) RequeueHead() (*Item, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	// Try to get the next item in the queue.
+	item, err := q.nextItem()
+	if err != nil {
+		return nil, err
+	}
+	priority := ItemPriority(item.ID)
+	if !q.priorities {
+		priority = 0
+	}
+	if q.idsLeft(priority) == 0 {
+		return nil, ErrIDExhausted
+	}
+
+	// Move the item to a new key after the tail, or after the last
+	// item of its priority.
+	id := q.lastID(priority) + 1
+	key := q.key(id)
+	batch := new(leveldb.Batch)
+	batch.Delete(item.Key)
+	batch.Put(key, q.encodeValue(item.header(), item.Value))
+	moved := *item
+	moved.ID, moved.Key = id, key
+	q.putState(batch, q.state().add(&moved).remove(item))
+	if err := q.db.Write(batch, q.writeOptions); err != nil {
+		return nil, fmt.Errorf("goque: put item %d: %w", id, err)
+	}
+
+	// Update tail position, then remove the item from its old
+	// position.
+	if q.priorities {
+		q.addedPriority(&moved)
+	} else {
+		q.tail = id
+		q.count++
+		q.size += uint64(len(item.Value))
+	}
+	old := *item
+	item.ID, item.Key = id, key
+
+	return item, q.removed(&old)
+}
+
+// Peek returns the next item in the queue without removing it.
+func (q *Queue) Peek() (*Item, error) {
+	if end := q.startSpan(context.Background(), "peek"); end != nil {
+		item, err := q.peekNext()
+		endSpan(end, item, err)
+		return item, err
+	}
+	return q.peekNext()
+}
+
+// peekNext is Peek without tracing.
+func (q *Queue) peekNext() (*Item, error) {
+	// Return the cached head item if it is still valid.
+	if item := q.loadHead(time.Now()); item != nil {
+		return q.unpackHead(item)
+	}
+
+	q.rlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		q.runlock()
+		return nil, ErrDBClosed
+	}
+
+	// Return the next item if possible without the write lock.
+	if item, ok, err := q.peekHead(time.Now()); ok {
+		q.runlock()
+		if err != nil {
+			return nil, err
+		}
+		return q.unpackHead(item)
+	}
+	q.runlock()
+
+	// Take the write lock to remove the expired or missing items and
+	// to find the first item that is ready.
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	return q.nextItem()
+}
+
+// PeekWithLength is like Peek, but also returns the length of the queue,
+// including the returned item, as of the same moment, which separate
+// calls to Peek and Length cannot guarantee. If the queue is empty, a
+// nil item, a length of 0 and ErrEmpty are returned.
+func (q *Queue) PeekWithLength() (*Item, uint64, error) {
+	q.rlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		q.runlock()
+		return nil, 0, ErrDBClosed
+	}
+
+	// Return the next item if possible without the write lock. The
+	// cached head item cannot be dropped while the lock is held.
+	now := time.Now()
+	item, ok, err := q.loadHead(now), true, error(nil)
+	if item == nil {
+		item, ok, err = q.peekHead(now)
+	}
+	if ok {
+		length := q.length()
+		q.runlock()
+		if err != nil {
+			return nil, 0, err
+		}
+		if item, err = q.unpackHead(item); err != nil {
+			return nil, 0, err
+		}
+		return item, length, nil
+	}
+	q.runlock()
+
+	// Take the write lock to remove the expired or missing items and
+	// to find the first item that is ready.
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, 0, ErrDBClosed
+	}
+
+	item, err = q.nextItem()
+	if err != nil {
+		return nil, 0, err
+	}
+	return item, q.length(), nil
+}
+
+// peekHead returns the item at the head of the queue, caching it for
+// Peek. Unless the item has expired, is not ready or is missing and can
+// be skipped, or in-flight items are due to go back into the queue, it
+// returns true along with the item or the error getting it. Otherwise
+// it returns false and the caller must use nextItem with the write
+// lock. The caller must hold the read lock taken by rlock, and must
+// decompress the value using unpackHead once it is released.
+func (q *Queue) peekHead(now time.Time) (*Item, bool, error) {
+	item, err := q.getPackedItemByID(q.head + 1)
+	if !q.requeuePending(now) && (err == nil && !item.isExpired(now) && item.isReady(now) || err != nil && !q.canSkip(err)) {
+		if err == nil {
+			q.cacheHead(item, now)
+		}
+		return item, true, err
+	}
+	return nil, false, nil
+}
+
+// TryPeek is like Peek, but returns a nil item and a nil error if the
+// queue is empty, so only actual failures return an error.
+func (q *Queue) TryPeek() (*Item, error) {
+	item, err := q.Peek()
+	if err == ErrEmpty {
+		return nil, nil
+	}
+	return item, err
+}
+
+// PeekTail returns the most recently enqueued item in the queue
+// without removing it, like PeekFromTail with an offset of 0.
+func (q *Queue) PeekTail() (*Item, error) {
+	return q.PeekFromTail(0)
+}
+
+// PeekByOffset returns the item located at the given offset,
+// starting from the head of the queue, without removing it.
+func (q *Queue) PeekByOffset(offset uint64) (*Item, error) {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Without gaps, the item ID follows from the offset.
+	if !q.hasGaps() {
+		return q.getItemByID(q.head + offset + 1)
+	}
+
+	// Check if out of bounds.
+	if offset >= q.length() {
+		return nil, ErrOutOfBounds
+	}
+
+	// Find the item by stepping over the items in front of it.
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	defer iter.Release()
+
+	if !q.seekOffset(iter, offset) {
+		if err := iter.Error(); err != nil {
+			return nil, fmt.Errorf("goque: iterate items: %w", err)
+		}
+		return nil, ErrOutOfBounds
+	}
+
+	item := q.newItemFromIterator(iter)
+	if err := checkItem(item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// PeekFromTail returns the item located at the given offset, starting
+// from the tail of the queue, without removing it. An offset of 0 is
+// the item at the tail, 1 the item in front of it, and so on.
+func (q *Queue) PeekFromTail(offset uint64) (*Item, error) {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Check if empty or out of bounds.
+	if q.length() == 0 {
+		return nil, ErrEmpty
+	} else if offset >= q.length() {
+		return nil, ErrOutOfBounds
+	}
+
+	// Without gaps, the item ID follows from the offset.
+	if !q.hasGaps() {
+		return q.getItemByID(q.tail - offset)
+	}
+
+	// Find the item by stepping back over the items behind it.
+	r := q.itemRange()
+	if q.tail < math.MaxUint64 {
+		r.Limit = q.key(q.tail + 1)
+	}
+	iter := q.db.NewIterator(r, nil)
+	defer iter.Release()
+
+	ok := iter.Last()
+	for i := uint64(0); ok && i < offset; i++ {
+		ok = iter.Prev()
+	}
+	if !ok {
+		if err := iter.Error(); err != nil {
+			return nil, fmt.Errorf("goque: iterate items: %w", err)
+		}
+		return nil, ErrOutOfBounds
+	}
+
+	item := q.newItemFromIterator(iter)
+	if err := checkItem(item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// PeekRange returns up to n items starting at the given offset from
+// the head of the queue, without removing them. If the range runs past
+// the tail of the queue, only the items that exist are returned.
+func (q *Queue) PeekRange(offset, n uint64) ([]*Item, error) {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	return q.peekRange(offset, n)
+}
+
+// peekRange is PeekRange without taking the lock. The caller must hold
+// the read lock taken by rlock.
+func (q *Queue) peekRange(offset, n uint64) ([]*Item, error) {
+	// Check if empty or out of bounds.
+	if q.length() == 0 {
+		return nil, ErrEmpty
+	} else if offset >= q.length() {
+		return nil, ErrOutOfBounds
+	}
+
+	// Limit to the number of items available.
+	if n > q.length()-offset {
+		n = q.length() - offset
+	}
+
+	// Iterate over the items from the offset.
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	defer iter.Release()
+
+	items := make([]*Item, 0, n)
+	for ok := q.seekOffset(iter, offset); ok && uint64(len(items)) < n; ok = iter.Next() {
+		item := q.newItemFromIterator(iter)
+		if item.ID > q.tail {
+			break
+		}
+		if err := checkItem(item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	return items, nil
+}
+
+// DeleteToken identifies the items returned by PeekN, so that exactly
+// those items can be removed using Commit once processed.
+type DeleteToken struct {
+	q   *Queue
+	ids []uint64
+
+	// epoch is the epoch of the queue when the items were peeked, so
+	// that an item given the ID of a peeked item, such as after Compact
+	// or by a queue opened using WithResetOnEmpty, is not mistaken for
+	// it.
+	epoch uint64
+}
+
+// PeekN returns up to n items from the head of the queue without
+// removing them, along with a token to remove them once they have been
+// processed using Commit. If the queue holds fewer than n items, all of
+// them are returned.
+func (q *Queue) PeekN(n uint64) ([]*Item, DeleteToken, error) {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, DeleteToken{}, ErrDBClosed
+	}
+
+	items, err := q.peekRange(0, n)
+	if err != nil {
+		return nil, DeleteToken{}, err
+	}
+
+	token := DeleteToken{q: q, ids: make([]uint64, len(items)), epoch: q.epoch}
+	for i, item := range items {
+		token.ids[i] = item.ID
+	}
+	return items, token, nil
+}
+
+// Commit removes the items of the given token returned by PeekN from
+// the head of the queue using a single atomic write. If the items are
+// no longer at the head of the queue, such as when some of them were
+// dequeued in the meantime, or other items took their IDs, such as
+// after Compact, ErrStaleToken is returned and nothing is removed.
+func (q *Queue) Commit(token DeleteToken) error {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	// Check the token belongs to this queue, and no IDs were given to
+	// other items since.
+	if token.q != q || token.epoch != q.epoch {
+		return ErrStaleToken
+	}
+	if len(token.ids) == 0 {
+		return nil
+	}
+
+	// Add the removal of the items to a batch, checking they are still
+	// the oldest items.
+	var removed, size, archived uint64
+	batch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	for removed < uint64(len(token.ids)) && iter.Next() {
+		id := q.keyID(iter.Key())
+		if id > q.tail || id != token.ids[removed] {
+			break
+		}
+		h, value := decodeValue(iter.Value())
+		batch.Delete(iter.Key())
+		archived = q.archiveItem(batch, archived, id, iter.Value())
+		if h.unique {
+			batch.Delete(q.uniqueKey(value))
+		}
+		removed++
+		size += uint64(len(value))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+	if removed != uint64(len(token.ids)) {
+		return ErrStaleToken
+	}
+
+	// Remove these items from the queue.
+	q.putState(batch, q.state().removeHead(token.ids[removed-1], removed, size))
+	if err := q.db.Write(batch, q.writeOptions); err != nil {
+		return fmt.Errorf("goque: write batch: %w", err)
+	}
+	q.archiveTail += archived
+
+	// Move head position past the removed items.
+	q.head = token.ids[removed-1]
+	q.count -= removed
+	q.size -= size
+	return q.skipGaps()
+}
+
+// PeekByID returns the item with the given ID without removing it.
+func (q *Queue) PeekByID(id uint64) (*Item, error) {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	return q.getItemByID(id)
+}
+
+// ForEach calls fn for each item in the queue, in order from head to
+// tail, without removing them. If fn returns an error, the iteration
+// stops and that error is returned.
+//
+// The read lock is held for the duration of the iteration, so fn must
+// not call any method that modifies the queue. For a queue opened using
+// WithScanChunk, the read lock is released between chunks of items
+// instead.
+func (q *Queue) ForEach(fn func(*Item) error) error {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Iterate over the items from the head.
+	return q.scanItems(func(iter iterator.Iterator) error {
+		item := q.newItemFromIterator(iter)
+		if err := checkItem(item); err != nil {
+			return err
+		}
+		return fn(item)
+	})
+}
+
+// CountFunc returns the number of items in the queue for which pred
+// returns true, without removing them.
+//
+// CountFunc visits every item using ForEach, so it takes O(n) time
+// while holding the read lock. It is meant for occasional inspection,
+// such as monitoring, not for use on every enqueue or dequeue.
+func (q *Queue) CountFunc(pred func(*Item) bool) (uint64, error) {
+	var n uint64
+	err := q.ForEach(func(item *Item) error {
+		if pred(item) {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// FindFunc returns the first item from the head of the queue for which
+// pred returns true, without removing it. If no item matches,
+// ErrNotFound is returned. Like CountFunc, it takes O(n) time in the
+// worst case while holding the read lock.
+func (q *Queue) FindFunc(pred func(*Item) bool) (*Item, error) {
+	var found *Item
+	err := q.ForEach(func(item *Item) error {
+		if pred(item) {
+			found = item
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+
+	return found, nil
+}
+
+// errFound stops the iteration of FindFunc once an item is found.
+var errFound = errors.New("goque: Item found")
+
+// Update updates the item with the given ID without changing its
+// position. The expiry, enqueue and not-before times of the item, if
+// any, are kept.
+//
+// ErrEmpty is returned if the queue is empty, and ErrOutOfBounds if the
+// ID is not within the queue.
+func (q *Queue) Update(id uint64, newValue []byte) (*Item, error) {
+	// Check the size of the value, and compress it before taking the
+	// lock.
+	if err := q.checkValueSize(newValue); err != nil {
+		return nil, err
+	}
+	packed := q.packValue(newValue)
+
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	// Get the current item to keep its metadata. This also checks
+	// that the item exists in the queue.
+	item, err := q.getItemByID(id)
+	if err != nil {
+		return nil, err
+	}
+	oldValue := item.Value
+	item.Value = newValue
+
+	// Update this item in the queue.
+	if err := q.putItem(item, oldValue, packed); err != nil {
+		return nil, err
+	}
+	q.size += uint64(len(item.Value)) - uint64(len(oldValue))
+
+	return item, nil
+}
+
+// UpdateBatch updates the values of several items in the queue using
+// a single atomic write, so either all of the items are updated or none
+// are. The updates map item IDs to their new values. If any of the IDs
+// is not in the queue, ErrOutOfBounds is returned before anything is
+// written.
+func (q *Queue) UpdateBatch(updates map[uint64][]byte) error {
+	// Check the size of the values, and compress them before taking
+	// the lock.
+	packed := make(map[uint64][]byte, len(updates))
+	for id, value := range updates {
+		if err := q.checkValueSize(value); err != nil {
+			return err
+		}
+		packed[id] = q.packValue(value)
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	// Get the current items to keep their metadata. This also checks
+	// that each item exists in the queue.
+	items := make([]*Item, 0, len(updates))
+	for id := range updates {
+		item, err := q.getItemByID(id)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+
+	// Add the new values to a batch. The old unique values are removed
+	// from the index first, so values swapped between items stay indexed.
+	batch := new(leveldb.Batch)
+	for _, item := range items {
+		if item.unique {
+			batch.Delete(q.uniqueKey(item.Value))
+		}
+	}
+	var size uint64
+	for _, item := range items {
+		value := updates[item.ID]
+		if item.unique {
+			batch.Put(q.uniqueKey(value), nil)
+		}
+		batch.Put(item.Key, q.encodePacked(item.header(), packed[item.ID]))
+		size += uint64(len(value)) - uint64(len(item.Value))
+	}
+	state := q.state()
+	state.size += size
+	q.putState(batch, state)
+
+	// Update these items in the queue.
+	if err := q.db.Write(batch, q.writeOptions); err != nil {
+		return fmt.Errorf("goque: write batch: %w", err)
+	}
+	q.size += size
+
+	return nil
+}
+
+// UpdateCAS updates the value of the given item to newValue only if
+// its currently stored value is equal to oldValue, returning whether
+// the value was updated. On success the value of the given item is set
+// to newValue.
+//
+// This allows callers that Peek an item and then update it to detect
+// that another goroutine updated the item in between, without any
+// locking of their own.
+func (q *Queue) UpdateCAS(item *Item, oldValue, newValue []byte) (bool, error) {
+	// Check the size of the value, and compress it before taking the
+	// lock.
+	if err := q.checkValueSize(newValue); err != nil {
+		return false, err
+	}
+	packed := q.packValue(newValue)
+
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return false, ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return false, ErrReadOnly
+	}
+
+	// Get the current item and compare its value. This also checks
+	// that the item exists in the queue.
+	current, err := q.getItemByID(item.ID)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(current.Value, oldValue) {
+		return false, nil
+	}
+	current.Value = newValue
+
+	// Update this item in the queue.
+	if err := q.putItem(current, oldValue, packed); err != nil {
+		return false, err
+	}
+	q.size += uint64(len(newValue)) - uint64(len(oldValue))
+	item.Value = newValue
+
+	return true, nil
+}
+
+// UpdateString is a helper function for Update that accepts a value
+// as a string rather than a byte slice.
+func (q *Queue) UpdateString(id uint64, newValue string) (*Item, error) {
+	return q.Update(id, []byte(newValue))
+}
+
+// UpdateObject is a helper function for Update that accepts any
+// value type, which is then encoded into a byte slice using the codec
+// of the queue, which is encoding/gob unless changed using SetCodec.
+//
+// A nil value returns ErrNilObject.
+func (q *Queue) UpdateObject(id uint64, newValue interface{}) (*Item, error) {
+	if newValue == nil {
+		return nil, ErrNilObject
+	}
+
+	data, err := q.marshalObject(newValue)
+	if err != nil {
+		return nil, err
+	}
+	return q.Update(id, data)
+}
+
+// SetCodec sets the codec used by the object helper functions of the
+// queue, such as EnqueueObject, UpdateObject and Item.ToObject. A nil
+// codec restores the default of encoding/gob.
+//
+// Values already stored are not converted, so the codec should be set
+// right after opening the queue.
+func (q *Queue) SetCodec(codec ObjectCodec) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.codec = codec
+}
+
+// SetHooks sets the functions called after each item is added to or
+// removed from the queue by the Enqueue and Dequeue methods, such as
+// to update metrics or write an audit log. A nil function disables
+// the respective hook.
+//
+// The hooks are called without the queue lock held and are passed a
+// copy of the item, so they may call other methods of the queue, but
+// they delay the return of the method calling them.
+func (q *Queue) SetHooks(onEnqueue, onDequeue func(*Item)) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.onEnqueue = onEnqueue
+	q.onDequeue = onDequeue
+}
+
+// SetEvictHook sets the function called after each item is evicted
+// from the queue to make room for a new item under the DropOldest
+// overflow policy. A nil function disables the hook. Like the hooks set
+// using SetHooks, it is called without the queue lock held and is
+// passed a copy of the item.
+func (q *Queue) SetEvictHook(onEvict func(*Item)) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.onEvict = onEvict
+}
+
+// ReleaseItem returns the given item to the item pool of a queue opened
+// with WithItemPool, so that its memory can be reused by the items
+// returned later on. The item, including its key and value, must not
+// be used after it has been released.
+//
+// Only items read from the database, such as by Dequeue or Peek, are
+// pooled. For other items, such as those returned by Enqueue, whose
+// value is the slice passed in by the caller, and for queues without
+// an item pool, ReleaseItem does nothing.
+func (q *Queue) ReleaseItem(item *Item) {
+	if q.pool == nil || item == nil || !item.pooled {
+		return
+	}
+	q.pool.Put(item)
+}
+
+// Sync makes all prior writes to the queue durable by writing a
+// synchronous no-op to the LevelDB journal. It blocks until the journal
+// has been flushed to disk, so every item enqueued or dequeued before
+// the call survives a machine crash.
+//
+// This gives a consistency point, such as before a planned restart,
+// without the cost of opening the queue with WithSyncWrites.
+func (q *Queue) Sync() error {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	// Empty batches are not written, so delete a key that never holds
+	// an item instead, as IDs start at 1.
+	batch := new(leveldb.Batch)
+	batch.Delete(q.key(0))
+	if err := q.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return fmt.Errorf("goque: sync: %w", err)
+	}
+
+	return nil
+}
+
+// SizeBytes returns the total length of the values of the items in the
+// queue, not including the metadata stored with them. It is kept up to
+// date as items are added, updated and removed, so calling it is cheap.
+func (q *Queue) SizeBytes() uint64 {
+	q.rlock()
+	defer q.runlock()
+
+	return q.size
+}
+
+// Length returns the total number of items in the queue.
+func (q *Queue) Length() uint64 {
+	q.rlock()
+	defer q.runlock()
+
+	return q.length()
+}
+
+// IsOpen returns true if the queue is open, or false if it has been
+// closed. Methods called on a closed queue return ErrDBClosed.
+func (q *Queue) IsOpen() bool {
+	q.RLock()
+	defer q.RUnlock()
+
+	return q.isOpen
+}
+
+// DB returns the underlying LevelDB database of the queue, such as to
+// read its properties or run custom iterators, or nil if the queue is
+// closed. The database is closed along with the queue.
+//
+// Writing to the database directly is unsafe, as the queue does not
+// know about the change; keys written or deleted within the key range
+// of the queue leave its head, tail and length out of sync.
+func (q *Queue) DB() *leveldb.DB {
+	q.RLock()
+	defer q.RUnlock()
+
+	if !q.isOpen {
+		return nil
+	}
+	return q.db
+}
+
+// Property returns the value of the given property of the underlying
+// LevelDB database, such as for a health endpoint. The supported names
+// are those of LevelDB:
+//
+//	leveldb.num-files-at-level{n}  the number of files at level n
+//	leveldb.stats                  statistics on compactions by level
+//	leveldb.sstables               the tables at each level
+//	leveldb.blockpool              the state of the block pool
+//	leveldb.cachedblock            the size of the block cache
+//	leveldb.openedtables           the number of open tables
+//	leveldb.alivesnaps             the number of unreleased snapshots
+//	leveldb.aliveiters             the number of unreleased iterators
+//
+// An unknown name returns the LevelDB not found error.
+func (q *Queue) Property(name string) (string, error) {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return "", ErrDBClosed
+	}
+
+	value, err := q.db.GetProperty(name)
+	if err != nil {
+		return "", fmt.Errorf("goque: get property %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// Stats returns a snapshot of the internal state of the queue.
+func (q *Queue) Stats() QueueStats {
+	q.rlock()
+	defer q.runlock()
+
+	return QueueStats{
+		Head:    q.head,
+		Tail:    q.tail,
+		Length:  q.length(),
+		DataDir: q.DataDir,
+	}
+}
+
+// Clear removes all items from the queue, including the items in
+// flight and those in its archive, and resets its head and tail, so
+// the next enqueued item is given an ID of 1 again. Unlike Drop, the
+// underlying database is kept open.
+func (q *Queue) Clear() error {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	// Remove every stored item from the queue.
+	if err := q.deleteKeys(); err != nil {
+		return err
+	}
+
+	// Reset queue head, tail, item count and size, and forget the
+	// in-flight items.
+	q.head = 0
+	q.tail = 0
+	q.count = 0
+	q.size = 0
+	q.inFlight = 0
+	q.nextDeadline = time.Time{}
+	q.deadPending = false
+	q.archiveHead = 0
+	q.archiveTail = 0
+	q.epoch++
+	if q.priorities {
+		q.resetPriorities()
+	}
+
+	return nil
+}
+
+// Compact moves the remaining items of the queue to the start of the
+// ID space, so the item at the head is given an ID of 1 again. This
+// reclaims the IDs used up by removed items and is most useful after
+// Enqueue returned ErrIDExhausted.
+//
+// The order of the items is kept, but their IDs change and any gaps
+// left by DequeueByID are closed. Any IDs held onto from before the
+// call no longer refer to the same items.
+func (q *Queue) Compact() error {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	// Check if already compact.
+	if q.head == 0 && !q.hasGaps() {
+		return nil
+	}
+
+	// Add the moves of the items to a batch. Items are moved in
+	// ascending order and always to a lower ID, so an old key is
+	// deleted before a new item is put in its place. With priorities,
+	// the items of each priority start over from its first ID.
+	var id, first uint64
+	batch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	for iter.Next() {
+		old := q.keyID(iter.Key())
+		if old > q.tail {
+			break
+		}
+		if p := ItemPriority(old); q.priorities && p != ItemPriority(id) {
+			id = uint64(p) << prioritySeqBits
+		}
+		id++
+		if first == 0 {
+			first = id
+		}
+		batch.Delete(iter.Key())
+		batch.Put(q.key(id), iter.Value())
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Move the items.
+	head := uint64(0)
+	if first > 0 {
+		head = first - 1
+	}
+	if batch.Len() > 0 {
+		state := q.state()
+		state.head, state.tail = head, id
+		q.putState(batch, state)
+		if err := q.db.Write(batch, q.writeOptions); err != nil {
+			return fmt.Errorf("goque: write batch: %w", err)
+		}
+	}
+
+	// Reset queue head and tail.
+	q.head = head
+	q.tail = id
+	q.epoch++
+
+	return q.initPriorities()
+}
+
+// CompactRange compacts the underlying LevelDB database over its full
+// key space, reclaiming the disk space held by dequeued items, such as
+// after draining a large queue. Unlike Compact, it does not change the
+// IDs of the items.
+//
+// The queue lock is only held to check that the queue is open, so other
+// operations may run while the compaction is in progress.
+func (q *Queue) CompactRange() error {
+	q.RLock()
+	db := q.db
+	isOpen := q.isOpen
+	q.RUnlock()
+
+	// Check if queue is closed.
+	if !isOpen {
+		return ErrDBClosed
+	}
+
+	// Check if queue is read-only.
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	// The range covers the removed items below the head as well as
+	// the items in the queue.
+	if r := q.keyRange(); r != nil {
+		return db.CompactRange(*r)
+	}
+	return db.CompactRange(util.Range{})
+}
+
+// Close closes the LevelDB database of the queue. A queue created using
+// NewQueueFromDB is closed without closing its database.
+//
+// Any error closing the database is returned, such as a failure to
+// write out buffered data. The queue is closed either way.
+func (q *Queue) Close() error {
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is already closed.
+	if !q.isOpen {
+		return nil
+	}
+
+	// Reset queue head, tail, item count and size
+	// and set isOpen to false.
+	q.head = 0
+	q.tail = 0
+	q.count = 0
+	q.size = 0
+	q.isOpen = false
+	q.closing = false
+	q.stopPrefetch()
+
+	// Wake any goroutines waiting for an item so they
+	// can observe the closed queue.
+	for _, ch := range q.watchers {
+		close(ch)
+	}
+	q.watchers = nil
+	q.broadcast()
+
+	// Close the LevelDB database, unless it is shared.
+	if !q.shared {
+		if err := q.db.Close(); err != nil {
+			q.log(LogError, "close queue", "dataDir", q.DataDir, "error", err)
+			return err
+		}
+	}
+	q.log(LogInfo, "close queue", "dataDir", q.DataDir)
+
+	return nil
+}
+
+// Shutdown stops the queue for a clean exit of the program. It rejects
+// new items with ErrClosing, passes the remaining items to fn in order
+// from the head, removing each item once fn returns nil, and then
+// closes the queue.
+//
+// If fn returns an error, or ctx is done before the queue is empty, the
+// remaining items are left in the queue for the next time it is opened,
+// and the error is returned once the queue is closed.
+func (q *Queue) Shutdown(ctx context.Context, fn func(*Item) error) error {
+	q.Lock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		q.Unlock()
+		return ErrDBClosed
+	}
+
+	// Reject new items from now on.
+	q.closing = true
+	q.Unlock()
+
+	// Pass the remaining items to fn.
+	_, err := q.drain(ctx, math.MaxUint64, fn)
+	if closeErr := q.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Drop closes and deletes the LevelDB database of the queue. For an
+// in-memory queue, Drop is the same as Close, while for a queue created
+// using NewQueueFromDB, only the keys under its prefix are deleted. A
+// queue opened read-only cannot be dropped.
+//
+// Any error closing the queue or removing its data directory is
+// returned, in which case the directory may be left in place.
+func (q *Queue) Drop() error {
+	// Check if queue is read-only.
+	if q.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := q.Close(); err != nil {
+		return err
+	}
+
+	// In-memory queues have nothing left to delete.
+	if q.memory {
+		return nil
+	}
+
+	// A shared database only loses the keys of the queue.
+	if q.shared {
+		return q.deleteKeys()
+	}
+
+	return os.RemoveAll(q.DataDir)
+}
+
+// deleteKeys deletes all keys of the queue from the database, including
+// its metadata. The caller must hold the write lock, or have closed the
+// queue.
+func (q *Queue) deleteKeys() error {
+	// Add the removal of every key to a batch.
+	batch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.keyRange(), nil)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Remove the keys.
+	if batch.Len() > 0 {
+		if err := q.db.Write(batch, q.writeOptions); err != nil {
+			return fmt.Errorf("goque: write batch: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// dequeue removes the next item in the queue and returns it. The
+// caller must hold the write lock.
+func (q *Queue) dequeue() (*Item, error) {
+	// Try to get the next item in the queue.
+	item, err := q.nextItem()
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove this item from the queue.
+	if err := q.deleteItem(item); err != nil {
+		return nil, err
+	}
+
+	return item, q.removed(item)
+}
+
+// dequeueHead removes the item at the head of the queue and returns it,
+// holding only the lock of the head. If the item cannot be removed this
+// way, because the queue has gaps or the item is missing, has expired
+// or is not ready, false is returned and the caller must use dequeue
+// with the write lock instead. The value is decompressed once the lock
+// of the tail is released. The caller must hold the read lock.
+func (q *Queue) dequeueHead() (*Item, bool, error) {
+	q.headMu.Lock()
+	defer q.headMu.Unlock()
+
+	// In-flight items that are due are put back using the write lock.
+	now := time.Now()
+	if q.requeuePending(now) {
+		return nil, false, nil
+	}
+
+	// Get the item at the head while the tail cannot move, taking the
+	// item cached by Peek if there is one.
+	q.tailMu.RLock()
+	gaps := q.hasGaps()
+	item, err := q.takeHead(), error(nil)
+	if prefetched := q.takePrefetched(); item == nil {
+		item = prefetched
+	} else {
+		q.ReleaseItem(prefetched)
+	}
+	if item == nil {
+		item, err = q.getPackedItemByID(q.head + 1)
+	}
+	q.tailMu.RUnlock()
+	if err == ErrEmpty {
+		return nil, true, err
+	}
+	if gaps || err != nil || item.isExpired(now) || !item.isReady(now) {
+		return nil, false, nil
+	}
+
+	// Decompress the value while only the head is locked, so that
+	// Enqueue is not held up. An item that cannot be decompressed is
+	// left for dequeue to report.
+	if err := item.unpack(); err != nil {
+		return nil, false, nil
+	}
+
+	// Remove this item from the queue. The tail cannot move until the
+	// state of the queue stored along with the removal is applied.
+	q.tailMu.Lock()
+	defer q.tailMu.Unlock()
+	if err := q.deleteItem(item); err != nil {
+		return nil, true, err
+	}
+
+	// Move head position past the item. Without gaps, the head
+	// reaches the tail once the queue is empty.
+	q.head = item.ID
+	q.count--
+	q.size -= uint64(len(item.Value))
+
+	return item, true, nil
+}
+
+// rlock takes the read lock of the queue along with the locks of its
+// head and tail, so that the head, tail, item count and size are not
+// changed by Enqueue or Dequeue while the lock is held.
+func (q *Queue) rlock() {
+	q.RLock()
+	q.headMu.RLock()
+	q.tailMu.RLock()
+}
+
+// runlock releases the locks taken by rlock.
+func (q *Queue) runlock() {
+	q.tailMu.RUnlock()
+	q.headMu.RUnlock()
+	q.RUnlock()
+}
+
+// putItem stores the given item after its value was changed from the
+// given old value, moving its entry in the index of unique items. The
+// new value is given as returned by packValue. The caller must hold the
+// write lock.
+func (q *Queue) putItem(item *Item, oldValue, packed []byte) error {
+	batch := new(leveldb.Batch)
+	if item.unique {
+		batch.Delete(q.uniqueKey(oldValue))
+		batch.Put(q.uniqueKey(item.Value), nil)
+	}
+	item.data = q.encodePacked(item.header(), packed)
+	batch.Put(item.Key, item.data)
+	state := q.state()
+	state.size += uint64(len(item.Value)) - uint64(len(oldValue))
+	q.putState(batch, state)
+	if err := q.retry(func() error { return q.db.Write(batch, q.writeOptions) }); err != nil {
+		return fmt.Errorf("goque: put item %d: %w", item.ID, err)
+	}
+	return nil
+}
+
+// deleteItem deletes the given item from the database, along with its
+// entry in the index of unique items. The caller must hold the write
+// lock, or the read lock and the locks of the head and tail.
+func (q *Queue) deleteItem(item *Item) error {
+	batch := new(leveldb.Batch)
+	batch.Delete(item.Key)
+	q.unindex(batch, item)
+	archived := q.archiveStored(batch, 0, item)
+	q.putState(batch, q.state().remove(item))
+	if err := q.retry(func() error { return q.db.Write(batch, q.writeOptions) }); err != nil {
+		return fmt.Errorf("goque: delete item %d: %w", item.ID, err)
+	}
+	q.archiveTail += archived
+	return nil
+}
+
+// removed updates the head, tail, item count and size of the queue
+// after the given item has been deleted from the database. The caller
+// must hold the write lock.
+func (q *Queue) removed(item *Item) error {
+	q.count--
+	q.size -= uint64(len(item.Value))
+
+	// Move head position if the item was at the head. The tail stays
+	// where it is, so the ID of a removed tail item is not reused.
+	if item.ID == q.head+1 {
+		q.head = item.ID
+		return q.skipGaps()
+	} else if q.count == 0 {
+		q.head = q.tail
+	}
+
+	return nil
+}
+
+// fixBounds sets the head of the queue to the first item stored after
+// the given ID, for use after removing items from anywhere in the
+// queue. The tail is kept even if the item at the tail was removed, so
+// the IDs of removed items are never given to new items. The caller
+// must hold the write lock.
+func (q *Queue) fixBounds(after uint64) error {
+	q.head = after
+	return q.skipGaps()
+}
+
+// enqueued calls the enqueue hook, if any, with a copy of each of the
+// given items. The caller must not hold the lock.
+func (q *Queue) enqueued(items ...*Item) {
+	q.RLock()
+	hook := q.onEnqueue
+	logger := q.logger
+	q.RUnlock()
+
+	logItems(logger, "enqueue", items)
+	callHook(hook, items)
+}
+
+// dequeued calls the dequeue hook, if any, with a copy of each of the
+// given items. The caller must not hold the lock.
+func (q *Queue) dequeued(items ...*Item) {
+	q.RLock()
+	hook := q.onDequeue
+	logger := q.logger
+	q.RUnlock()
+
+	logItems(logger, "dequeue", items)
+	callHook(hook, items)
+}
+
+// evicted calls the evict hook, if any, with a copy of each of the
+// given items. The caller must not hold the lock.
+func (q *Queue) evicted(items ...*Item) {
+	if len(items) == 0 {
+		return
+	}
+
+	q.RLock()
+	hook := q.onEvict
+	logger := q.logger
+	q.RUnlock()
+
+	logItems(logger, "evict", items)
+	callHook(hook, items)
+}
+
+// callHook calls the given hook, if not nil, with a copy of each of the
+// given items.
+func callHook(hook func(*Item), items []*Item) {
+	if hook == nil {
+		return
+	}
+	for _, item := range items {
+		hook(item.copy())
+	}
+}
+
+// marshalObject encodes the given value using the codec of the queue.
+// The encoding itself is done without holding the lock.
+func (q *Queue) marshalObject(value interface{}) ([]byte, error) {
+	q.RLock()
+	codec := q.codec
+	q.RUnlock()
+
+	if codec == nil {
+		codec = GobObjectCodec{}
+	}
+	return codec.Marshal(value)
+}
+
+// nextItem returns the next item in the queue without removing it,
+// after first removing any expired items in front of it. The caller
+// must hold the write lock.
+func (q *Queue) nextItem() (*Item, error) {
+	// Put back any due in-flight items first.
+	now := time.Now()
+	if err := q.requeueDue(now); err != nil {
+		return nil, err
+	}
+
+	// Try to get the next item in the queue.
+	q.readyAt = time.Time{}
+	item, err := q.getItemByID(q.head + 1)
+	if q.canSkip(err) {
+		// Recount the items to skip the missing ones.
+		if err := q.countItems(); err != nil {
+			return nil, err
+		}
+		item, err = q.getItemByID(q.head + 1)
+	}
+	if err != nil || !item.isExpired(now) && item.isReady(now) {
+		return item, err
+	}
+
+	// Find the first item that has not expired and is ready. Expired
+	// items are removed up to the first item that is not ready, while
+	// the items that are not ready are kept.
+	item = nil
+	var keep, removed, size uint64
+	state := q.state()
+	batch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	for iter.Next() {
+		next := q.newItemFromIterator(iter)
+		if next.ID > q.tail {
+			break
+		}
+		if next.isExpired(now) && keep == 0 {
+			batch.Delete(next.Key)
+			q.unindex(batch, next)
+			state = state.remove(next)
+			removed++
+			size += uint64(len(next.Value))
+			continue
+		}
+		if next.isExpired(now) {
+			continue
+		}
+		if !next.isReady(now) {
+			if keep == 0 {
+				keep = next.ID
+			}
+			if q.readyAt.IsZero() || next.NotBefore.Before(q.readyAt) {
+				q.readyAt = next.NotBefore
+			}
+			continue
+		}
+		if err := checkItem(next); err != nil {
+			iter.Release()
+			return nil, err
+		}
+		item = next
+		break
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// A read-only queue keeps the expired items.
+	if q.readOnly {
+		if item == nil {
+			return nil, ErrEmpty
+		}
+		return item, nil
+	}
+
+	// Remove the expired items from the queue.
+	if removed > 0 {
+		q.putState(batch, state)
+	}
+	if err := q.db.Write(batch, q.writeOptions); err != nil {
+		return nil, fmt.Errorf("goque: write batch: %w", err)
+	}
+	q.count -= removed
+	q.size -= size
+
+	// Move head position to the first item kept.
+	if keep != 0 {
+		q.head = keep - 1
+	} else if item != nil {
+		q.head = item.ID - 1
+	} else {
+		q.head = q.tail
+	}
+
+	// Every item was expired or not ready.
+	if item == nil {
+		return nil, ErrEmpty
+	}
+
+	return item, nil
+}
+
+// broadcast wakes all goroutines blocked in DequeueCtx along with the
+// prefetch goroutine, if any, and signals the channels returned by
+// Watch. The caller must hold the write lock, or the read lock and the
+// lock of the tail.
+func (q *Queue) broadcast() {
+	if q.notify != nil {
+		close(q.notify)
+		q.notify = nil
+	}
+	q.wakePrefetch()
+
+	for _, ch := range q.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// wakeAt returns the time at which the next delayed item is ready, or
+// the next in-flight item goes back into the queue, whichever is first,
+// so that consumers waiting for an item can wake up then. It returns
+// the zero time if there is no such item. The caller must hold the
+// lock.
+func (q *Queue) wakeAt() time.Time {
+	readyAt := q.readyAt
+	if !q.nextDeadline.IsZero() && (readyAt.IsZero() || q.nextDeadline.Before(readyAt)) {
+		readyAt = q.nextDeadline
+	}
+	return readyAt
+}
+
+// unwatch stops sending signals to the given channel returned by Watch.
+func (q *Queue) unwatch(ch <-chan struct{}) {
+	q.Lock()
+	defer q.Unlock()
+
+	for i, w := range q.watchers {
+		if w == ch {
+			q.watchers = append(q.watchers[:i], q.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// length returns the total number of items in the queue. The caller
+// must hold the lock.
+func (q *Queue) length() uint64 {
+	return q.count
+}
+
+// hasGaps returns true if items were removed from within the queue,
+// so that not every ID between head and tail holds an item. The
+// caller must hold the lock.
+func (q *Queue) hasGaps() bool {
+	return q.count < q.tail-q.head
+}
+
+// skipGaps moves the head position of the queue past any removed items,
+// so that the item following the head exists. The caller must hold the
+// write lock.
+func (q *Queue) skipGaps() error {
+	// An empty queue has nothing to skip.
+	if q.count == 0 {
+		q.head = q.tail
+		return nil
+	}
+
+	// Check if there are any gaps to skip.
+	if !q.hasGaps() {
+		return nil
+	}
+
+	// Move head position to the first remaining item.
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	defer iter.Release()
+
+	if iter.First() {
+		q.head = q.keyID(iter.Key()) - 1
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	return nil
+}
+
+// seekOffset moves the given iterator over the items of the queue to
+// the item at the given offset from the head, returning false if there
+// is no such item. The caller must hold the lock.
+func (q *Queue) seekOffset(iter iterator.Iterator, offset uint64) bool {
+	// Without gaps, the item ID follows from the offset.
+	if !q.hasGaps() {
+		return iter.Seek(q.key(q.head + offset + 1))
+	}
+
+	// Otherwise step over the items in front of it.
+	ok := iter.First()
+	for i := uint64(0); ok && i < offset; i++ {
+		ok = iter.Next()
+	}
+	return ok
+}
+
+// evict checks if the queue has room for n more items. If it does not
+// and the overflow policy of the queue is DropOldest, the removal of
+// the oldest items to make room is added to the given batch and the
+// items are returned; otherwise ErrFull is returned. The caller must
+// hold the write lock, or the read lock and the lock of the tail if the
+// overflow policy is Reject, and call removeEvicted once the batch is
+// written.
+func (q *Queue) evict(batch *leveldb.Batch, n uint64) ([]*Item, error) {
+	// Check if queue is full.
+	if !q.isFull(n) {
+		return nil, nil
+	}
+	if q.overflow != DropOldest || n > q.maxLength {
+		return nil, ErrFull
+	}
+
+	// Add the removal of the oldest items to the batch.
+	n = q.length() + n - q.maxLength
+	items := make([]*Item, 0, n)
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	defer iter.Release()
+	for uint64(len(items)) < n && iter.Next() {
+		item := q.newItemFromIterator(iter)
+		items = append(items, item)
+		batch.Delete(item.Key)
+		q.unindex(batch, item)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	return items, nil
+}
+
+// removeEvicted updates the head, item count and size of the queue
+// after the given items returned by evict have been removed. The caller
+// must hold the write lock.
+func (q *Queue) removeEvicted(items []*Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	for _, item := range items {
+		q.size -= uint64(len(item.Value))
+	}
+	q.count -= uint64(len(items))
+	q.head = items[len(items)-1].ID
+	return q.skipGaps()
+}
+
+// resetIfEmpty moves the head and tail of an empty queue back to 0 if
+// the queue was opened using WithResetOnEmpty, so that the next item
+// added gets ID 1. The caller must hold the write lock.
+func (q *Queue) resetIfEmpty() {
+	if q.resetOnEmpty && q.count == 0 {
+		q.head, q.tail = 0, 0
+		q.epoch++
+		if q.priorities {
+			q.resetPriorities()
+		}
+	}
+}
+
+// checkValueSize returns ErrValueTooLarge if the given value is larger
+// than the maximum value size of the queue. It does not need the lock,
+// as the maximum is only set when the queue is opened.
+func (q *Queue) checkValueSize(value []byte) error {
+	if q.maxValueSize > 0 && len(value) > q.maxValueSize {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+// isFull returns true if adding n items would exceed the maximum
+// length of the queue. The caller must hold the lock.
+func (q *Queue) isFull(n uint64) bool {
+	return q.maxLength > 0 && n > 0 && q.length()+n > q.maxLength
+}
+
+// encodeValue returns the stored value of an item with the given
+// header and value, adding a checksum if the queue was opened using
+// WithChecksums and compressing the value if it was opened using
+// WithCompression.
+func (q *Queue) encodeValue(h itemHeader, value []byte) []byte {
+	return q.encodePacked(h, q.packValue(value))
+}
+
+// packValue returns the given value compressed if the queue was opened
+// using WithCompression, so that it can be compressed before the lock
+// is taken and encoded using encodePacked once the header is known.
+func (q *Queue) packValue(value []byte) []byte {
+	if q.compression == NoCompression {
+		return value
+	}
+	return compressValue(q.compression, value)
+}
+
+// encodePacked is encodeValue for a value returned by packValue.
+func (q *Queue) encodePacked(h itemHeader, packed []byte) []byte {
+	h.checksum = q.checksums
+	h.compressed = q.compression != NoCompression
+	return h.encode(packed)
+}
+
+// metaPrefix is the prefix of the keys holding metadata of the queue
+// rather than items, unless the queue uses key namespaces. These keys
+// sort in front of the key of the first possible item ID, so they are
+// not part of the item range.
+var metaPrefix = idToKey(0)
+
+// isMetaKey returns true if the given key holds metadata of the queue.
+func (q *Queue) isMetaKey(key []byte) bool {
+	return len(key) > len(q.keys.meta) && bytes.HasPrefix(key, q.keys.meta)
+}
+
+// key returns the key of the item with the given ID, following the
+// prefix of the queue, if any.
+func (q *Queue) key(id uint64) []byte {
+	return q.appendItemKey(make([]byte, 0, len(q.keys.item)+8), id)
+}
+
+// appendItemKey appends the prefix of the item keys of the queue and
+// the key of the item with the given ID to dst and returns the extended
+// buffer.
+func (q *Queue) appendItemKey(dst []byte, id uint64) []byte {
+	dst = append(dst, q.keys.item...)
+	if q.keyCodec == nil {
+		return appendKey(dst, id)
+	}
+	return append(dst, q.keyCodec.ToKey(id)...)
+}
+
+// keyID returns the ID of the item stored under the given key.
+func (q *Queue) keyID(key []byte) uint64 {
+	if q.keyCodec == nil {
+		return keyToID(key)
+	}
+	return q.keyCodec.FromKey(key[len(q.keys.item):])
+}
+
+// isItemKey returns true if the given key is a valid item key.
+func (q *Queue) isItemKey(key []byte) bool {
+	if !bytes.HasPrefix(key, q.keys.item) {
+		return false
+	}
+	if q.keyCodec == nil {
+		return len(key) == len(q.keys.item)+8 && keyToID(key) != 0
+	}
+	id := q.keyID(key)
+	return id != 0 && bytes.Equal(key[len(q.keys.item):], q.keyCodec.ToKey(id))
+}
+
+// keyRange returns the range of all keys of the queue, including its
+// metadata, or nil for the whole database if the queue has no prefix.
+func (q *Queue) keyRange() *util.Range {
+	if len(q.prefix) == 0 {
+		return nil
+	}
+	return util.BytesPrefix(q.prefix)
+}
+
+// rangeFrom returns the range of the item keys of the queue starting
+// at the given ID.
+func (q *Queue) rangeFrom(id uint64) *util.Range {
+	r := &util.Range{Start: q.key(id)}
+	if len(q.keys.item) > 0 {
+		r.Limit = util.BytesPrefix(q.keys.item).Limit
+	}
+	return r
+}
+
+// itemRange returns the key range starting at the head of the queue.
+// The caller must hold the lock.
+func (q *Queue) itemRange() *util.Range {
+	return q.rangeFrom(q.head + 1)
+}
+
+// newItemFromIterator creates an item from the current position of the
+// given iterator over the items of the queue.
+func (q *Queue) newItemFromIterator(iter iterator.Iterator) *Item {
+	if q.pool != nil {
+		return q.newPooledItem(q.keyID(iter.Key()), iter.Key(), iter.Value())
+	}
+
+	key := append([]byte(nil), iter.Key()...)
+	item := newItem(q.keyID(key), key, append([]byte(nil), iter.Value()...))
+	item.codec = q.codec
+	return item
+}
+
+// newPooledItem creates an item from the item pool of the queue for the
+// given ID and key from its stored value.
+func (q *Queue) newPooledItem(id uint64, key, data []byte) *Item {
+	return q.fillPooledItem(q.pool.Get().(*Item), id, key, data)
+}
+
+// fillPooledItem sets the given pooled item to the given ID and key and
+// its stored value. The key and value are copied into the buffers of
+// the item, where the key may already be in its buffer.
+func (q *Queue) fillPooledItem(item *Item, id uint64, key, data []byte) *Item {
+	h, value := decodeValue(data)
+	*item = Item{
+		ID:         id,
+		Key:        append(item.Key[:0], key...),
+		Value:      append(item.Value[:0], value...),
+		ExpiresAt:  h.expiresAt,
+		EnqueuedAt: h.enqueuedAt,
+		NotBefore:  h.notBefore,
+		Receives:   h.receives,
+		unique:     h.unique,
+		err:        h.err,
+		pooled:     true,
+		codec:      q.codec,
+	}
+	return item
+}
+
+// canSkip returns true if the given error is for an item missing from
+// the database that the queue is set to skip.
+func (q *Queue) canSkip(err error) bool {
+	return q.skipMissing && errors.Is(err, ErrCorrupt)
+}
+
+// getItemByID returns an item, if found, for the given ID.
+func (q *Queue) getItemByID(id uint64) (*Item, error) {
+	return q.getItem(id, newItem)
+}
+
+// getPackedItemByID is getItemByID for an item that is decompressed
+// using unpack once the lock is released. Items of a queue with an item
+// pool are decompressed right away, as their value is copied into the
+// buffer of the item anyway.
+func (q *Queue) getPackedItemByID(id uint64) (*Item, error) {
+	return q.getItem(id, newPackedItem)
+}
+
+// getItem is getItemByID creating the item using the given function.
+func (q *Queue) getItem(id uint64, create func(id uint64, key, data []byte) *Item) (*Item, error) {
+	// Check if empty or out of bounds.
+	if q.length() == 0 {
+		return nil, ErrEmpty
+	} else if id <= q.head || id > q.tail {
+		return nil, ErrOutOfBounds
+	}
+
+	// Pooled items reuse their key buffer for the lookup.
+	if q.pool != nil {
+		return q.getPooledItemByID(id)
+	}
+
+	// Get item from database. A missing item within a queue that
+	// has gaps was removed by DequeueByID.
+	key := q.key(id)
+	var value []byte
+	err := q.retry(func() (err error) {
+		value, err = q.db.Get(key, nil)
+		return err
+	})
+	if err == leveldb.ErrNotFound && q.hasGaps() {
+		return nil, ErrOutOfBounds
+	} else if err == leveldb.ErrNotFound {
+		return nil, fmt.Errorf("goque: get item %d: %w", id, ErrCorrupt)
+	} else if err != nil {
+		return nil, fmt.Errorf("goque: get item %d: %w", id, err)
+	}
+
+	item := create(id, key, value)
+	item.codec = q.codec
+	if err := checkItem(item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// getPooledItemByID is getItemByID for a queue with an item pool. The
+// caller must hold the lock and have checked the bounds of the ID.
+func (q *Queue) getPooledItemByID(id uint64) (*Item, error) {
+	item := q.pool.Get().(*Item)
+	item.Key = q.appendItemKey(item.Key[:0], id)
+
+	// Get item from database, as in getItemByID.
+	var value []byte
+	err := q.retry(func() (err error) {
+		value, err = q.db.Get(item.Key, nil)
+		return err
+	})
+	if err != nil {
+		q.pool.Put(item)
+	}
+	if err == leveldb.ErrNotFound && q.hasGaps() {
+		return nil, ErrOutOfBounds
+	} else if err == leveldb.ErrNotFound {
+		return nil, fmt.Errorf("goque: get item %d: %w", id, ErrCorrupt)
+	} else if err != nil {
+		return nil, fmt.Errorf("goque: get item %d: %w", id, err)
+	}
+
+	item = q.fillPooledItem(item, id, item.Key, value)
+	if err := checkItem(item); err != nil {
+		q.pool.Put(item)
+		return nil, err
+	}
+	return item, nil
+}
+
+// removeInvalidKeys removes all keys from the database that are not
+// valid item keys, returning the number of keys removed.
+func (q *Queue) removeInvalidKeys() (int, error) {
+	batch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.keyRange(), nil)
+	for iter.Next() {
+		if q.isMetaKey(iter.Key()) {
+			continue
+		}
+		if !q.isItemKey(iter.Key()) {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	if err := q.db.Write(batch, q.writeOptions); err != nil {
+		return 0, fmt.Errorf("goque: write batch: %w", err)
+	}
+
+	return batch.Len(), nil
+}
+
+// init initializes the queue data from the stored state of the queue,
+// if it uses WithStoredState. Other queues, queues without a stored
+// state, such as those written by older versions of Goque, and queues
+// being recovered have their items counted instead, after which the
+// state is stored if the queue uses WithStoredState, or else deleted.
+func (q *Queue) init() error {
+	if err := q.checkDeadLetter(); err != nil {
+		return err
+	}
+	q.epoch++
+	if err := q.initKeys(); err != nil {
+		return err
+	}
+
+	// Remove invalid keys before reading the items.
+	if q.repair {
+		var err error
+		if q.repaired, err = q.removeInvalidKeys(); err != nil {
+			return err
+		}
+	}
+
+	ok := false
+	if q.storedState && !q.recovered {
+		var err error
+		if ok, err = q.loadState(); err != nil {
+			return err
+		}
+	}
+	if !ok {
+		if err := q.countItems(); err != nil {
+			return err
+		}
+		if !q.readOnly {
+			if err := q.writeState(); err != nil {
+				return err
+			}
+		}
+	}
+	if !q.storedState && !q.readOnly {
+		if err := q.deleteState(); err != nil {
+			return err
+		}
+	}
+
+	if err := q.initPriorities(); err != nil {
+		return err
+	}
+	if err := q.initArchive(); err != nil {
+		return err
+	}
+	return q.initInFlight()
 }
@@ -1,10 +1,18 @@
 package goque
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 func TestQueueClose(t *testing.T) {
@@ -23,50 +31,182 @@ func TestQueueClose(t *testing.T) {
 		t.Errorf("Expected queue length of 1, got %d", q.Length())
 	}
 
+	if !q.IsOpen() {
+		t.Error("Expected queue to be open")
+	}
+
 	q.Close()
 
+	if q.IsOpen() {
+		t.Error("Expected queue to be closed")
+	}
+
 	if _, err = q.Dequeue(); err != ErrDBClosed {
 		t.Errorf("Expected to get database closed error, got %s", err.Error())
 	}
 
+	if _, err = q.EnqueueString("value"); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+
+	if _, err = q.Peek(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+
+	if err = q.Clear(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+
 	if q.Length() != 0 {
 		t.Errorf("Expected queue length of 0, got %d", q.Length())
 	}
 }
 
-func TestQueueDrop(t *testing.T) {
+func TestQueueOpen(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
 		t.Error(err)
 	}
+	defer q.Drop()
 
-	if _, err = os.Stat(file); os.IsNotExist(err) {
+	// Opening an open queue does nothing.
+	if err = q.Open(); err != nil {
 		t.Error(err)
 	}
 
-	q.Drop()
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
 
-	if _, err = os.Stat(file); err == nil {
-		t.Error("Expected directory for test database to have been deleted")
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 9 {
+		t.Errorf("Expected queue length of 9, got %d", q.Length())
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 2"
+
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
 	}
 }
 
-func TestQueueIncompatibleType(t *testing.T) {
+func TestQueueReopenOrder(t *testing.T) {
+	// The second case has IDs crossing the byte boundary at 256.
+	for _, bc := range []struct {
+		total, removed int
+	}{
+		{100, 50},
+		{300, 250},
+	} {
+		// Reopen with the stored state, and with the items counted.
+		for _, count := range []bool{false, true} {
+			file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+			q, err := OpenQueue(file)
+			if err != nil {
+				t.Error(err)
+			}
+
+			for i := 1; i <= bc.total; i++ {
+				if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+					t.Error(err)
+				}
+			}
+			for i := 1; i <= bc.removed; i++ {
+				if _, err = q.Dequeue(); err != nil {
+					t.Error(err)
+				}
+			}
+
+			if count {
+				if err = q.db.Delete(q.stateKey(), nil); err != nil {
+					t.Error(err)
+				}
+			}
+			if err = q.Close(); err != nil {
+				t.Error(err)
+			}
+			if err = q.Open(); err != nil {
+				t.Error(err)
+			}
+
+			if q.Length() != uint64(bc.total-bc.removed) {
+				t.Errorf("Expected queue length of %d, got %d", bc.total-bc.removed, q.Length())
+			}
+
+			for i := bc.removed + 1; i <= bc.total; i++ {
+				item, err := q.Dequeue()
+				if err != nil {
+					t.Error(err)
+					break
+				}
+
+				compStr := fmt.Sprintf("value for item %d", i)
+
+				if item.ID != uint64(i) || item.ToString() != compStr {
+					t.Errorf("Expected item %d with string '%s', got item %d with '%s'", i, compStr, item.ID, item.ToString())
+				}
+			}
+
+			if _, err = q.Dequeue(); err != ErrEmpty {
+				t.Errorf("Expected to get empty error, got %v", err)
+			}
+
+			q.Drop()
+		}
+	}
+}
+func TestQueueCloseError(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
-	pq, err := OpenPriorityQueue(file, ASC)
+	q, err := OpenQueue(file)
 	if err != nil {
 		t.Error(err)
 	}
-	defer pq.Drop()
-	pq.Close()
+	defer os.RemoveAll(file)
 
-	if _, err = OpenQueue(file); err != ErrIncompatibleType {
-		t.Error("Expected priority queue to return ErrIncompatibleTypes when opening goquePriorityQueue")
+	// Close the database behind the back of the queue, so that
+	// closing it again fails.
+	if err = q.db.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Close(); err != leveldb.ErrClosed {
+		t.Errorf("Expected to get LevelDB closed error, got %v", err)
+	}
+
+	if q.IsOpen() {
+		t.Error("Expected queue to be closed")
+	}
+
+	// The queue was closed despite the error.
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Drop(); err != nil {
+		t.Error(err)
 	}
 }
 
-func TestQueueEnqueue(t *testing.T) {
+func TestQueueCloseConcurrent(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
@@ -74,23 +214,102 @@ func TestQueueEnqueue(t *testing.T) {
 	}
 	defer q.Drop()
 
-	for i := 1; i <= 10; i++ {
-		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+	// Operations racing with Close either succeed or see the
+	// closed queue.
+	check := func(err error) {
+		if err != nil && err != ErrEmpty && err != ErrDBClosed {
 			t.Error(err)
 		}
 	}
 
-	if q.Length() != 10 {
-		t.Errorf("Expected queue size of 10, got %d", q.Length())
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 50; j++ {
+				_, err := q.EnqueueString("value")
+				check(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 50; j++ {
+				_, err := q.Dequeue()
+				check(err)
+				_, err = q.Peek()
+				check(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			<-start
+			_, err := q.DequeueCtx(ctx)
+			check(err)
+		}()
+		go func() {
+			defer wg.Done()
+			<-start
+			time.Sleep(time.Millisecond)
+			if err := q.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if q.IsOpen() {
+		t.Error("Expected queue to be closed")
 	}
 }
 
-func TestQueueDequeue(t *testing.T) {
+func TestQueueDrop(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
 		t.Error(err)
 	}
+
+	if _, err = os.Stat(file); os.IsNotExist(err) {
+		t.Error(err)
+	}
+
+	q.Drop()
+
+	if _, err = os.Stat(file); err == nil {
+		t.Error("Expected directory for test database to have been deleted")
+	}
+}
+
+func TestQueueDropError(t *testing.T) {
+	// A path ending in a dot cannot be removed by os.RemoveAll.
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file + "/.")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(file)
+
+	if err = q.Drop(); err == nil {
+		t.Error("Expected to get an error removing the directory")
+	}
+
+	if q.IsOpen() {
+		t.Error("Expected queue to be closed")
+	}
+}
+
+func TestQueueMem(t *testing.T) {
+	q, err := OpenMemQueue()
+	if err != nil {
+		t.Error(err)
+	}
 	defer q.Drop()
 
 	for i := 1; i <= 10; i++ {
@@ -108,171 +327,212 @@ func TestQueueDequeue(t *testing.T) {
 		t.Error(err)
 	}
 
-	if q.Length() != 9 {
-		t.Errorf("Expected queue length of 9, got %d", q.Length())
-	}
-
 	compStr := "value for item 1"
 
 	if deqItem.ToString() != compStr {
 		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
 	}
+
+	if err = q.Drop(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.Dequeue(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
 }
 
-func TestQueuePeek(t *testing.T) {
+func TestQueueWithOptions(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
-	q, err := OpenQueue(file)
+	q, err := OpenQueueWithOptions(file, &opt.Options{WriteBuffer: 64 * opt.MiB})
 	if err != nil {
 		t.Error(err)
 	}
 	defer q.Drop()
 
-	compStr := "value for item"
+	if _, err = q.EnqueueString("value for item"); err != nil {
+		t.Error(err)
+	}
 
-	if _, err = q.EnqueueString(compStr); err != nil {
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+
+	// A read-only database should refuse writes.
+	if err = q.Close(); err != nil {
 		t.Error(err)
 	}
 
-	peekItem, err := q.Peek()
-	if err != nil {
+	if q, err = OpenQueueWithOptions(file, &opt.Options{ReadOnly: true}); err != nil {
 		t.Error(err)
 	}
 
-	if peekItem.ToString() != compStr {
-		t.Errorf("Expected string to be '%s', got '%s'", compStr, peekItem.ToString())
+	if _, err = q.EnqueueString("value for item"); err == nil {
+		t.Error("Expected enqueue on a read-only database to fail")
 	}
 
 	if q.Length() != 1 {
 		t.Errorf("Expected queue length of 1, got %d", q.Length())
 	}
+
+	q.Close()
 }
 
-func TestQueuePeekByOffset(t *testing.T) {
+func TestQueueSyncWrites(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
-	q, err := OpenQueue(file)
+	q, err := OpenQueue(file, WithSyncWrites())
 	if err != nil {
 		t.Error(err)
 	}
 	defer q.Drop()
 
+	if q.writeOptions == nil || !q.writeOptions.Sync {
+		t.Error("Expected queue to use synchronous writes")
+	}
+
 	for i := 1; i <= 10; i++ {
 		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
 			t.Error(err)
 		}
 	}
 
-	compStrFirst := "value for item 1"
-	compStrLast := "value for item 10"
-	compStr := "value for item 4"
-
-	peekFirstItem, err := q.PeekByOffset(0)
-	if err != nil {
+	if _, err = q.Dequeue(); err != nil {
 		t.Error(err)
 	}
 
-	if peekFirstItem.ToString() != compStrFirst {
-		t.Errorf("Expected string to be '%s', got '%s'", compStrFirst, peekFirstItem.ToString())
+	if q.Length() != 9 {
+		t.Errorf("Expected queue length of 9, got %d", q.Length())
 	}
+}
 
-	peekLastItem, err := q.PeekByOffset(9)
+func TestQueueIncompatibleType(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	pq, err := OpenPriorityQueue(file, ASC)
 	if err != nil {
 		t.Error(err)
 	}
+	defer pq.Drop()
+	pq.Close()
 
-	if peekLastItem.ToString() != compStrLast {
-		t.Errorf("Expected string to be '%s', got '%s'", compStrLast, peekLastItem.ToString())
+	if _, err = OpenQueue(file); err != ErrIncompatibleType {
+		t.Error("Expected priority queue to return ErrIncompatibleTypes when opening goquePriorityQueue")
 	}
+}
 
-	peekItem, err := q.PeekByOffset(3)
+func TestQueueEnqueue(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
 	if err != nil {
 		t.Error(err)
 	}
+	defer q.Drop()
 
-	if peekItem.ToString() != compStr {
-		t.Errorf("Expected string to be '%s', got '%s'", compStr, peekItem.ToString())
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
 	}
 
 	if q.Length() != 10 {
-		t.Errorf("Expected queue length of 10, got %d", q.Length())
+		t.Errorf("Expected queue size of 10, got %d", q.Length())
 	}
 }
 
-func TestQueuePeekByID(t *testing.T) {
+func TestQueueMaxLength(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
-	q, err := OpenQueue(file)
+	q, err := OpenQueue(file, WithMaxLength(3))
 	if err != nil {
 		t.Error(err)
 	}
 	defer q.Drop()
 
-	for i := 1; i <= 10; i++ {
+	for i := 1; i <= 3; i++ {
 		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
 			t.Error(err)
 		}
 	}
 
-	compStr := "value for item 3"
+	if _, err = q.EnqueueString("value for item 4"); err != ErrFull {
+		t.Errorf("Expected to get full error, got %v", err)
+	}
 
-	peekItem, err := q.PeekByID(3)
-	if err != nil {
+	if q.Length() != 3 {
+		t.Errorf("Expected queue length of 3, got %d", q.Length())
+	}
+
+	if _, err = q.Dequeue(); err != nil {
 		t.Error(err)
 	}
 
-	if peekItem.ToString() != compStr {
-		t.Errorf("Expected string to be '%s', got '%s'", compStr, peekItem.ToString())
+	if _, err = q.EnqueueBatch([][]byte{[]byte("a"), []byte("b")}); err != ErrFull {
+		t.Errorf("Expected to get full error, got %v", err)
 	}
 
-	if q.Length() != 10 {
-		t.Errorf("Expected queue length of 10, got %d", q.Length())
+	if _, err = q.EnqueueString("value for item 4"); err != nil {
+		t.Error(err)
 	}
 }
 
-func TestQueueUpdate(t *testing.T) {
+func TestQueueMaxLengthConcurrent(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
-	q, err := OpenQueue(file)
+	q, err := OpenQueue(file, WithMaxLength(5))
 	if err != nil {
 		t.Error(err)
 	}
 	defer q.Drop()
 
-	for i := 1; i <= 10; i++ {
-		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
-			t.Error(err)
-		}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := q.EnqueueString("value"); err != nil && err != ErrFull {
+				t.Error(err)
+			}
+		}()
 	}
+	wg.Wait()
 
-	item, err := q.PeekByID(3)
+	if q.Length() != 5 {
+		t.Errorf("Expected queue length of 5, got %d", q.Length())
+	}
+}
+
+func TestQueueMaxValueSize(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithMaxValueSize(10))
 	if err != nil {
 		t.Error(err)
 	}
+	defer q.Drop()
 
-	oldCompStr := "value for item 3"
-	newCompStr := "new value for item 3"
-
-	if item.ToString() != oldCompStr {
-		t.Errorf("Expected string to be '%s', got '%s'", oldCompStr, item.ToString())
+	// Values just under and at the limit are added.
+	for _, n := range []int{9, 10} {
+		if _, err = q.Enqueue(make([]byte, n)); err != nil {
+			t.Errorf("Expected to add a value of %d bytes, got %v", n, err)
+		}
 	}
 
-	updatedItem, err := q.Update(item.ID, []byte(newCompStr))
-	if err != nil {
-		t.Error(err)
+	// A value just over the limit is rejected.
+	if _, err = q.Enqueue(make([]byte, 11)); err != ErrValueTooLarge {
+		t.Errorf("Expected to get value too large error, got %v", err)
 	}
 
-	if updatedItem.ToString() != newCompStr {
-		t.Errorf("Expected current item value to be '%s', got '%s'", newCompStr, item.ToString())
+	// A batch with a single value over the limit is rejected.
+	if _, err = q.EnqueueBatch([][]byte{make([]byte, 1), make([]byte, 11)}); err != ErrValueTooLarge {
+		t.Errorf("Expected to get value too large error, got %v", err)
 	}
 
-	newItem, err := q.PeekByID(3)
-	if err != nil {
-		t.Error(err)
+	if _, err = q.Update(1, make([]byte, 11)); err != ErrValueTooLarge {
+		t.Errorf("Expected to get value too large error, got %v", err)
 	}
 
-	if newItem.ToString() != newCompStr {
-		t.Errorf("Expected new item value to be '%s', got '%s'", newCompStr, item.ToString())
+	if q.Length() != 2 {
+		t.Errorf("Expected queue length of 2, got %d", q.Length())
 	}
 }
 
-func TestQueueUpdateString(t *testing.T) {
+func TestQueueEnqueueWithLength(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
@@ -280,44 +540,48 @@ func TestQueueUpdateString(t *testing.T) {
 	}
 	defer q.Drop()
 
-	for i := 1; i <= 10; i++ {
-		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
-			t.Error(err)
-		}
-	}
-
-	item, err := q.PeekByID(3)
-	if err != nil {
-		t.Error(err)
+	// Each concurrent enqueue sees a different length.
+	var wg sync.WaitGroup
+	lengths := make(chan uint64, 100)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				_, length, err := q.EnqueueWithLength([]byte("value"))
+				if err != nil {
+					t.Error(err)
+				}
+				lengths <- length
+			}
+		}()
 	}
+	wg.Wait()
+	close(lengths)
 
-	oldCompStr := "value for item 3"
-	newCompStr := "new value for item 3"
-
-	if item.ToString() != oldCompStr {
-		t.Errorf("Expected string to be '%s', got '%s'", oldCompStr, item.ToString())
+	seen := make(map[uint64]bool)
+	for length := range lengths {
+		if length < 1 || length > 100 || seen[length] {
+			t.Errorf("Expected unique length between 1 and 100, got %d", length)
+		}
+		seen[length] = true
 	}
 
-	updatedItem, err := q.UpdateString(item.ID, newCompStr)
-	if err != nil {
+	if _, err = q.Dequeue(); err != nil {
 		t.Error(err)
 	}
 
-	if updatedItem.ToString() != newCompStr {
-		t.Errorf("Expected current item value to be '%s', got '%s'", newCompStr, item.ToString())
-	}
-
-	newItem, err := q.PeekByID(3)
+	item, length, err := q.EnqueueWithLength([]byte("value"))
 	if err != nil {
 		t.Error(err)
 	}
 
-	if newItem.ToString() != newCompStr {
-		t.Errorf("Expected new item value to be '%s', got '%s'", newCompStr, item.ToString())
+	if item.ID != 101 || length != 100 {
+		t.Errorf("Expected item ID 101 and length of 100, got %d and %d", item.ID, length)
 	}
 }
 
-func TestQueueUpdateObject(t *testing.T) {
+func TestQueueEnqueueBatch(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
@@ -325,61 +589,90 @@ func TestQueueUpdateObject(t *testing.T) {
 	}
 	defer q.Drop()
 
-	type object struct {
-		Value int
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
 	}
 
-	for i := 1; i <= 10; i++ {
-		if _, err = q.EnqueueObject(object{i}); err != nil {
-			t.Error(err)
-		}
+	var values [][]byte
+	for i := 2; i <= 10; i++ {
+		values = append(values, []byte(fmt.Sprintf("value for item %d", i)))
 	}
 
-	item, err := q.PeekByID(3)
+	items, err := q.EnqueueBatch(values)
 	if err != nil {
 		t.Error(err)
 	}
 
-	oldCompObj := object{3}
-	newCompObj := object{33}
-
-	var obj object
-	if err := item.ToObject(&obj); err != nil {
-		t.Error(err)
+	if len(items) != 9 {
+		t.Errorf("Expected 9 items to be returned, got %d", len(items))
 	}
 
-	if obj != oldCompObj {
-		t.Errorf("Expected object to be '%+v', got '%+v'", oldCompObj, obj)
+	for i, item := range items {
+		if item.ID != uint64(i+2) {
+			t.Errorf("Expected item ID to be %d, got %d", i+2, item.ID)
+		}
+		if keyToID(item.Key) != item.ID {
+			t.Errorf("Expected item key to match ID %d, got %v", item.ID, item.Key)
+		}
 	}
 
-	updatedItem, err := q.UpdateObject(item.ID, newCompObj)
+	if q.Length() != 10 {
+		t.Errorf("Expected queue length of 10, got %d", q.Length())
+	}
+
+	for i := 1; i <= 10; i++ {
+		deqItem, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+
+	if items, err = q.EnqueueBatch(nil); err != nil || len(items) != 0 {
+		t.Errorf("Expected empty batch to enqueue nothing, got %d items and error %v", len(items), err)
+	}
+}
+
+func TestQueueDequeue(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
 	if err != nil {
 		t.Error(err)
 	}
+	defer q.Drop()
 
-	if err := updatedItem.ToObject(&obj); err != nil {
-		t.Error(err)
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
 	}
 
-	if obj != newCompObj {
-		t.Errorf("Expected current object to be '%+v', got '%+v'", newCompObj, obj)
+	if q.Length() != 10 {
+		t.Errorf("Expected queue length of 10, got %d", q.Length())
 	}
 
-	newItem, err := q.PeekByID(3)
+	deqItem, err := q.Dequeue()
 	if err != nil {
 		t.Error(err)
 	}
 
-	if err := newItem.ToObject(&obj); err != nil {
-		t.Error(err)
+	if q.Length() != 9 {
+		t.Errorf("Expected queue length of 9, got %d", q.Length())
 	}
 
-	if obj != newCompObj {
-		t.Errorf("Expected new object to be '%+v', got '%+v'", newCompObj, obj)
+	compStr := "value for item 1"
+
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
 	}
 }
 
-func TestQueueUpdateOutOfBounds(t *testing.T) {
+func TestQueueDequeueByID(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
@@ -393,29 +686,82 @@ func TestQueueUpdateOutOfBounds(t *testing.T) {
 		}
 	}
 
-	if q.Length() != 10 {
-		t.Errorf("Expected queue length of 10, got %d", q.Length())
+	// Remove the head, tail and a middle item.
+	for _, id := range []uint64{1, 10, 5} {
+		deqItem, err := q.DequeueByID(id)
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", id)
+
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
 	}
 
-	deqItem, err := q.Dequeue()
+	if q.Length() != 7 {
+		t.Errorf("Expected queue length of 7, got %d", q.Length())
+	}
+
+	if _, err = q.DequeueByID(5); err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %v", err)
+	}
+
+	if _, err = q.PeekByID(5); err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %v", err)
+	}
+
+	// Offsets should count the remaining items only.
+	peekItem, err := q.PeekByOffset(3)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if q.Length() != 9 {
-		t.Errorf("Expected queue length of 9, got %d", q.Length())
+	if peekItem.ID != 6 {
+		t.Errorf("Expected item at offset 3 to have ID 6, got %d", peekItem.ID)
 	}
 
-	if _, err = q.Update(deqItem.ID, []byte(`new value`)); err != ErrOutOfBounds {
-		t.Errorf("Expected to get queue out of bounds error, got %s", err.Error())
+	// The ID of the removed tail item is never given to a new item.
+	item, err := q.EnqueueString("value for item 11")
+	if err != nil {
+		t.Error(err)
 	}
 
-	if _, err = q.Update(deqItem.ID+1, []byte(`new value`)); err != nil {
+	if item.ID != 11 {
+		t.Errorf("Expected item ID to be 11, got %d", item.ID)
+	}
+
+	// The count should survive reopening the queue.
+	if err = q.Close(); err != nil {
 		t.Error(err)
 	}
+
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 8 {
+		t.Errorf("Expected queue length of 8, got %d", q.Length())
+	}
+
+	for _, id := range []uint64{2, 3, 4, 6, 7, 8, 9, 11} {
+		deqItem, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if deqItem.ID != id {
+			t.Errorf("Expected item ID to be %d, got %d", id, deqItem.ID)
+		}
+	}
+
+	if _, err = q.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
 }
 
-func TestQueueEmpty(t *testing.T) {
+func TestQueueRequeueHead(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
@@ -423,23 +769,104 @@ func TestQueueEmpty(t *testing.T) {
 	}
 	defer q.Drop()
 
-	_, err = q.EnqueueString("value for item")
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, err := q.RequeueHead()
 	if err != nil {
 		t.Error(err)
 	}
+	if item.ID != 4 {
+		t.Errorf("Expected item ID to be 4, got %d", item.ID)
+	}
 
-	_, err = q.Dequeue()
+	compStr := "value for item 1"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if q.Length() != 3 {
+		t.Errorf("Expected queue length of 3, got %d", q.Length())
+	}
+
+	// The first item now comes last.
+	for _, i := range []int{2, 3, 1} {
+		deqItem, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+
+	if _, err = q.RequeueHead(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+}
+
+func TestQueueLengthWithGaps(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
 	if err != nil {
 		t.Error(err)
 	}
+	defer q.Drop()
 
-	_, err = q.Dequeue()
-	if err != ErrEmpty {
-		t.Errorf("Expected to get empty error, got %s", err.Error())
+	for i := 1; i <= 20; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, id := range []uint64{3, 4, 9, 15} {
+		if _, err = q.DequeueByID(id); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	// Count the item keys actually stored in the database.
+	stored := func() uint64 {
+		var n uint64
+		iter := q.db.NewIterator(q.rangeFrom(1), nil)
+		defer iter.Release()
+		for iter.Next() {
+			n++
+		}
+		return n
+	}
+
+	if q.Length() != stored() {
+		t.Errorf("Expected queue length of %d, got %d", stored(), q.Length())
+	}
+
+	if q.Length() != 15 {
+		t.Errorf("Expected queue length of 15, got %d", q.Length())
+	}
+
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != stored() {
+		t.Errorf("Expected queue length of %d after reopening, got %d", stored(), q.Length())
 	}
 }
 
-func TestQueueOutOfBounds(t *testing.T) {
+func TestQueueDequeueBatch(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
@@ -447,18 +874,58 @@ func TestQueueOutOfBounds(t *testing.T) {
 	}
 	defer q.Drop()
 
-	_, err = q.EnqueueString("value for item")
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	items, err := q.DequeueBatch(4)
 	if err != nil {
 		t.Error(err)
 	}
 
-	_, err = q.PeekByOffset(2)
-	if err != ErrOutOfBounds {
-		t.Errorf("Expected to get queue out of bounds error, got %s", err.Error())
+	if len(items) != 4 {
+		t.Errorf("Expected 4 items to be dequeued, got %d", len(items))
+	}
+
+	for i, item := range items {
+		compStr := fmt.Sprintf("value for item %d", i+1)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	if q.Length() != 6 {
+		t.Errorf("Expected queue length of 6, got %d", q.Length())
+	}
+
+	// Asking for more than available returns the rest.
+	if items, err = q.DequeueBatch(100); err != nil {
+		t.Error(err)
+	}
+
+	if len(items) != 6 {
+		t.Errorf("Expected 6 items to be dequeued, got %d", len(items))
+	}
+
+	compStr := "value for item 5"
+
+	if items[0].ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, items[0].ToString())
+	}
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
+
+	if _, err = q.DequeueBatch(1); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
 	}
 }
 
-func TestQueueRecover(t *testing.T) {
+func TestQueueDequeueCtx(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
@@ -466,64 +933,3725 @@ func TestQueueRecover(t *testing.T) {
 	}
 	defer q.Drop()
 
-	_, err = q.EnqueueString("value for item")
-	if err != nil {
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
 		t.Error(err)
 	}
 
-	if err = q.Close(); err != nil {
+	// An available item should be returned immediately.
+	deqItem, err := q.DequeueCtx(context.Background())
+	if err != nil {
 		t.Error(err)
 	}
-	if err = os.Remove(file + "/MANIFEST-000000"); err != nil {
+
+	compStr := "value for item 1"
+
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+	}
+
+	// A blocked call should return once an item is enqueued.
+	done := make(chan *Item)
+	go func() {
+		item, err := q.DequeueCtx(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		done <- item
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err = q.EnqueueString("value for item 2"); err != nil {
 		t.Error(err)
 	}
 
-	if q, err = OpenQueue(file); !IsCorrupted(err) {
-		t.Errorf("Expected corruption error, got %s", err)
+	compStr = "value for item 2"
+
+	if deqItem = <-done; deqItem == nil || deqItem.ToString() != compStr {
+		t.Errorf("Expected blocked dequeue to receive '%s', got %+v", compStr, deqItem)
 	}
-	if q, err = RecoverQueue(file); err != nil {
+}
+
+func TestQueueDequeueCtxCancel(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
 		t.Error(err)
 	}
+	defer q.Drop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err = q.DequeueCtx(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected to get deadline exceeded error, got %v", err)
+	}
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
 }
 
-func BenchmarkQueueEnqueue(b *testing.B) {
-	// Open test database
+func TestQueueDequeueCtxMultipleWaiters(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
-		b.Error(err)
+		t.Error(err)
 	}
 	defer q.Drop()
 
-	b.ResetTimer()
-	b.ReportAllocs()
+	const waiters = 5
 
-	for n := 0; n < b.N; n++ {
-		_, _ = q.Enqueue([]byte("value"))
+	var wg sync.WaitGroup
+	items := make(chan *Item, waiters)
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item, err := q.DequeueCtx(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			items <- item
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	for i := 1; i <= waiters; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	wg.Wait()
+	close(items)
+
+	seen := make(map[uint64]bool)
+	for item := range items {
+		if seen[item.ID] {
+			t.Errorf("Expected item %d to be received only once", item.ID)
+		}
+		seen[item.ID] = true
+	}
+
+	if len(seen) != waiters {
+		t.Errorf("Expected %d items to be received, got %d", waiters, len(seen))
 	}
 }
 
-func BenchmarkQueueDequeue(b *testing.B) {
-	// Open test database
+func TestQueueEnqueueWithTTL(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	q, err := OpenQueue(file)
 	if err != nil {
-		b.Error(err)
+		t.Error(err)
 	}
 	defer q.Drop()
 
-	// Fill with dummy data
-	for n := 0; n < b.N; n++ {
-		if _, err = q.Enqueue([]byte("value")); err != nil {
-			b.Error(err)
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueWithTTL([]byte(fmt.Sprintf("value for item %d", i)), time.Millisecond); err != nil {
+			t.Error(err)
 		}
 	}
 
-	// Start benchmark
-	b.ResetTimer()
-	b.ReportAllocs()
+	item, err := q.EnqueueWithTTL([]byte("value for item 4"), time.Hour)
+	if err != nil {
+		t.Error(err)
+	}
 
-	for n := 0; n < b.N; n++ {
-		_, _ = q.Dequeue()
+	if item.ExpiresAt.IsZero() {
+		t.Error("Expected item to have an expiry time")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	// Peek should skip the expired items.
+	peekItem, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 4"
+
+	if peekItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, peekItem.ToString())
+	}
+
+	if !peekItem.ExpiresAt.Equal(item.ExpiresAt) {
+		t.Errorf("Expected expiry time to be %s, got %s", item.ExpiresAt, peekItem.ExpiresAt)
+	}
+
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+	}
+}
+
+func TestQueueEnqueueWithTTLAllExpired(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueWithTTL([]byte(fmt.Sprintf("value for item %d", i)), time.Millisecond); err != nil {
+			t.Error(err)
+		}
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err = q.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
+
+	// Items without a TTL should still be stored in the legacy format.
+	item, err := q.EnqueueString("value for item 4")
+	if err != nil {
+		t.Error(err)
+	}
+
+	value, err := q.db.Get(item.Key, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(value) != "value for item 4" {
+		t.Errorf("Expected stored value to be unchanged, got %v", value)
+	}
+}
+
+func TestQueuePeek(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	compStr := "value for item"
+
+	if _, err = q.EnqueueString(compStr); err != nil {
+		t.Error(err)
+	}
+
+	peekItem, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if peekItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, peekItem.ToString())
+	}
+
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+}
+
+func TestQueuePeekWithLength(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if item, length, err := q.PeekWithLength(); item != nil || length != 0 || err != ErrEmpty {
+		t.Errorf("Expected nil item, length of 0 and empty error, got %v, %d, %v", item, length, err)
+	}
+
+	// An item that is not ready is skipped using the write lock.
+	if _, err = q.EnqueueAt([]byte("value for later item"), time.Now().Add(time.Hour)); err != nil {
+		t.Error(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, length, err := q.PeekWithLength()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if length != 4 {
+		t.Errorf("Expected queue length of 4, got %d", length)
+	}
+
+	// The head item cached by Peek is returned along with the length.
+	q2, err := OpenQueue(file + "_2")
+	if err != nil {
+		t.Error(err)
+	}
+	defer q2.Drop()
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q2.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err = q2.Peek(); err != nil {
+		t.Error(err)
+	}
+	if _, err = q2.EnqueueString("value for item 4"); err != nil {
+		t.Error(err)
+	}
+
+	item, length, err = q2.PeekWithLength()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if length != 4 {
+		t.Errorf("Expected queue length of 4, got %d", length)
+	}
+}
+
+func TestQueuePeekCachedHead(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	peek := func(compStr string) {
+		t.Helper()
+		item, err := q.Peek()
+		if err != nil {
+			t.Error(err)
+		} else if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Changing the returned item does not change the cached item.
+	item, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+	item.Value[0] = 'X'
+	peek("value for item 1")
+
+	// The cached item is dropped when the head changes.
+	if _, err = q.UpdateString(1, "new value for item 1"); err != nil {
+		t.Error(err)
+	}
+	peek("new value for item 1")
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+	peek("value for item 2")
+
+	if _, err = q.DequeueByID(2); err != nil {
+		t.Error(err)
+	}
+	peek("value for item 3")
+
+	// As well as when an in-flight item goes back into the queue.
+	if _, err = q.Receive(20 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	peek("value for item 4")
+	time.Sleep(30 * time.Millisecond)
+	peek("value for item 3")
+
+	// Or when the cached item expires.
+	if err = q.Clear(); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.EnqueueWithTTL([]byte("value for item 6"), 20*time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.EnqueueString("value for item 7"); err != nil {
+		t.Error(err)
+	}
+	peek("value for item 6")
+	time.Sleep(30 * time.Millisecond)
+	peek("value for item 7")
+
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.Peek(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
+
+func TestQueueDequeueCachedHead(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	dequeue := func(compStr string) {
+		t.Helper()
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		} else if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Dequeue returns the item cached by Peek.
+	item, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+	item.Value[0] = 'X'
+	dequeue("value for item 1")
+
+	// Updating the head item drops it from the cache.
+	if _, err = q.Peek(); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.UpdateString(2, "new value for item 2"); err != nil {
+		t.Error(err)
+	}
+	dequeue("new value for item 2")
+
+	// As does clearing the queue.
+	if _, err = q.Peek(); err != nil {
+		t.Error(err)
+	}
+	if err = q.Clear(); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	if q.Length() != 0 || q.SizeBytes() != 0 {
+		t.Errorf("Expected queue length and size of 0, got %d and %d", q.Length(), q.SizeBytes())
+	}
+}
+
+func TestQueuePeekTail(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.PeekTail(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	peekItem, err := q.PeekTail()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 10"
+
+	if peekItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, peekItem.ToString())
+	}
+
+	if q.Length() != 10 {
+		t.Errorf("Expected queue length of 10, got %d", q.Length())
+	}
+}
+
+func TestQueuePeekByOffset(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	compStrFirst := "value for item 1"
+	compStrLast := "value for item 10"
+	compStr := "value for item 4"
+
+	peekFirstItem, err := q.PeekByOffset(0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if peekFirstItem.ToString() != compStrFirst {
+		t.Errorf("Expected string to be '%s', got '%s'", compStrFirst, peekFirstItem.ToString())
+	}
+
+	peekLastItem, err := q.PeekByOffset(9)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if peekLastItem.ToString() != compStrLast {
+		t.Errorf("Expected string to be '%s', got '%s'", compStrLast, peekLastItem.ToString())
+	}
+
+	peekItem, err := q.PeekByOffset(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if peekItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, peekItem.ToString())
+	}
+
+	if q.Length() != 10 {
+		t.Errorf("Expected queue length of 10, got %d", q.Length())
+	}
+}
+
+func TestQueuePeekFromTail(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.PeekFromTail(0); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	for offset, n := range []int{10, 9, 8} {
+		item, err := q.PeekFromTail(uint64(offset))
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", n)
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	if _, err = q.PeekFromTail(9); err != ErrOutOfBounds {
+		t.Errorf("Expected to get out of bounds error, got %v", err)
+	}
+
+	// Gaps are stepped over.
+	if _, err = q.DequeueByID(9); err != nil {
+		t.Error(err)
+	}
+
+	for offset, n := range map[uint64]int{0: 10, 1: 8, 7: 2} {
+		item, err := q.PeekFromTail(offset)
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", n)
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	if _, err = q.PeekFromTail(8); err != ErrOutOfBounds {
+		t.Errorf("Expected to get out of bounds error, got %v", err)
+	}
+}
+
+func TestQueuePeekRange(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.PeekRange(0, 1); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	items, err := q.PeekRange(2, 3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(items) != 3 {
+		t.Errorf("Expected 3 items, got %d", len(items))
+	}
+
+	for i, item := range items {
+		compStr := fmt.Sprintf("value for item %d", i+4)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	// A range running past the tail returns the items that exist.
+	if items, err = q.PeekRange(7, 5); err != nil {
+		t.Error(err)
+	}
+
+	if len(items) != 2 || items[1].ID != 10 {
+		t.Errorf("Expected items 9 and 10, got %d items", len(items))
+	}
+
+	if _, err = q.PeekRange(9, 1); err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %v", err)
+	}
+
+	if q.Length() != 9 {
+		t.Errorf("Expected queue length of 9, got %d", q.Length())
+	}
+}
+
+func TestQueuePeekNCommit(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, _, err = q.PeekN(1); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	items, token, err := q.PeekN(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(items) != 3 || q.Length() != 10 {
+		t.Errorf("Expected 3 items and queue length of 10, got %d and %d", len(items), q.Length())
+	}
+
+	if err = q.Commit(token); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 7 {
+		t.Errorf("Expected queue length of 7, got %d", q.Length())
+	}
+
+	// Committing twice fails, as the items are gone.
+	if err = q.Commit(token); err != ErrStaleToken {
+		t.Errorf("Expected to get stale token error, got %v", err)
+	}
+
+	// Items dequeued in between fail the commit.
+	if _, token, err = q.PeekN(3); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Commit(token); err != ErrStaleToken {
+		t.Errorf("Expected to get stale token error, got %v", err)
+	}
+
+	if q.Length() != 6 {
+		t.Errorf("Expected queue length of 6, got %d", q.Length())
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 5"
+
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+	}
+}
+
+func TestQueuePeekNCommitReusedID(t *testing.T) {
+	for _, bc := range []struct {
+		name  string
+		opts  []QueueOption
+		n     uint64
+		left  uint64
+		value string
+	}{
+		{"Tail", nil, 3, 3, "d"},
+		{"ResetOnEmpty", []QueueOption{WithResetOnEmpty()}, 1, 1, "d"},
+		{"ResetOnEmptySameValue", []QueueOption{WithResetOnEmpty()}, 1, 1, "a"},
+	} {
+		file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+		q, err := OpenQueue(file, bc.opts...)
+		if err != nil {
+			t.Error(err)
+		}
+
+		for _, value := range []string{"a", "b", "c"} {
+			if _, err = q.EnqueueString(value); err != nil {
+				t.Error(err)
+			}
+		}
+
+		_, token, err := q.PeekN(bc.n)
+		if err != nil {
+			t.Error(err)
+		}
+
+		// Remove the peeked items and add a new one that is given the
+		// ID of one of them, unless IDs are never reused, even if it has
+		// the same value.
+		if bc.opts == nil {
+			_, err = q.DequeueByID(3)
+		} else {
+			_, err = q.DequeueBatch(3)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+		if _, err = q.EnqueueString(bc.value); err != nil {
+			t.Error(err)
+		}
+
+		if err = q.Commit(token); err != ErrStaleToken {
+			t.Errorf("%s: Expected to get stale token error, got %v", bc.name, err)
+		}
+
+		if q.Length() != bc.left {
+			t.Errorf("%s: Expected queue length of %d, got %d", bc.name, bc.left, q.Length())
+		}
+
+		if item, err := q.PeekTail(); err != nil {
+			t.Error(err)
+		} else if item.ToString() != bc.value {
+			t.Errorf("%s: Expected string to be '%s', got '%s'", bc.name, bc.value, item.ToString())
+		}
+
+		q.Drop()
+	}
+}
+
+func TestQueuePeekByID(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	compStr := "value for item 3"
+
+	peekItem, err := q.PeekByID(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if peekItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, peekItem.ToString())
+	}
+
+	if q.Length() != 10 {
+		t.Errorf("Expected queue length of 10, got %d", q.Length())
+	}
+}
+
+func TestQueueForEach(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	var ids []uint64
+	err = q.ForEach(func(item *Item) error {
+		compStr := fmt.Sprintf("value for item %d", item.ID)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+
+		ids = append(ids, item.ID)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(ids) != 9 || ids[0] != 2 || ids[8] != 10 {
+		t.Errorf("Expected to iterate over items 2 through 10, got %v", ids)
+	}
+
+	// Returning an error should stop the iteration.
+	errStop := errors.New("stop")
+	count := 0
+	err = q.ForEach(func(item *Item) error {
+		count++
+		if count == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("Expected to get stop error, got %v", err)
+	}
+
+	if count != 3 {
+		t.Errorf("Expected iteration to stop after 3 items, got %d", count)
+	}
+
+	if q.Length() != 9 {
+		t.Errorf("Expected queue length of 9, got %d", q.Length())
+	}
+}
+
+func TestQueueCountFindFunc(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	even := func(item *Item) bool {
+		return item.ID%2 == 0
+	}
+
+	n, err := q.CountFunc(even)
+	if err != nil {
+		t.Error(err)
+	}
+	if n != 5 {
+		t.Errorf("Expected to count 5 items, got %d", n)
+	}
+
+	item, err := q.FindFunc(even)
+	if err != nil {
+		t.Error(err)
+	}
+	if item.ID != 2 {
+		t.Errorf("Expected item ID to be 2, got %d", item.ID)
+	}
+
+	_, err = q.FindFunc(func(item *Item) bool {
+		return item.ToString() == "value for item 1"
+	})
+	if err != ErrNotFound {
+		t.Errorf("Expected to get not found error, got %v", err)
+	}
+
+	q.Close()
+
+	if _, err = q.CountFunc(even); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
+
+func TestQueueUpdate(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, err := q.PeekByID(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	oldCompStr := "value for item 3"
+	newCompStr := "new value for item 3"
+
+	if item.ToString() != oldCompStr {
+		t.Errorf("Expected string to be '%s', got '%s'", oldCompStr, item.ToString())
+	}
+
+	updatedItem, err := q.Update(item.ID, []byte(newCompStr))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if updatedItem.ToString() != newCompStr {
+		t.Errorf("Expected current item value to be '%s', got '%s'", newCompStr, item.ToString())
+	}
+
+	newItem, err := q.PeekByID(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if newItem.ToString() != newCompStr {
+		t.Errorf("Expected new item value to be '%s', got '%s'", newCompStr, item.ToString())
+	}
+}
+
+func TestQueueUpdateString(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, err := q.PeekByID(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	oldCompStr := "value for item 3"
+	newCompStr := "new value for item 3"
+
+	if item.ToString() != oldCompStr {
+		t.Errorf("Expected string to be '%s', got '%s'", oldCompStr, item.ToString())
+	}
+
+	updatedItem, err := q.UpdateString(item.ID, newCompStr)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if updatedItem.ToString() != newCompStr {
+		t.Errorf("Expected current item value to be '%s', got '%s'", newCompStr, item.ToString())
+	}
+
+	newItem, err := q.PeekByID(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if newItem.ToString() != newCompStr {
+		t.Errorf("Expected new item value to be '%s', got '%s'", newCompStr, item.ToString())
+	}
+}
+
+func TestQueueUpdateObject(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	type object struct {
+		Value int
+	}
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueObject(object{i}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, err := q.PeekByID(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	oldCompObj := object{3}
+	newCompObj := object{33}
+
+	var obj object
+	if err := item.ToObject(&obj); err != nil {
+		t.Error(err)
+	}
+
+	if obj != oldCompObj {
+		t.Errorf("Expected object to be '%+v', got '%+v'", oldCompObj, obj)
+	}
+
+	updatedItem, err := q.UpdateObject(item.ID, newCompObj)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := updatedItem.ToObject(&obj); err != nil {
+		t.Error(err)
+	}
+
+	if obj != newCompObj {
+		t.Errorf("Expected current object to be '%+v', got '%+v'", newCompObj, obj)
+	}
+
+	newItem, err := q.PeekByID(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := newItem.ToObject(&obj); err != nil {
+		t.Error(err)
+	}
+
+	if obj != newCompObj {
+		t.Errorf("Expected new object to be '%+v', got '%+v'", newCompObj, obj)
+	}
+}
+
+func TestQueueEnqueueString(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	compStr := "value for item"
+
+	item, err := q.EnqueueString(compStr)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ID != 1 {
+		t.Errorf("Expected item ID to be 1, got %d", item.ID)
+	}
+
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+}
+
+func TestQueueEnqueueObject(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	type object struct {
+		Value int
+	}
+
+	item, err := q.EnqueueObject(object{1})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ID != 1 {
+		t.Errorf("Expected item ID to be 1, got %d", item.ID)
+	}
+
+	if _, err = q.EnqueueObject(nil); err != ErrNilObject {
+		t.Errorf("Expected to get nil object error, got %v", err)
+	}
+
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	var obj object
+	if err := deqItem.ToObject(&obj); err != nil {
+		t.Error(err)
+	}
+
+	if obj != (object{1}) {
+		t.Errorf("Expected object to be '%+v', got '%+v'", object{1}, obj)
+	}
+
+	// Values not encoded with gob should fail to decode.
+	if _, err = q.EnqueueString("not a gob value"); err != nil {
+		t.Error(err)
+	}
+
+	deqItem, err = q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := deqItem.ToObject(&obj); err == nil {
+		t.Error("Expected decoding a non-gob value to fail")
+	}
+}
+
+func TestQueueUpdateOutOfBounds(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if q.Length() != 10 {
+		t.Errorf("Expected queue length of 10, got %d", q.Length())
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 9 {
+		t.Errorf("Expected queue length of 9, got %d", q.Length())
+	}
+
+	if _, err = q.Update(deqItem.ID, []byte(`new value`)); err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %s", err.Error())
+	}
+
+	if _, err = q.Update(deqItem.ID+1, []byte(`new value`)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueUpdateBatch(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err = q.UpdateBatch(map[uint64][]byte{
+		2: []byte(`new value for item 2`),
+		4: []byte(`new value for item 4`),
+	}); err != nil {
+		t.Error(err)
+	}
+
+	// An ID out of bounds fails the whole batch.
+	if err = q.UpdateBatch(map[uint64][]byte{
+		3: []byte(`new value for item 3`),
+		6: []byte(`new value for item 6`),
+	}); err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %v", err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		item, err := q.PeekByID(uint64(i))
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if i == 2 || i == 4 {
+			compStr = "new " + compStr
+		}
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	size := uint64(5*len("value for item 1") + 2*len("new "))
+	if q.SizeBytes() != size {
+		t.Errorf("Expected size of %d bytes, got %d", size, q.SizeBytes())
+	}
+}
+
+func TestQueueClear(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Clear(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
+
+	if _, err = q.Peek(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	item, err := q.EnqueueString("value for new item")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ID != 1 {
+		t.Errorf("Expected item ID to be 1, got %d", item.ID)
+	}
+
+	// The cleared items should not come back after reopening.
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if q, err = OpenQueue(file); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+}
+
+func TestQueueDB(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	item, err := q.EnqueueString("value for item 1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	value, err := q.DB().Get(item.Key, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+
+	if string(value) != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, value)
+	}
+
+	if _, err = q.DB().GetProperty("leveldb.stats"); err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if q.DB() != nil {
+		t.Error("Expected database of closed queue to be nil")
+	}
+}
+
+func TestQueueProperty(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for _, name := range []string{"leveldb.stats", "leveldb.sstables", "leveldb.num-files-at-level0"} {
+		if _, err = q.Property(name); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.Property("leveldb.unknown"); !errors.Is(err, leveldb.ErrNotFound) {
+		t.Errorf("Expected to get not found error, got %v", err)
+	}
+
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.Property("leveldb.stats"); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
+
+func TestQueueStats(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	want := QueueStats{Head: 3, Tail: 10, Length: 7, DataDir: file}
+	if stats := q.Stats(); stats != want {
+		t.Errorf("Expected stats to be '%+v', got '%+v'", want, stats)
+	}
+}
+
+func TestQueueIDExhausted(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	// Move the queue to the end of the ID space.
+	q.head = math.MaxUint64 - 2
+	q.tail = math.MaxUint64 - 2
+
+	for i := 1; i <= 2; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.EnqueueString("value for item 3"); err != ErrIDExhausted {
+		t.Errorf("Expected to get ID exhausted error, got %v", err)
+	}
+
+	if _, err = q.EnqueueBatch([][]byte{[]byte("value for item 3")}); err != ErrIDExhausted {
+		t.Errorf("Expected to get ID exhausted error, got %v", err)
+	}
+
+	if q.Length() != 2 {
+		t.Errorf("Expected queue length of 2, got %d", q.Length())
+	}
+
+	// Compacting should make room for new items.
+	if err = q.Compact(); err != nil {
+		t.Error(err)
+	}
+
+	item, err := q.EnqueueString("value for item 3")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ID != 3 {
+		t.Errorf("Expected item ID to be 3, got %d", item.ID)
+	}
+
+	for i := 1; i <= 3; i++ {
+		deqItem, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if deqItem.ID != uint64(i) || deqItem.ToString() != compStr {
+			t.Errorf("Expected item %d to be '%s', got item %d '%s'", i, compStr, deqItem.ID, deqItem.ToString())
+		}
+	}
+}
+
+func TestQueueCompact(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Leave a high head and gaps in the middle.
+	for i := 1; i <= 3; i++ {
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, id := range []uint64{5, 8} {
+		if _, err = q.DequeueByID(id); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err = q.Compact(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 5 {
+		t.Errorf("Expected queue length of 5, got %d", q.Length())
+	}
+
+	// The items keep their order and get IDs 1 to 5.
+	for i, n := range []int{4, 6, 7, 9, 10} {
+		item, err := q.PeekByID(uint64(i + 1))
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", n)
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	item, err := q.EnqueueString("value for item 11")
+	if err != nil {
+		t.Error(err)
+	}
+	if item.ID != 6 {
+		t.Errorf("Expected item ID to be 6, got %d", item.ID)
+	}
+}
+
+func TestQueueResetOnEmpty(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithResetOnEmpty())
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// IDs continue while the queue is not empty.
+	for i := 1; i <= 4; i++ {
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, err := q.EnqueueString("value for item 6")
+	if err != nil {
+		t.Error(err)
+	}
+	if item.ID != 6 {
+		t.Errorf("Expected item ID to be 6, got %d", item.ID)
+	}
+
+	// Drain the queue, after which the IDs start over.
+	for i := 1; i <= 2; i++ {
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, err = q.EnqueueString("value for item 7")
+	if err != nil {
+		t.Error(err)
+	}
+	if item.ID != 1 {
+		t.Errorf("Expected item ID to be 1, got %d", item.ID)
+	}
+
+	items, err := q.EnqueueBatch([][]byte{[]byte("value for item 8")})
+	if err != nil {
+		t.Error(err)
+	}
+	if items[0].ID != 2 {
+		t.Errorf("Expected item ID to be 2, got %d", items[0].ID)
+	}
+
+	for _, compStr := range []string{"value for item 7", "value for item 8"} {
+		deqItem, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+}
+
+func TestQueueEmpty(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	_, err = q.EnqueueString("value for item")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = q.Dequeue()
+	if err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %s", err.Error())
+	}
+}
+
+func TestQueueOutOfBounds(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	_, err = q.EnqueueString("value for item")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = q.PeekByOffset(2)
+	if err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %s", err.Error())
+	}
+}
+
+func TestQueueConcurrentLength(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	const ops = 200
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < ops; i++ {
+			if _, err := q.EnqueueString("value"); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < ops; i++ {
+			if _, err := q.Dequeue(); err != nil && err != ErrEmpty {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < ops; i++ {
+			if length := q.Length(); length > ops {
+				t.Errorf("Expected queue length of at most %d, got %d", ops, length)
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestQueueDequeueRaceLast(t *testing.T) {
+	for _, bc := range []struct {
+		name string
+		opts []QueueOption
+		gaps bool
+	}{
+		{"Head", nil, false},
+		{"Pool", []QueueOption{WithItemPool()}, false},
+		{"Gaps", nil, true},
+	} {
+		file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+		q, err := OpenQueue(file, bc.opts...)
+		if err != nil {
+			t.Error(err)
+		}
+
+		for round := 0; round < 50; round++ {
+			// Leave a gap in front of the last item, so that Dequeue
+			// takes the write lock.
+			if bc.gaps {
+				if _, err = q.EnqueueString("value for removed item"); err != nil {
+					t.Error(err)
+				}
+			}
+			item, err := q.EnqueueString("value for last item")
+			if err != nil {
+				t.Error(err)
+			}
+			if bc.gaps {
+				if _, err = q.DequeueByID(item.ID - 1); err != nil {
+					t.Error(err)
+				}
+			}
+
+			// Both consumers see the last item before racing for it.
+			if q.Length() != 1 {
+				t.Errorf("Expected queue length of 1, got %d", q.Length())
+			}
+
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			errs := make([]error, 2)
+			items := make([]*Item, 2)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					<-start
+					items[i], errs[i] = q.Dequeue()
+				}(i)
+			}
+			close(start)
+			wg.Wait()
+
+			var got, empty int
+			for i, err := range errs {
+				switch {
+				case err == nil && items[i].ToString() == "value for last item":
+					got++
+				case err == ErrEmpty:
+					empty++
+				default:
+					t.Errorf("%s: Expected item or empty error, got %v", bc.name, err)
+				}
+			}
+
+			if got != 1 || empty != 1 {
+				t.Errorf("%s: Expected one item and one empty error, got %d and %d", bc.name, got, empty)
+			}
+		}
+
+		q.Drop()
+	}
+}
+
+func TestQueueRecover(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	_, err = q.EnqueueString("value for item")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = os.Remove(file + "/MANIFEST-000000"); err != nil {
+		t.Error(err)
+	}
+
+	if q, err = OpenQueue(file); !IsCorrupted(err) {
+		t.Errorf("Expected corruption error, got %s", err)
+	}
+	if q, err = RecoverQueue(file); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkQueueEnqueue(b *testing.B) {
+	// Open test database
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		b.Error(err)
+	}
+	defer q.Drop()
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		_, _ = q.Enqueue([]byte("value"))
+	}
+}
+
+func BenchmarkQueueDequeue(b *testing.B) {
+	// Open test database
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		b.Error(err)
+	}
+	defer q.Drop()
+
+	// Fill with dummy data
+	for n := 0; n < b.N; n++ {
+		if _, err = q.Enqueue([]byte("value")); err != nil {
+			b.Error(err)
+		}
+	}
+
+	// Start benchmark
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		_, _ = q.Dequeue()
+	}
+}
+
+func BenchmarkQueueDiscard(b *testing.B) {
+	// Open test database
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		b.Error(err)
+	}
+	defer q.Drop()
+
+	// Fill with dummy data
+	for n := 0; n < b.N; n++ {
+		if _, err = q.Enqueue([]byte("value")); err != nil {
+			b.Error(err)
+		}
+	}
+
+	// Start benchmark
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n += 100 {
+		_, _ = q.Discard(100)
+	}
+}
+
+func BenchmarkQueuePeek(b *testing.B) {
+	benchmarkQueuePeek(b)
+}
+
+func BenchmarkQueuePeekItemPool(b *testing.B) {
+	benchmarkQueuePeek(b, WithItemPool())
+}
+
+func benchmarkQueuePeek(b *testing.B, opts ...QueueOption) {
+	// Open test database
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, opts...)
+	if err != nil {
+		b.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.Enqueue([]byte("value")); err != nil {
+		b.Error(err)
+	}
+
+	// Start benchmark
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		item, _ := q.Peek()
+		q.ReleaseItem(item)
+	}
+}
+
+func BenchmarkQueuePeekConcurrentEnqueue(b *testing.B) {
+	// Open test database
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		b.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.Enqueue([]byte("value")); err != nil {
+		b.Error(err)
+	}
+
+	// Keep enqueuing while peeking.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					_, _ = q.Enqueue([]byte("value"))
+				}
+			}
+		}()
+	}
+
+	// Start benchmark
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		_, _ = q.Peek()
+	}
+
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkQueuePeekDequeue(b *testing.B) {
+	// Open test database
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		b.Error(err)
+	}
+	defer q.Drop()
+
+	for n := 0; n < b.N; n++ {
+		if _, err = q.Enqueue([]byte("value")); err != nil {
+			b.Error(err)
+		}
+	}
+
+	// Start benchmark
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		_, _ = q.Peek()
+		_, _ = q.Dequeue()
+	}
+}
+
+func BenchmarkQueueEnqueueDequeue(b *testing.B) {
+	// Open test database
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		b.Error(err)
+	}
+	defer q.Drop()
+
+	// Start benchmark
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		_, _ = q.Enqueue([]byte("value"))
+		_, _ = q.Dequeue()
+	}
+}
+
+func BenchmarkQueueEnqueueDequeueItemPool(b *testing.B) {
+	// Open test database
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithItemPool())
+	if err != nil {
+		b.Error(err)
+	}
+	defer q.Drop()
+
+	// Start benchmark
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		_, _ = q.Enqueue([]byte("value"))
+		item, _ := q.Dequeue()
+		q.ReleaseItem(item)
+	}
+}
+
+func BenchmarkQueueProducerConsumer(b *testing.B) {
+	benchmarkQueueProducerConsumer(b)
+}
+
+func BenchmarkQueueProducerConsumerSyncWrites(b *testing.B) {
+	benchmarkQueueProducerConsumer(b, WithSyncWrites())
+}
+
+func benchmarkQueueProducerConsumer(b *testing.B, opts ...QueueOption) {
+	// Open test database
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, opts...)
+	if err != nil {
+		b.Error(err)
+	}
+	defer q.Drop()
+
+	// Start benchmark
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	// One goroutine enqueues while another dequeues.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := 0; n < b.N; {
+			if _, err := q.Dequeue(); err == nil {
+				n++
+			}
+		}
+	}()
+	for n := 0; n < b.N; n++ {
+		_, _ = q.Enqueue([]byte("value"))
+	}
+	<-done
+}
+
+func TestQueueSetCodec(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	type object struct {
+		Value int
+	}
+
+	q.SetCodec(JSONObjectCodec{})
+
+	item, err := q.EnqueueObject(object{1})
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := `{"Value":1}`
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if _, err = q.UpdateObject(item.ID, object{2}); err != nil {
+		t.Error(err)
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	var obj object
+	if err := deqItem.ToObject(&obj); err != nil {
+		t.Error(err)
+	}
+
+	if obj != (object{2}) {
+		t.Errorf("Expected object to be '%+v', got '%+v'", object{2}, obj)
+	}
+
+	// A nil codec restores gob.
+	q.SetCodec(nil)
+
+	if _, err = q.EnqueueObject(object{3}); err != nil {
+		t.Error(err)
+	}
+
+	deqItem, err = q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := (JSONObjectCodec{}).Unmarshal(deqItem.Value, &obj); err == nil {
+		t.Error("Expected gob value to not decode as JSON")
+	}
+
+	if err := deqItem.ToObject(&obj); err != nil {
+		t.Error(err)
+	}
+
+	if obj != (object{3}) {
+		t.Errorf("Expected object to be '%+v', got '%+v'", object{3}, obj)
+	}
+}
+
+func TestQueueEnqueueObjectAsJSON(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	type object struct {
+		Value int
+		Time  time.Time
+	}
+
+	// JSON is used whatever the codec of the queue.
+	obj := object{1, time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)}
+	item, err := q.EnqueueObjectAsJSON(obj)
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := `{"Value":1,"Time":"2020-01-02T03:04:05.000000006Z"}`
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if _, err = q.EnqueueObject(obj); err != nil {
+		t.Error(err)
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	var deqObj object
+	if err := deqItem.ToObjectFromJSON(&deqObj); err != nil {
+		t.Error(err)
+	}
+
+	if deqObj.Value != obj.Value || !deqObj.Time.Equal(obj.Time) {
+		t.Errorf("Expected object to be '%+v', got '%+v'", obj, deqObj)
+	}
+
+	// Gob and JSON items coexist in the same queue.
+	if deqItem, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	if err := deqItem.ToObjectFromJSON(&deqObj); err == nil {
+		t.Error("Expected gob value to not decode as JSON")
+	}
+
+	if err := deqItem.ToObject(&deqObj); err != nil {
+		t.Error(err)
+	}
+
+	if deqObj.Value != obj.Value || !deqObj.Time.Equal(obj.Time) {
+		t.Errorf("Expected object to be '%+v', got '%+v'", obj, deqObj)
+	}
+
+	if _, err = q.EnqueueObjectAsJSON(nil); err != ErrNilObject {
+		t.Errorf("Expected to get nil object error, got %v", err)
+	}
+}
+
+func TestQueueWithEnqueueTime(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	// Items enqueued without the option have no enqueue time.
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+
+	q.Close()
+	q, err = OpenQueue(file, WithEnqueueTime())
+	if err != nil {
+		t.Error(err)
+	}
+
+	before := time.Now()
+	item, err := q.EnqueueString("value for item 2")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.EnqueuedAt.Before(before) || item.EnqueuedAt.After(time.Now()) {
+		t.Errorf("Expected enqueue time to be around %s, got %s", before, item.EnqueuedAt)
+	}
+
+	if _, err = q.EnqueueBatch([][]byte{[]byte("value for item 3")}); err != nil {
+		t.Error(err)
+	}
+
+	// The enqueue time is kept when the item is updated.
+	if _, err = q.UpdateString(item.ID, "new value for item 2"); err != nil {
+		t.Error(err)
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+	}
+
+	if !deqItem.EnqueuedAt.IsZero() {
+		t.Errorf("Expected no enqueue time, got %s", deqItem.EnqueuedAt)
+	}
+
+	deqItem, err = q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr = "new value for item 2"
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+	}
+
+	if !deqItem.EnqueuedAt.Equal(item.EnqueuedAt) {
+		t.Errorf("Expected enqueue time to be %s, got %s", item.EnqueuedAt, deqItem.EnqueuedAt)
+	}
+
+	// Reading without the option still decodes the enqueue time.
+	q.Close()
+	q, err = OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	deqItem, err = q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr = "value for item 3"
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+	}
+
+	if deqItem.EnqueuedAt.IsZero() {
+		t.Error("Expected an enqueue time, got the zero time")
+	}
+}
+
+func TestQueueUpdateCAS(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	oldValue := []byte("value for item 1")
+	if _, err = q.Enqueue(oldValue); err != nil {
+		t.Error(err)
+	}
+
+	// Race two updates from the same old value.
+	var wg sync.WaitGroup
+	results := make([]bool, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			item, err := q.Peek()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			newValue := []byte(fmt.Sprintf("new value from writer %d", i))
+			results[i], err = q.UpdateCAS(item, oldValue, newValue)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if results[0] == results[1] {
+		t.Errorf("Expected exactly one update to succeed, got %v", results)
+	}
+
+	winner := 0
+	if results[1] {
+		winner = 1
+	}
+
+	item, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := fmt.Sprintf("new value from writer %d", winner)
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if _, err = q.UpdateCAS(&Item{ID: 2}, oldValue, oldValue); err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %v", err)
+	}
+}
+
+func TestQueueUpdateByIDAfterReopen(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.Update(1, []byte(`new value`)); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Only the ID is known after reopening.
+	q.Close()
+	q, err = OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.UpdateString(2, "new value for item 2"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.Update(4, []byte(`new value`)); err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %v", err)
+	}
+
+	item, err := q.PeekByID(2)
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "new value for item 2"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+}
+
+func TestQueueSync(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err = q.Sync(); err != nil {
+		t.Error(err)
+	}
+
+	// Syncing must not change the items.
+	q.Close()
+	q, err = OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 10 {
+		t.Errorf("Expected queue length of 10, got %d", q.Length())
+	}
+
+	item, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	q.Close()
+	if err = q.Sync(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
+
+func TestQueueCompactRange(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 100; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for i := 1; i <= 90; i++ {
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err = q.CompactRange(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 10 {
+		t.Errorf("Expected queue length of 10, got %d", q.Length())
+	}
+
+	item, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ID != 91 {
+		t.Errorf("Expected item ID to be 91, got %d", item.ID)
+	}
+
+	q.Close()
+	if err = q.CompactRange(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
+
+func TestQueueSetHooks(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	var enqueued, dequeued []uint64
+	q.SetHooks(func(item *Item) {
+		enqueued = append(enqueued, item.ID)
+
+		// The hook must not be able to change the stored item.
+		item.Value[0] = 'X'
+	}, func(item *Item) {
+		dequeued = append(dequeued, item.ID)
+
+		// The lock must not be held while the hook runs.
+		q.Length()
+	})
+
+	item, err := q.EnqueueString("value for item 1")
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if _, err = q.EnqueueBatch([][]byte{[]byte("value for item 2"), []byte("value for item 3")}); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.DequeueBatch(2); err != nil {
+		t.Error(err)
+	}
+
+	// Failed operations do not call the hooks.
+	if _, err = q.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	if fmt.Sprint(enqueued) != "[1 2 3]" {
+		t.Errorf("Expected enqueue hook to be called for items [1 2 3], got %v", enqueued)
+	}
+
+	if fmt.Sprint(dequeued) != "[1 2 3]" {
+		t.Errorf("Expected dequeue hook to be called for items [1 2 3], got %v", dequeued)
+	}
+
+	// Nil hooks are not called.
+	q.SetHooks(nil, nil)
+
+	if _, err = q.EnqueueString("value for item 4"); err != nil {
+		t.Error(err)
+	}
+
+	item, err = q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr = "value for item 4"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if len(enqueued) != 3 || len(dequeued) != 3 {
+		t.Errorf("Expected hooks to not be called, got %v and %v", enqueued, dequeued)
+	}
+}
+
+func TestQueueTryPeekTryDequeue(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if item, err := q.TryPeek(); item != nil || err != nil {
+		t.Errorf("Expected no item and no error, got %v and %v", item, err)
+	}
+
+	if item, err := q.TryDequeue(); item != nil || err != nil {
+		t.Errorf("Expected no item and no error, got %v and %v", item, err)
+	}
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+
+	item, err := q.TryPeek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	item, err = q.TryDequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
+
+	q.Close()
+	if _, err = q.TryDequeue(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
+
+func TestQueueWrappedErrors(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+
+	// Close the database behind the back of the queue, so operations
+	// fail within LevelDB.
+	q.db.Close()
+
+	_, err = q.Dequeue()
+	if !errors.Is(err, leveldb.ErrClosed) {
+		t.Errorf("Expected error to wrap the LevelDB closed error, got %v", err)
+	}
+
+	compStr := "goque: get item 1: leveldb: closed"
+	if err == nil || err.Error() != compStr {
+		t.Errorf("Expected string to be '%s', got '%v'", compStr, err)
+	}
+
+	if _, err = q.EnqueueString("value for item 2"); !errors.Is(err, leveldb.ErrClosed) {
+		t.Errorf("Expected error to wrap the LevelDB closed error, got %v", err)
+	}
+
+	// Sentinel errors are not wrapped.
+	q.head = q.tail
+	q.count = 0
+	if _, err = q.Peek(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	// The database is already closed.
+	q.isOpen = false
+}
+
+func TestQueueMissingItem(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Delete the first two items out of band.
+	for id := uint64(1); id <= 2; id++ {
+		if err = q.db.Delete(idToKey(id), nil); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.Dequeue(); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected to get corrupt error, got %v", err)
+	}
+
+	if !errors.Is(err, leveldb.ErrNotFound) {
+		t.Errorf("Expected error to wrap the LevelDB not found error, got %v", err)
+	}
+
+	if q.Length() != 5 {
+		t.Errorf("Expected queue length of 5, got %d", q.Length())
+	}
+
+	// Skip the missing items instead.
+	q.Close()
+	q, err = OpenQueue(file, WithSkipMissing())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err = q.db.Delete(idToKey(4), nil); err != nil {
+		t.Error(err)
+	}
+
+	for _, compStr := range []string{"value for item 3", "value for item 5"} {
+		item, err := q.Peek()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
+}
+
+func TestQueueWithChecksums(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	// Items written without checksums still read with them on.
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+
+	q.Close()
+	q, err = OpenQueue(file, WithChecksums())
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i := 2; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	// Flip a byte of the value of the next item out of band.
+	data, err := q.db.Get(idToKey(2), nil)
+	if err != nil {
+		t.Error(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = q.db.Put(idToKey(2), data, nil); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.Dequeue(); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Expected to get checksum error, got %v", err)
+	}
+
+	if q.Length() != 2 {
+		t.Errorf("Expected queue length of 2, got %d", q.Length())
+	}
+
+	// The bad item can be discarded to get to the next one.
+	if _, err = q.Discard(1); err != nil {
+		t.Error(err)
+	}
+
+	item, err = q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr = "value for item 3"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+}
+
+func TestOpenQueueCtx(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueueCtx(context.Background(), file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+
+	// A done context fails before opening.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = OpenQueueCtx(ctx, file); err != context.Canceled {
+		t.Errorf("Expected to get context canceled error, got %v", err)
+	}
+
+	// An open that takes too long is abandoned, and closed once done.
+	release := make(chan struct{})
+	dbs := make(chan *leveldb.DB, 1)
+	slowOpen := func(path string, o *opt.Options) (*leveldb.DB, error) {
+		<-release
+		db, err := openMemDB(path, o)
+		dbs <- db
+		return db, err
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	slowFile := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	defer os.RemoveAll(slowFile)
+	if _, err = openQueueCtx(ctx, slowFile, slowOpen, nil); err != context.DeadlineExceeded {
+		t.Errorf("Expected to get deadline exceeded error, got %v", err)
+	}
+
+	close(release)
+	db := <-dbs
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err = db.Get([]byte("key"), nil); err != leveldb.ErrClosed; _, err = db.Get([]byte("key"), nil) {
+		if time.Now().After(deadline) {
+			t.Errorf("Expected abandoned queue to be closed, got %v", err)
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOpenQueueRecover(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Add stray keys that are not valid item keys.
+	for _, key := range [][]byte{[]byte("junk"), idToKey(0), append(idToKey(3), 'x')} {
+		if err = q.db.Put(key, []byte("stray value"), nil); err != nil {
+			t.Error(err)
+		}
+	}
+
+	q.Close()
+	q, repaired, err := OpenQueueRecover(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if repaired != 3 {
+		t.Errorf("Expected 3 keys to be repaired, got %d", repaired)
+	}
+
+	if q.Length() != 5 {
+		t.Errorf("Expected queue length of 5, got %d", q.Length())
+	}
+
+	for i := 1; i <= 5; i++ {
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	// A repaired queue needs no further repairs.
+	q.Close()
+	q, repaired, err = OpenQueueRecover(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if repaired != 0 {
+		t.Errorf("Expected 0 keys to be repaired, got %d", repaired)
+	}
+}
+
+func TestOpenQueueReadOnly(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueWithTTL([]byte("value for item 1"), time.Nanosecond); err != nil {
+		t.Error(err)
+	}
+
+	for i := 2; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+	q.Close()
+
+	rq, err := OpenQueueReadOnly(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer rq.Close()
+
+	// Reads work, and skip the expired item.
+	item, err := rq.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 2"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if _, err = rq.PeekByID(3); err != nil {
+		t.Error(err)
+	}
+
+	if items, err := rq.PeekRange(0, 3); err != nil || len(items) != 3 {
+		t.Errorf("Expected 3 items, got %d and %v", len(items), err)
+	}
+
+	if rq.Length() != 3 {
+		t.Errorf("Expected queue length of 3, got %d", rq.Length())
+	}
+
+	// Writes are rejected.
+	if _, err = rq.EnqueueString("value for item 4"); err != ErrReadOnly {
+		t.Errorf("Expected to get read-only error, got %v", err)
+	}
+
+	if _, err = rq.Dequeue(); err != ErrReadOnly {
+		t.Errorf("Expected to get read-only error, got %v", err)
+	}
+
+	if _, err = rq.UpdateString(2, "new value for item 2"); err != ErrReadOnly {
+		t.Errorf("Expected to get read-only error, got %v", err)
+	}
+
+	if err = rq.Clear(); err != ErrReadOnly {
+		t.Errorf("Expected to get read-only error, got %v", err)
+	}
+
+	if err = rq.Drop(); err != ErrReadOnly {
+		t.Errorf("Expected to get read-only error, got %v", err)
+	}
+
+	// The queue is left unchanged.
+	rq.Close()
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 3 {
+		t.Errorf("Expected queue length of 3, got %d", q.Length())
+	}
+
+	// A directory without a queue is not created.
+	if _, err = OpenQueueReadOnly(file + "_missing"); err == nil {
+		t.Error("Expected an error opening a missing queue read-only")
+	}
+
+	if _, err = os.Stat(file + "_missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected missing queue to not be created, got %v", err)
+	}
+}
+
+func TestQueueSizeBytes(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("12345"); err != nil {
+		t.Error(err)
+	}
+
+	// Metadata does not count towards the size.
+	if _, err = q.EnqueueWithTTL([]byte("1234567890"), time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.EnqueueBatch([][]byte{[]byte("123"), []byte("1234")}); err != nil {
+		t.Error(err)
+	}
+
+	if q.SizeBytes() != 22 {
+		t.Errorf("Expected queue size of 22, got %d", q.SizeBytes())
+	}
+
+	if _, err = q.UpdateString(2, "12"); err != nil {
+		t.Error(err)
+	}
+
+	if q.SizeBytes() != 14 {
+		t.Errorf("Expected queue size of 14, got %d", q.SizeBytes())
+	}
+
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.DequeueByID(4); err != nil {
+		t.Error(err)
+	}
+
+	if q.SizeBytes() != 5 {
+		t.Errorf("Expected queue size of 5, got %d", q.SizeBytes())
+	}
+
+	// The size is recomputed when the queue is opened.
+	q.Close()
+	q, err = OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if q.SizeBytes() != 5 {
+		t.Errorf("Expected queue size of 5, got %d", q.SizeBytes())
+	}
+
+	if _, err = q.DequeueBatch(10); err != nil {
+		t.Error(err)
+	}
+
+	if q.SizeBytes() != 0 {
+		t.Errorf("Expected queue size of 0, got %d", q.SizeBytes())
+	}
+}
+
+func TestQueueTrim(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Leave a gap within the items to remove.
+	if _, err = q.DequeueByID(3); err != nil {
+		t.Error(err)
+	}
+
+	n, err := q.Trim(5)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if n != 4 {
+		t.Errorf("Expected 4 items to be removed, got %d", n)
+	}
+
+	if q.Length() != 5 {
+		t.Errorf("Expected queue length of 5, got %d", q.Length())
+	}
+
+	item, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 6"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	// A queue within the limit is left as is.
+	if n, err = q.Trim(5); err != nil || n != 0 {
+		t.Errorf("Expected no items to be removed, got %d and %v", n, err)
+	}
+
+	if n, err = q.Trim(0); err != nil || n != 5 {
+		t.Errorf("Expected 5 items to be removed, got %d and %v", n, err)
+	}
+
+	if _, err = q.Peek(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+}
+
+func TestQueueOverflowDropOldest(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithMaxLength(3), WithOverflowPolicy(DropOldest))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	var evicted []uint64
+	q.SetEvictHook(func(item *Item) {
+		evicted = append(evicted, item.ID)
+	})
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if fmt.Sprint(evicted) != "[1 2]" {
+		t.Errorf("Expected items [1 2] to be evicted, got %v", evicted)
+	}
+
+	if q.Length() != 3 {
+		t.Errorf("Expected queue length of 3, got %d", q.Length())
+	}
+
+	if _, err = q.EnqueueBatch([][]byte{[]byte("value for item 6"), []byte("value for item 7")}); err != nil {
+		t.Error(err)
+	}
+
+	if fmt.Sprint(evicted) != "[1 2 3 4]" {
+		t.Errorf("Expected items [1 2 3 4] to be evicted, got %v", evicted)
+	}
+
+	// A batch larger than the queue is still rejected.
+	if _, err = q.EnqueueBatch(make([][]byte, 4)); err != ErrFull {
+		t.Errorf("Expected to get queue full error, got %v", err)
+	}
+
+	for i := 5; i <= 7; i++ {
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
+}
+
+func TestQueueEnqueueAt(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	notBefore := time.Now().Add(100 * time.Millisecond)
+	if _, err = q.EnqueueAt([]byte("value for item 1"), notBefore); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.EnqueueString("value for item 2"); err != nil {
+		t.Error(err)
+	}
+
+	// The delayed item is skipped.
+	item, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 2"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if item, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if _, err = q.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+
+	// DequeueCtx waits until the delayed item is ready.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	item, err = q.DequeueCtx(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr = "value for item 1"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	if time.Now().Before(notBefore) {
+		t.Error("Expected delayed item to not be dequeued before its time")
+	}
+
+	if !item.NotBefore.Equal(notBefore) {
+		t.Errorf("Expected not-before time to be %s, got %s", notBefore, item.NotBefore)
+	}
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
+}
+
+func TestQueueDequeueBatchNotReady(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.EnqueueAt([]byte("value for item 2"), time.Now().Add(time.Hour)); err != nil {
+		t.Error(err)
+	}
+
+	for i := 3; i <= 4; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	items, err := q.DequeueBatch(10)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(items) != 3 {
+		t.Errorf("Expected 3 items, got %d", len(items))
+	}
+
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+
+	stats := q.Stats()
+	if stats.Head != 1 || stats.Tail != 4 {
+		t.Errorf("Expected head and tail of 1 and 4, got %d and %d", stats.Head, stats.Tail)
+	}
+
+	if _, err = q.DequeueBatch(10); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	// New items are still added after the tail, without reusing IDs.
+	item, err := q.EnqueueString("value for item 5")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ID != 5 {
+		t.Errorf("Expected item ID to be 5, got %d", item.ID)
+	}
+}
+
+func TestQueueWithItemPool(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithItemPool())
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for i := 1; i <= 10; i++ {
+		item, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+
+		if item.ID != uint64(i) || keyToID(item.Key) != uint64(i) {
+			t.Errorf("Expected item ID to be %d, got %d and key %v", i, item.ID, item.Key)
+		}
+
+		q.ReleaseItem(item)
+	}
+
+	// Items holding the value of the caller are not pooled.
+	value := []byte("value for item 11")
+	item, err := q.Enqueue(value)
+	if err != nil {
+		t.Error(err)
+	}
+	q.ReleaseItem(item)
+
+	if _, err = q.EnqueueString("value for item 12"); err != nil {
+		t.Error(err)
+	}
+
+	for i := 11; i <= 12; i++ {
+		if item, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+		q.ReleaseItem(item)
+	}
+
+	compStr := "value for item 11"
+	if string(value) != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, value)
+	}
+
+	// Releasing items of a queue without a pool does nothing.
+	q2, err := OpenMemQueue()
+	if err != nil {
+		t.Error(err)
+	}
+	defer q2.Close()
+
+	q2.ReleaseItem(&Item{})
+}
+
+func TestAppendKey(t *testing.T) {
+	// Keys must sort in the same order as their IDs.
+	ids := []uint64{0, 1, 255, 256, 1 << 32, math.MaxUint64}
+	buf := make([]byte, 0, 8)
+	for i := 1; i < len(ids); i++ {
+		prev := string(idToKey(ids[i-1]))
+		buf = appendKey(buf[:0], ids[i])
+		if prev >= string(buf) {
+			t.Errorf("Expected key of %d to sort after key of %d", ids[i], ids[i-1])
+		}
+
+		if keyToID(buf) != ids[i] {
+			t.Errorf("Expected ID to be %d, got %d", ids[i], keyToID(buf))
+		}
+	}
+}
+
+func TestQueueProducerConsumer(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	const ops = 500
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < ops; i++ {
+			if _, err := q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < ops; {
+			item, err := q.Dequeue()
+			if err == ErrEmpty {
+				continue
+			} else if err != nil {
+				t.Error(err)
+				return
+			}
+
+			compStr := fmt.Sprintf("value for item %d", i)
+			if item.ToString() != compStr {
+				t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+			}
+			i++
+		}
+	}()
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			stats := q.Stats()
+			if stats.Head > stats.Tail || stats.Length != stats.Tail-stats.Head {
+				t.Errorf("Expected length to be %d, got %d", stats.Tail-stats.Head, stats.Length)
+			}
+			if _, err := q.Peek(); err != nil && err != ErrEmpty {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-stopped
+
+	if q.Length() != 0 {
+		t.Errorf("Expected queue length of 0, got %d", q.Length())
+	}
+}
+
+func TestNewQueueFromDB(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	db, err := leveldb.OpenFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(file)
+	defer db.Close()
+
+	if err = db.Put([]byte("other"), []byte("other value"), nil); err != nil {
+		t.Error(err)
+	}
+
+	q, err := NewQueueFromDB(db, []byte("a:"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	q2, err := NewQueueFromDB(db, []byte("b:"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+		if _, _, err = q2.EnqueueUnique([]byte(fmt.Sprintf("value for item %d", i+10))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if q.Length() != 10 || q2.Length() != 10 {
+		t.Errorf("Expected queue lengths of 10, got %d and %d", q.Length(), q2.Length())
+	}
+
+	for i := 1; i <= 5; i++ {
+		deqItem, err := q2.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i+10)
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+
+	// Closing the queue keeps the database open, and a new queue
+	// continues from the stored items.
+	if err = q2.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if q2, err = NewQueueFromDB(db, []byte("b:")); err != nil {
+		t.Error(err)
+	}
+
+	if q2.Length() != 5 {
+		t.Errorf("Expected queue length of 5, got %d", q2.Length())
+	}
+
+	deqItem, err := q2.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if deqItem.ID != 6 {
+		t.Errorf("Expected item ID to be 6, got %d", deqItem.ID)
+	}
+
+	// Dropping the queue removes only its own keys.
+	if err = q.Drop(); err != nil {
+		t.Error(err)
+	}
+
+	if q2.Length() != 4 {
+		t.Errorf("Expected queue length of 4, got %d", q2.Length())
+	}
+
+	value, err := db.Get([]byte("other"), nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(value) != "other value" {
+		t.Errorf("Expected string to be 'other value', got '%s'", value)
+	}
+
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		if key := string(iter.Key()); key[:2] == "a:" {
+			t.Errorf("Expected key '%s' to be deleted", key)
+		}
+	}
+	iter.Release()
+}
+
+func TestQueueWatch(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	w1, w2 := q.Watch(), q.Watch()
+
+	// Signals are coalesced, so several enqueues do not block.
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, w := range []<-chan struct{}{w1, w2} {
+		select {
+		case <-w:
+		default:
+			t.Error("Expected watch channel to be signalled")
+		}
+
+		select {
+		case <-w:
+			t.Error("Expected a single signal on watch channel")
+		default:
+		}
+	}
+
+	// A waiting consumer is woken by the next enqueue.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-w1
+		if q.Length() != 4 {
+			t.Errorf("Expected queue length of 4, got %d", q.Length())
+		}
+	}()
+	if _, err = q.EnqueueString("value for item 4"); err != nil {
+		t.Error(err)
+	}
+	<-done
+
+	// Closing the queue closes the channels.
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	for _, w := range []<-chan struct{}{w2, q.Watch()} {
+		for range w {
+		}
+	}
+}
+
+func TestQueueDiscard(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, _, err = q.EnqueueUnique([]byte("value for item 11")); err != nil {
+		t.Error(err)
+	}
+
+	discarded, err := q.Discard(3)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if discarded != 3 {
+		t.Errorf("Expected 3 items to be discarded, got %d", discarded)
+	}
+
+	if q.Length() != 8 {
+		t.Errorf("Expected queue length of 8, got %d", q.Length())
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 4"
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+	}
+
+	// Asking for more than available discards the rest.
+	if discarded, err = q.Discard(100); err != nil {
+		t.Error(err)
+	}
+
+	if discarded != 7 {
+		t.Errorf("Expected 7 items to be discarded, got %d", discarded)
+	}
+
+	if q.Length() != 0 || q.SizeBytes() != 0 {
+		t.Errorf("Expected queue length and size of 0, got %d and %d", q.Length(), q.SizeBytes())
+	}
+
+	// The unique item was removed from the index as well.
+	if _, ok, err := q.EnqueueUnique([]byte("value for item 11")); err != nil || !ok {
+		t.Errorf("Expected unique item to be added, got %t and %v", ok, err)
+	}
+
+	if discarded, err = q.Discard(0); err != nil || discarded != 0 {
+		t.Errorf("Expected 0 items to be discarded, got %d and %v", discarded, err)
+	}
+}
+
+func TestQueueDrain(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Fail on the fourth item.
+	errStop := errors.New("stop")
+	i := 1
+	n, err := q.Drain(func(item *Item) error {
+		compStr := fmt.Sprintf("value for item %d", i)
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+		if i == 4 {
+			return errStop
+		}
+		i++
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("Expected to get stop error, got %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected to drain 3 items, got %d", n)
+	}
+
+	// The failed item stays at the head, and items added while
+	// draining are left in the queue.
+	n, err = q.Drain(func(item *Item) error {
+		if item.ID == 4 {
+			if _, err := q.EnqueueString("value for item 6"); err != nil {
+				t.Error(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected to drain 2 items, got %d", n)
+	}
+
+	item, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 6"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+}
+
+func TestQueueShutdown(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Items after the one fn fails on are left for the next start.
+	errProcess := errors.New("process failed")
+	var values []string
+	err = q.Shutdown(context.Background(), func(item *Item) error {
+		if _, err := q.EnqueueString("value for new item"); err != ErrClosing {
+			t.Errorf("Expected to get closing error, got %v", err)
+		}
+		txn := q.Begin()
+		if _, err := txn.Enqueue([]byte("value for new item")); err != ErrClosing {
+			t.Errorf("Expected to get closing error, got %v", err)
+		}
+		txn.Rollback()
+		if err := q.Import(bytes.NewReader(nil)); err != ErrClosing {
+			t.Errorf("Expected to get closing error, got %v", err)
+		}
+		if _, err := q.SplitAt(0, file+"_split"); err != ErrClosing {
+			t.Errorf("Expected to get closing error, got %v", err)
+		}
+		if item.ID == 4 {
+			return errProcess
+		}
+		values = append(values, item.ToString())
+		return nil
+	})
+	if err != errProcess {
+		t.Errorf("Expected to get process error, got %v", err)
+	}
+
+	if len(values) != 3 {
+		t.Errorf("Expected 3 processed items, got %d", len(values))
+	}
+
+	if q.IsOpen() {
+		t.Error("Expected queue to be closed")
+	}
+
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 7 {
+		t.Errorf("Expected queue length of 7, got %d", q.Length())
+	}
+
+	// A reopened queue accepts items again.
+	if _, err = q.EnqueueString("value for item 11"); err != nil {
+		t.Error(err)
+	}
+
+	// A done context stops the drain.
+	ctx, cancel := context.WithCancel(context.Background())
+	err = q.Shutdown(ctx, func(item *Item) error {
+		if item.ID == 6 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected to get context canceled error, got %v", err)
+	}
+
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 5 {
+		t.Errorf("Expected queue length of 5, got %d", q.Length())
+	}
+
+	// Everything is processed otherwise.
+	var n int
+	if err = q.Shutdown(context.Background(), func(item *Item) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+
+	if n != 5 {
+		t.Errorf("Expected 5 processed items, got %d", n)
+	}
+
+	if err = q.Shutdown(context.Background(), nil); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
 	}
 }
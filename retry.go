@@ -0,0 +1,57 @@
+package goque
+
+import (
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// RetryPolicy determines how often the reads and writes of Enqueue,
+// Dequeue and Peek are retried when LevelDB returns an error that may
+// be transient, such as when the process has too many open files.
+//
+// Errors that cannot go away by retrying fail right away. These are
+// the goque errors such as ErrEmpty and ErrOutOfBounds, a missing key,
+// a closed or read-only database, and corruption.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times an operation is attempted,
+	// including the first. Values below 2 disable retries.
+	MaxAttempts int
+
+	// Backoff is the time waited before the second attempt, which is
+	// doubled before each attempt after it.
+	Backoff time.Duration
+}
+
+// do calls op until it succeeds, returns an error that is not
+// transient, or has been attempted MaxAttempts times, and returns the
+// error of the last attempt.
+func (p RetryPolicy) do(op func() error) error {
+	err := op()
+	wait := p.Backoff
+	for attempt := 1; attempt < p.MaxAttempts && isTransient(err); attempt++ {
+		time.Sleep(wait)
+		wait *= 2
+		err = op()
+	}
+	return err
+}
+
+// isTransient returns true if the given error may go away when the
+// operation is retried.
+func isTransient(err error) bool {
+	switch err {
+	case nil, ErrEmpty, ErrOutOfBounds, ErrDBClosed, ErrReadOnly, ErrFull,
+		leveldb.ErrNotFound, leveldb.ErrClosed, leveldb.ErrReadOnly,
+		leveldb.ErrSnapshotReleased, leveldb.ErrIterReleased:
+		return false
+	}
+	return !IsCorrupted(err)
+}
+
+// retry calls op according to the retry policy of the queue. The caller
+// must hold the lock, which is then held while waiting between
+// attempts.
+func (q *Queue) retry(op func() error) error {
+	return q.retryPolicy.do(op)
+}
@@ -0,0 +1,239 @@
+package goque
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	ldberrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// faultyStorage is an in-memory storage that fails the next opens of
+// its tables or syncs of its journal with err, as many times as set,
+// and counts the opens and syncs since.
+type faultyStorage struct {
+	storage.Storage
+
+	mu       sync.Mutex
+	failures int
+	calls    int
+	err      error
+}
+
+// failNext makes the next n opens or syncs fail with err.
+func (s *faultyStorage) failNext(n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures, s.calls, s.err = n, 0, err
+}
+
+// count returns the number of opens or syncs since failNext.
+func (s *faultyStorage) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// fault returns the error of the next failure, if any.
+func (s *faultyStorage) fault() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.failures == 0 {
+		return nil
+	}
+	s.failures--
+	return s.err
+}
+
+func (s *faultyStorage) Open(fd storage.FileDesc) (storage.Reader, error) {
+	if fd.Type == storage.TypeTable {
+		if err := s.fault(); err != nil {
+			return nil, err
+		}
+	}
+	return s.Storage.Open(fd)
+}
+
+func (s *faultyStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	w, err := s.Storage.Create(fd)
+	if err != nil || fd.Type != storage.TypeJournal {
+		return w, err
+	}
+	return faultyWriter{w, s}, nil
+}
+
+type faultyWriter struct {
+	storage.Writer
+	s *faultyStorage
+}
+
+func (w faultyWriter) Sync() error {
+	if err := w.s.fault(); err != nil {
+		return err
+	}
+	return w.Writer.Sync()
+}
+
+// openFaultyQueue opens an in-memory queue on the given storage.
+func openFaultyQueue(stor *faultyStorage, opts ...QueueOption) (*Queue, error) {
+	open := func(_ string, o *opt.Options) (*leveldb.DB, error) {
+		return leveldb.Open(stor, o)
+	}
+	return openQueue("", open, append([]QueueOption{inMemory()}, opts...))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	errTransient := errors.New("open file: too many open files")
+
+	// An operation failing once succeeds on the second attempt.
+	attempts := 0
+	err := p.do(func() error {
+		attempts++
+		if attempts == 1 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("Expected success after 2 attempts, got %v after %d", err, attempts)
+	}
+
+	// An operation failing every time is attempted MaxAttempts times.
+	attempts = 0
+	err = p.do(func() error {
+		attempts++
+		return errTransient
+	})
+	if err != errTransient || attempts != 3 {
+		t.Errorf("Expected transient error after 3 attempts, got %v after %d", err, attempts)
+	}
+
+	// Errors that are not transient are not retried.
+	for _, permanent := range []error{
+		ErrEmpty,
+		ErrOutOfBounds,
+		ldberrors.ErrNotFound,
+		ldberrors.NewErrCorrupted(storage.FileDesc{}, errors.New("bad block")),
+	} {
+		attempts = 0
+		err = p.do(func() error {
+			attempts++
+			return permanent
+		})
+		if err != permanent || attempts != 1 {
+			t.Errorf("Expected %v after 1 attempt, got %v after %d", permanent, err, attempts)
+		}
+	}
+
+	// The zero policy does not retry.
+	attempts = 0
+	err = RetryPolicy{}.do(func() error {
+		attempts++
+		return errTransient
+	})
+	if err != errTransient || attempts != 1 {
+		t.Errorf("Expected transient error after 1 attempt, got %v after %d", err, attempts)
+	}
+}
+
+func TestQueueWithRetryPolicy(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: 100 * time.Millisecond}))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+	}
+
+	// A missing item is not retried.
+	start := time.Now()
+	if _, err = q.PeekByID(1); err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %v", err)
+	}
+	if _, err = q.DequeueByID(5); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.PeekByID(5); err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %v", err)
+	}
+	if time.Since(start) >= 100*time.Millisecond {
+		t.Errorf("Expected missing item to not be retried, took %v", time.Since(start))
+	}
+}
+
+func TestQueueRetryStorage(t *testing.T) {
+	errTransient := errors.New("open file: too many open files")
+	errCorrupt := ldberrors.NewErrCorrupted(storage.FileDesc{}, errors.New("bad block"))
+
+	stor := &faultyStorage{Storage: storage.NewMemStorage()}
+	q, err := openFaultyQueue(stor, WithSyncWrites(), WithRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// Each write syncs the journal once, so a write failing once
+	// succeeds after two syncs.
+	stor.failNext(1, errTransient)
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+	if stor.count() != 2 {
+		t.Errorf("Expected 2 attempts, got %d", stor.count())
+	}
+
+	// A corrupt write is attempted once.
+	stor.failNext(3, errCorrupt)
+	if _, err = q.EnqueueString("value for item 2"); !IsCorrupted(err) {
+		t.Errorf("Expected to get corruption error, got %v", err)
+	}
+	if stor.count() != 1 {
+		t.Errorf("Expected 1 attempt, got %d", stor.count())
+	}
+	stor.failNext(0, nil)
+
+	// Once the items are in a table that has not been read yet, a read
+	// of an item opens the table until it succeeds, so a read failing
+	// once succeeds after two opens, followed by the sync of the write
+	// removing the item.
+	if err = q.db.CompactRange(util.Range{}); err != nil {
+		t.Error(err)
+	}
+	stor.failNext(1, errTransient)
+	item, err := q.Dequeue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stor.count() != 3 {
+		t.Errorf("Expected 2 attempts and a write, got %d opens and syncs", stor.count())
+	}
+
+	compStr := "value for item 1"
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+}
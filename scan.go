@@ -0,0 +1,67 @@
+package goque
+
+import (
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+// scanItems calls fn with an iterator positioned at each item of the
+// queue in turn, from head to tail. If fn returns an error, the scan
+// stops and that error is returned. The caller must hold the read lock
+// taken by rlock.
+//
+// If the queue has a scan chunk, the iterator and the read lock are
+// released after each chunk, and the scan continues after the last
+// item read, or after the head if it has since moved past that item.
+func (q *Queue) scanItems(fn func(iterator.Iterator) error) error {
+	after := q.head
+	for {
+		last, done, err := q.scanChunkAfter(after, fn)
+		if err != nil || done {
+			return err
+		}
+
+		// Let other operations proceed between chunks.
+		q.runlock()
+		q.rlock()
+
+		// Check if queue is closed.
+		if !q.isOpen {
+			return ErrDBClosed
+		}
+
+		after = last
+		if q.head > after {
+			after = q.head
+		}
+	}
+}
+
+// scanChunkAfter calls fn for the items of the queue after the given
+// ID, up to the scan chunk of the queue. It returns the ID of the last
+// item read, and whether the tail of the queue was reached.
+func (q *Queue) scanChunkAfter(after uint64, fn func(iterator.Iterator) error) (uint64, bool, error) {
+	iter := q.db.NewIterator(q.rangeFrom(after+1), nil)
+	defer iter.Release()
+
+	for n := 0; q.scanChunk <= 0 || n < q.scanChunk; n++ {
+		if !iter.Next() {
+			break
+		}
+		id := q.keyID(iter.Key())
+		if id > q.tail {
+			return after, true, nil
+		}
+		if err := fn(iter); err != nil {
+			return after, true, err
+		}
+		after = id
+	}
+	if err := iter.Error(); err != nil {
+		return after, true, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// More items may follow a full chunk.
+	return after, q.scanChunk <= 0 || !iter.Valid(), nil
+}
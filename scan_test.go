@@ -0,0 +1,87 @@
+package goque
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueWithScanChunk(t *testing.T) {
+	for _, chunk := range []int{1, 3, 4, 100} {
+		file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+		q, err := OpenQueue(file, WithScanChunk(chunk))
+		if err != nil {
+			t.Error(err)
+		}
+
+		for i := 1; i <= 12; i++ {
+			if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+				t.Error(err)
+			}
+		}
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+		if _, err = q.DequeueByID(5); err != nil {
+			t.Error(err)
+		}
+
+		// Every item is visited once, in order, across the chunks.
+		var ids []uint64
+		err = q.ForEach(func(item *Item) error {
+			ids = append(ids, item.ID)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+
+		if fmt.Sprint(ids) != "[2 3 4 6 7 8 9 10 11 12]" {
+			t.Errorf("Expected to visit items 2 to 12 but 5 with chunks of %d, got %v", chunk, ids)
+		}
+
+		// The export is the same as that of a single iterator.
+		var buf bytes.Buffer
+		if err = q.Export(&buf); err != nil {
+			t.Error(err)
+		}
+		q.scanChunk = 0
+		var whole bytes.Buffer
+		if err = q.Export(&whole); err != nil {
+			t.Error(err)
+		}
+
+		if !bytes.Equal(buf.Bytes(), whole.Bytes()) {
+			t.Errorf("Expected export with chunks of %d to match the export without", chunk)
+		}
+
+		q.Drop()
+	}
+}
+
+func TestQueueWithScanChunkStop(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithScanChunk(2))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	item, err := q.FindFunc(func(item *Item) bool {
+		return item.ToString() == "value for item 7"
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ID != 7 {
+		t.Errorf("Expected to find item 7, got %d", item.ID)
+	}
+}
@@ -0,0 +1,107 @@
+package goque
+
+import (
+	"sync"
+)
+
+// Scheduler dequeues items from several queues, such as one queue per
+// tenant, picking the queue for each item by weighted round-robin. A
+// queue with weight 3 gets three items dequeued for each item of a
+// queue with weight 1, as long as neither is empty. The picks are
+// spread out, so a queue with a high weight does not hold up the others
+// for long.
+//
+// The queues are not owned by the scheduler, so they are still opened
+// and closed by the caller.
+type Scheduler struct {
+	sync.Mutex
+
+	// entries holds the queues of the scheduler, in the order they
+	// were added.
+	entries []*schedulerEntry
+}
+
+// schedulerEntry is a queue of a scheduler along with its weight and
+// its current weight in the round-robin.
+type schedulerEntry struct {
+	q       *Queue
+	weight  int
+	current int
+}
+
+// NewScheduler returns a scheduler without any queues.
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Add adds a queue to the scheduler with the given weight. Weights
+// lower than 1 are treated as 1.
+func (s *Scheduler) Add(q *Queue, weight int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if weight < 1 {
+		weight = 1
+	}
+	s.entries = append(s.entries, &schedulerEntry{q: q, weight: weight})
+}
+
+// Next dequeues the next item from the queue picked by weighted
+// round-robin, returning the item along with the queue it came from.
+// Empty queues are skipped, and ErrEmpty is returned only if all of
+// the queues are empty. Any other error from a queue is returned along
+// with that queue.
+func (s *Scheduler) Next() (*Item, *Queue, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// Pick from the queues that are not empty yet.
+	candidates := make([]*schedulerEntry, len(s.entries))
+	copy(candidates, s.entries)
+
+	for len(candidates) > 0 {
+		i := s.pick(candidates)
+		e := candidates[i]
+		item, err := e.q.Dequeue()
+		if err == ErrEmpty {
+			// Undo the pick and leave out the empty queue.
+			s.unpick(candidates, i)
+			candidates = append(candidates[:i], candidates[i+1:]...)
+			continue
+		}
+		if err != nil {
+			return nil, e.q, err
+		}
+		return item, e.q, nil
+	}
+
+	return nil, nil, ErrEmpty
+}
+
+// pick returns the index of the next candidate using smooth weighted
+// round-robin, which raises the current weight of each candidate by its
+// weight and picks the one with the highest, which is then lowered by
+// the total weight of the candidates. The caller must hold the lock.
+func (s *Scheduler) pick(candidates []*schedulerEntry) int {
+	var total, picked int
+	for i, e := range candidates {
+		e.current += e.weight
+		total += e.weight
+		if e.current > candidates[picked].current {
+			picked = i
+		}
+	}
+	candidates[picked].current -= total
+	return picked
+}
+
+// unpick reverts the pick of the candidate at index picked. The caller
+// must hold the lock.
+func (s *Scheduler) unpick(candidates []*schedulerEntry, picked int) {
+	var total int
+	for _, e := range candidates {
+		e.current -= e.weight
+		total += e.weight
+	}
+	candidates[picked].current += total
+}
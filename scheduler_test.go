@@ -0,0 +1,80 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSchedulerNext(t *testing.T) {
+	s := NewScheduler()
+	weights := []int{1, 2, 5}
+	queues := make([]*Queue, len(weights))
+	for i, weight := range weights {
+		file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+		q, err := OpenQueue(file)
+		if err != nil {
+			t.Error(err)
+		}
+		defer q.Drop()
+
+		for j := 1; j <= 100; j++ {
+			if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", j)); err != nil {
+				t.Error(err)
+			}
+		}
+		queues[i] = q
+		s.Add(q, weight)
+	}
+
+	// Each round of 8 picks follows the weights.
+	picks := make(map[*Queue]int)
+	for i := 0; i < 80; i++ {
+		item, q, err := s.Next()
+		if err != nil {
+			t.Error(err)
+		}
+
+		picks[q]++
+		compStr := fmt.Sprintf("value for item %d", picks[q])
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+	for i, q := range queues {
+		if picks[q] != weights[i]*10 {
+			t.Errorf("Expected %d picks for queue %d, got %d", weights[i]*10, i, picks[q])
+		}
+	}
+
+	// Empty queues are skipped.
+	if err := queues[2].Clear(); err != nil {
+		t.Error(err)
+	}
+	picks = make(map[*Queue]int)
+	for i := 0; i < 30; i++ {
+		if _, q, err := s.Next(); err != nil {
+			t.Error(err)
+		} else {
+			picks[q]++
+		}
+	}
+	if picks[queues[0]] != 10 || picks[queues[1]] != 20 || picks[queues[2]] != 0 {
+		t.Errorf("Expected picks of 10, 20 and 0, got %d, %d and %d", picks[queues[0]], picks[queues[1]], picks[queues[2]])
+	}
+
+	// ErrEmpty is returned only once all queues are empty.
+	for {
+		if _, _, err := s.Next(); err == ErrEmpty {
+			break
+		} else if err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	for _, q := range queues {
+		if q.Length() != 0 {
+			t.Errorf("Expected queue length of 0, got %d", q.Length())
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package goque
+
+import (
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// QueueSnapshot is a frozen, point-in-time view of a queue. Items added
+// to or removed from the queue after the snapshot was taken do not
+// change its contents, so it can be iterated for a backup or analysis
+// without holding the lock of the queue.
+//
+// A snapshot holds on to the state of the underlying database, keeping
+// removed items from being compacted away, so it must be released
+// using Release once it is no longer needed.
+type QueueSnapshot struct {
+	q     *Queue
+	snap  *leveldb.Snapshot
+	head  uint64
+	tail  uint64
+	count uint64
+}
+
+// Snapshot returns a snapshot of the current state of the queue. The
+// queue lock is only held while the snapshot is taken, so Enqueue and
+// Dequeue continue on the queue while the snapshot is being used.
+func (q *Queue) Snapshot() (*QueueSnapshot, error) {
+	q.rlock()
+	defer q.runlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	snap, err := q.db.GetSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("goque: get snapshot: %w", err)
+	}
+
+	return &QueueSnapshot{
+		q:     q,
+		snap:  snap,
+		head:  q.head,
+		tail:  q.tail,
+		count: q.count,
+	}, nil
+}
+
+// Length returns the number of items in the queue at the time the
+// snapshot was taken.
+func (s *QueueSnapshot) Length() uint64 {
+	return s.count
+}
+
+// ForEach calls fn for each item in the snapshot, in order from head to
+// tail, until fn returns an error, which ForEach then returns. Once the
+// queue has been closed, the snapshot can no longer be iterated.
+func (s *QueueSnapshot) ForEach(fn func(*Item) error) error {
+	// Iterate over the items from the head.
+	iter := s.snap.NewIterator(s.q.rangeFrom(s.head+1), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		item := s.q.newItemFromIterator(iter)
+		if item.ID > s.tail {
+			break
+		}
+		if err := checkItem(item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	return nil
+}
+
+// Release releases the snapshot. It must be called once the snapshot is
+// no longer needed, and the snapshot must not be used afterwards.
+func (s *QueueSnapshot) Release() {
+	s.snap.Release()
+}
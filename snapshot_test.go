@@ -0,0 +1,78 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueSnapshot(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	snap, err := q.Snapshot()
+	if err != nil {
+		t.Error(err)
+	}
+	defer snap.Release()
+
+	// Change the queue after taking the snapshot.
+	if _, err = q.Dequeue(); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.EnqueueString("value for item 6"); err != nil {
+		t.Error(err)
+	}
+
+	if snap.Length() != 4 {
+		t.Errorf("Expected snapshot length of 4, got %d", snap.Length())
+	}
+
+	// The snapshot still holds items 2 to 5.
+	i := 2
+	err = snap.ForEach(func(item *Item) error {
+		compStr := fmt.Sprintf("value for item %d", i)
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+		if item.ID != uint64(i) {
+			t.Errorf("Expected item ID to be %d, got %d", i, item.ID)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if i != 6 {
+		t.Errorf("Expected to iterate over 4 items, got %d", i-2)
+	}
+}
+
+func TestQueueSnapshotClosed(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	q.Close()
+
+	if _, err = q.Snapshot(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
@@ -230,7 +230,10 @@ func (s *Stack) UpdateObject(id uint64, newValue interface{}) (*Item, error) {
 
 // Length returns the total number of items in the stack.
 func (s *Stack) Length() uint64 {
-	return s.head - s.tail
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.length()
 }
 
 // Close closes the LevelDB database of the stack.
@@ -243,18 +246,14 @@ func (s *Stack) Close() error {
 		return nil
 	}
 
-	// Close the LevelDB database.
-	if err := s.db.Close(); err != nil {
-		return err
-	}
-
 	// Reset stack head and tail and set
 	// isOpen to false.
 	s.head = 0
 	s.tail = 0
 	s.isOpen = false
 
-	return nil
+	// Close the LevelDB database.
+	return s.db.Close()
 }
 
 // Drop closes and deletes the LevelDB database of the stack.
@@ -266,10 +265,16 @@ func (s *Stack) Drop() error {
 	return os.RemoveAll(s.DataDir)
 }
 
+// length returns the total number of items in the stack. The caller
+// must hold the lock.
+func (s *Stack) length() uint64 {
+	return s.head - s.tail
+}
+
 // getItemByID returns an item, if found, for the given ID.
 func (s *Stack) getItemByID(id uint64) (*Item, error) {
 	// Check if empty or out of bounds.
-	if s.Length() == 0 {
+	if s.length() == 0 {
 		return nil, ErrEmpty
 	} else if id <= s.tail || id > s.head {
 		return nil, ErrOutOfBounds
@@ -119,6 +119,65 @@ func TestStackPop(t *testing.T) {
 	}
 }
 
+func TestStackPushPopReopen(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	s, err := OpenStack(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer s.Drop()
+
+	// Push 1 through 5, popping every second item.
+	var want []string
+	for i := 1; i <= 5; i++ {
+		value := fmt.Sprintf("value for item %d", i)
+		if _, err = s.PushString(value); err != nil {
+			t.Error(err)
+		}
+		want = append(want, value)
+
+		if i%2 == 0 {
+			popItem, err := s.Pop()
+			if err != nil {
+				t.Error(err)
+			}
+
+			if popItem.ToString() != value {
+				t.Errorf("Expected string to be '%s', got '%s'", value, popItem.ToString())
+			}
+			want = want[:len(want)-1]
+		}
+	}
+
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if s, err = OpenStack(file); err != nil {
+		t.Error(err)
+	}
+
+	if s.Length() != uint64(len(want)) {
+		t.Errorf("Expected stack length of %d, got %d", len(want), s.Length())
+	}
+
+	// The remaining items should pop in reverse order.
+	for i := len(want) - 1; i >= 0; i-- {
+		popItem, err := s.Pop()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if popItem.ToString() != want[i] {
+			t.Errorf("Expected string to be '%s', got '%s'", want[i], popItem.ToString())
+		}
+	}
+
+	if _, err = s.Pop(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+}
+
 func TestStackPeek(t *testing.T) {
 	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
 	s, err := OpenStack(file)
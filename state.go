@@ -0,0 +1,235 @@
+package goque
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// stateKind follows the metadata prefix of the queue in the key holding
+// the stored state of the queue.
+const stateKind = 's'
+
+// queueState is the head, tail, item count and size of a queue.
+//
+// The state is stored in the same batch as each change to the items of
+// the queue, so a queue can be opened without counting its items. It is
+// stored as four big-endian 8 byte integers, in the order of the fields.
+//
+// The stored head may trail the actual head, as items removed from
+// within the queue leave gaps that are only skipped in memory, so it is
+// set from the first item when the queue is opened. The tail may be
+// past the last item once the item at the tail is removed, and is kept
+// so that the IDs of removed items are not reused.
+type queueState struct {
+	head  uint64
+	tail  uint64
+	count uint64
+	size  uint64
+}
+
+// state returns the current state of the queue. The caller must hold
+// the write lock, or the read lock and the lock of the tail.
+func (q *Queue) state() queueState {
+	return queueState{head: q.head, tail: q.tail, count: q.count, size: q.size}
+}
+
+// add returns the state after adding the given item to the tail, or in
+// front of the head for a queue using priorities.
+func (s queueState) add(item *Item) queueState {
+	if s.count == 0 || item.ID <= s.head {
+		s.head = item.ID - 1
+	}
+	if item.ID > s.tail {
+		s.tail = item.ID
+	}
+	s.count++
+	s.size += uint64(len(item.Value))
+	return s
+}
+
+// addItems returns the state after adding n items with the given total
+// size to the tail.
+func (s queueState) addItems(n, size uint64) queueState {
+	s.tail += n
+	s.count += n
+	s.size += size
+	return s
+}
+
+// remove returns the state after removing the given item, moving the
+// head past it if the item is at the head.
+func (s queueState) remove(item *Item) queueState {
+	if item.ID == s.head+1 {
+		s.head = item.ID
+	}
+	return s.removeItems(1, uint64(len(item.Value)))
+}
+
+// removeAll returns the state after removing the given items.
+func (s queueState) removeAll(items []*Item) queueState {
+	for _, item := range items {
+		s = s.remove(item)
+	}
+	return s
+}
+
+// removeHead returns the state after removing n items with the given
+// total size from the head, up to the item with the given ID.
+func (s queueState) removeHead(last, n, size uint64) queueState {
+	s.head = last
+	return s.removeItems(n, size)
+}
+
+// removeItems returns the state after removing n items with the given
+// total size from anywhere in the queue. The head only moves once the
+// queue is empty.
+func (s queueState) removeItems(n, size uint64) queueState {
+	s.count -= n
+	s.size -= size
+	if s.count == 0 {
+		s.head = s.tail
+	}
+	return s
+}
+
+// stateKey returns the key of the stored state of the queue.
+func (q *Queue) stateKey() []byte {
+	return q.keys.state
+}
+
+// putState adds storing the given state of the queue to the batch, if
+// the queue stores its state.
+func (q *Queue) putState(batch *leveldb.Batch, s queueState) {
+	if !q.storedState {
+		return
+	}
+	var data [32]byte
+	binary.BigEndian.PutUint64(data[0:], s.head)
+	binary.BigEndian.PutUint64(data[8:], s.tail)
+	binary.BigEndian.PutUint64(data[16:], s.count)
+	binary.BigEndian.PutUint64(data[24:], s.size)
+	batch.Put(q.stateKey(), data[:])
+}
+
+// decodeState returns the state stored as the given value, or false if
+// the value does not hold a state.
+func decodeState(data []byte) (queueState, bool) {
+	if len(data) != 32 {
+		return queueState{}, false
+	}
+	return queueState{
+		head:  binary.BigEndian.Uint64(data[0:]),
+		tail:  binary.BigEndian.Uint64(data[8:]),
+		count: binary.BigEndian.Uint64(data[16:]),
+		size:  binary.BigEndian.Uint64(data[24:]),
+	}, true
+}
+
+// writeState stores the current state of the queue on its own, if the
+// queue stores its state. The caller must hold the write lock.
+func (q *Queue) writeState() error {
+	if !q.storedState {
+		return nil
+	}
+	batch := new(leveldb.Batch)
+	q.putState(batch, q.state())
+	if err := q.db.Write(batch, q.writeOptions); err != nil {
+		return fmt.Errorf("goque: write batch: %w", err)
+	}
+	return nil
+}
+
+// deleteState deletes the stored state of a queue that does not store
+// its state, such as one stored while it was last opened using
+// WithStoredState, since the state would not be kept up to date.
+func (q *Queue) deleteState() error {
+	ok, err := q.db.Has(q.stateKey(), nil)
+	if err != nil {
+		return fmt.Errorf("goque: get state: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	if err := q.db.Delete(q.stateKey(), q.writeOptions); err != nil {
+		return fmt.Errorf("goque: delete state: %w", err)
+	}
+	return nil
+}
+
+// loadState sets the head, tail, item count and size of the queue from
+// its stored state, returning false if there is none. A stored state
+// that does not fit the items of the queue, such as one left behind by
+// an older version of Goque writing to the queue, is not used either.
+func (q *Queue) loadState() (bool, error) {
+	data, err := q.db.Get(q.stateKey(), nil)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("goque: get state: %w", err)
+	}
+	s, ok := decodeState(data)
+	if !ok {
+		return false, nil
+	}
+
+	// Check the state against the first and last item, setting the
+	// head from the first item. The tail is kept.
+	iter := q.db.NewIterator(q.rangeFrom(1), nil)
+	defer iter.Release()
+
+	if iter.First() {
+		head := q.keyID(iter.Key()) - 1
+		iter.Last()
+		tail := q.keyID(iter.Key())
+		if s.count == 0 || head < s.head || tail > s.tail || s.count > tail-head {
+			return false, nil
+		}
+		s.head = head
+	} else if s.count != 0 {
+		return false, nil
+	} else {
+		s.head = s.tail
+	}
+	if err := iter.Error(); err != nil {
+		return false, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	q.head, q.tail, q.count, q.size = s.head, s.tail, s.count, s.size
+	return true, nil
+}
+
+// countItems sets the head, tail, item count and size of the queue by
+// reading all of its items.
+func (q *Queue) countItems() error {
+	// Create a new LevelDB Iterator over the items, leaving out the
+	// metadata keys in front of them.
+	iter := q.db.NewIterator(q.rangeFrom(1), nil)
+	defer iter.Release()
+
+	// Set queue head to the first item.
+	if iter.First() {
+		q.head = q.keyID(iter.Key()) - 1
+	}
+
+	// Set queue tail to the last item.
+	if iter.Last() {
+		q.tail = q.keyID(iter.Key())
+	}
+
+	// Count the items, as there may be gaps between head and tail,
+	// and sum up their size.
+	q.count = 0
+	q.size = 0
+	for ok := iter.First(); ok; ok = iter.Next() {
+		_, value := decodeValue(iter.Value())
+		q.count++
+		q.size += uint64(len(value))
+	}
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// checkState reports an error if the stored state of the queue does not
+// match its current state. The stored head may trail the head, and the
+// stored tail may be past the tail.
+func checkState(t *testing.T, q *Queue, op string) {
+	data, err := q.db.Get(q.stateKey(), nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, ok := decodeState(data)
+	if !ok {
+		t.Errorf("Expected stored state after %s, got %x", op, data)
+		return
+	}
+
+	if s.count != q.count || s.size != q.size || s.head > q.head || s.tail < q.tail {
+		t.Errorf("Expected stored state after %s to match %+v, got %+v", op, q.state(), s)
+	}
+}
+
+func TestQueueStoredState(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithStoredState())
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for _, op := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"Enqueue", func() error {
+			for i := 1; i <= 10; i++ {
+				if _, err := q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"EnqueueBatch", func() error {
+			_, err := q.EnqueueBatch([][]byte{[]byte("value for item 11"), []byte("value for item 12")})
+			return err
+		}},
+		{"Dequeue", func() error {
+			_, err := q.Dequeue()
+			return err
+		}},
+		{"DequeueByID", func() error {
+			_, err := q.DequeueByID(5)
+			return err
+		}},
+		{"Update", func() error {
+			_, err := q.UpdateString(6, "longer value for item 6")
+			return err
+		}},
+		{"UpdateBatch", func() error {
+			return q.UpdateBatch(map[uint64][]byte{7: []byte("7"), 8: []byte("8")})
+		}},
+		{"RequeueHead", func() error {
+			_, err := q.RequeueHead()
+			return err
+		}},
+		{"DequeueBatch", func() error {
+			_, err := q.DequeueBatch(2)
+			return err
+		}},
+		{"Commit", func() error {
+			_, token, err := q.PeekN(2)
+			if err != nil {
+				return err
+			}
+			return q.Commit(token)
+		}},
+		{"Receive", func() error {
+			item, err := q.Receive(time.Minute)
+			if err != nil {
+				return err
+			}
+			return q.Nack(item)
+		}},
+		{"Discard", func() error {
+			_, err := q.Discard(1)
+			return err
+		}},
+		{"Txn", func() error {
+			txn := q.Begin()
+			defer txn.Rollback()
+			if _, err := txn.Dequeue(); err != nil {
+				return err
+			}
+			if _, err := txn.Enqueue([]byte("value for item 14")); err != nil {
+				return err
+			}
+			return txn.Commit()
+		}},
+		{"Compact", q.Compact},
+		{"Trim", func() error {
+			_, err := q.Trim(2)
+			return err
+		}},
+	} {
+		if err = op.fn(); err != nil {
+			t.Errorf("%s: %v", op.name, err)
+		}
+		checkState(t, q, op.name)
+	}
+
+	// The queue opens with the same state.
+	stats, size := q.Stats(), q.SizeBytes()
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Stats() != stats {
+		t.Errorf("Expected stats of %+v after reopening, got %+v", stats, q.Stats())
+	}
+
+	if q.SizeBytes() != size {
+		t.Errorf("Expected size of %d bytes after reopening, got %d", size, q.SizeBytes())
+	}
+}
+
+func TestQueueOpenStoredState(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithStoredState())
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 5; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	for i := 1; i <= 5; i++ {
+		if _, err = q.Dequeue(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// An empty queue keeps its tail once reopened.
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	item, err := q.EnqueueString("value for item 6")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ID != 6 {
+		t.Errorf("Expected ID of 6 after reopening, got %d", item.ID)
+	}
+
+	// Without a stored state, as written by older versions, the items
+	// are counted and the state is stored.
+	if err = q.db.Delete(q.stateKey(), nil); err != nil {
+		t.Error(err)
+	}
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+	checkState(t, q, "Open")
+
+	// A stored state that does not fit the items is not used.
+	if err = q.db.Put(q.key(7), []byte("value for item 7"), nil); err != nil {
+		t.Error(err)
+	}
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 2 {
+		t.Errorf("Expected queue length of 2, got %d", q.Length())
+	}
+	checkState(t, q, "Open")
+
+	// Opening the queue without WithStoredState deletes the state, as
+	// it would no longer be kept up to date.
+	q.storedState = false
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if ok, err := q.db.Has(q.stateKey(), nil); err != nil {
+		t.Error(err)
+	} else if ok {
+		t.Error("Expected stored state to be deleted")
+	}
+
+	if q.Length() != 2 {
+		t.Errorf("Expected queue length of 2, got %d", q.Length())
+	}
+}
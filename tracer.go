@@ -0,0 +1,51 @@
+package goque
+
+import (
+	"context"
+)
+
+// Tracer starts a span for an operation on a queue, such as to pass it
+// on to OpenTelemetry. The operation is one of "enqueue", "dequeue" and
+// "peek", and ctx is the context passed to the method, if any, or else
+// context.Background(). It returns the function ending the span, which
+// is called with the ID of the item added, removed or read, or 0 if the
+// operation failed, and the error of the operation, if any. If it
+// returns nil, the operation is not traced.
+//
+// For OpenTelemetry, a Tracer can start a span using the tracer of the
+// application, recording the data directory as an attribute, and end
+// it recording the item ID, or the error along with an error status.
+type Tracer func(ctx context.Context, op, dataDir string) func(id uint64, err error)
+
+// SetTracer sets the tracer of the queue, replacing the one set using
+// WithTracer, if any. A nil tracer turns tracing off, after which the
+// traced methods only check that no tracer is set.
+//
+// Spans are started by Enqueue, Dequeue, DequeueCtx and Peek, and are
+// ended with any error these return, including errors that only report
+// the state of the queue, such as ErrEmpty, so the tracer can tell them
+// apart. Neither function is called while the queue lock is held.
+func (q *Queue) SetTracer(tracer Tracer) {
+	q.tracer.Store(tracer)
+}
+
+// startSpan starts a span for the given operation using the tracer of
+// the queue, returning the function ending it, or nil if the queue has
+// no tracer. It takes no lock.
+func (q *Queue) startSpan(ctx context.Context, op string) func(uint64, error) {
+	tracer, _ := q.tracer.Load().(Tracer)
+	if tracer == nil {
+		return nil
+	}
+	return tracer(ctx, op, q.DataDir)
+}
+
+// endSpan ends a span started using startSpan with the given result of
+// the operation.
+func endSpan(end func(uint64, error), item *Item, err error) {
+	var id uint64
+	if err == nil && item != nil {
+		id = item.ID
+	}
+	end(id, err)
+}
@@ -0,0 +1,62 @@
+package goque
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueTracer(t *testing.T) {
+	var mu sync.Mutex
+	var spans []string
+	tracer := func(ctx context.Context, op, dataDir string) func(uint64, error) {
+		return func(id uint64, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			spans = append(spans, fmt.Sprint(op, " ", dataDir, " ", id, " ", err))
+		}
+	}
+
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithTracer(tracer))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	if _, err = q.EnqueueString("value for item 1"); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.Peek(); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.DequeueCtx(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if _, err = q.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	// Once the tracer is removed, nothing is traced.
+	q.SetTracer(nil)
+	if _, err = q.EnqueueString("value for item 2"); err != nil {
+		t.Error(err)
+	}
+
+	expected := []string{
+		"enqueue " + file + " 1 <nil>",
+		"peek " + file + " 1 <nil>",
+		"dequeue " + file + " 1 <nil>",
+		"dequeue " + file + " 0 " + ErrEmpty.Error(),
+	}
+	if len(spans) != len(expected) {
+		t.Fatalf("Expected %d spans, got %q", len(expected), spans)
+	}
+	for i := range expected {
+		if spans[i] != expected[i] {
+			t.Errorf("Expected span to be '%s', got '%s'", expected[i], spans[i])
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package goque
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// TransferTo moves up to n items from the head of the queue to the
+// tail of dst, keeping their order, and returns the number of items
+// moved. The items are given new IDs in dst.
+//
+// The two queues use separate databases, so the move cannot be a
+// single atomic write. The items are first added to dst and only then
+// removed from the queue, giving at-least-once semantics: if the
+// process crashes or the removal fails part way through, the items
+// may end up in both queues, but are never lost.
+func (q *Queue) TransferTo(dst *Queue, n uint64) (uint64, error) {
+	if q == dst {
+		return 0, ErrSameQueue
+	}
+
+	unlock := lockPair(q, dst)
+	defer unlock()
+
+	// Check if either queue is closed.
+	if !q.isOpen || !dst.isOpen {
+		return 0, ErrDBClosed
+	}
+
+	// Check if either queue is read-only, or the destination is
+	// closing.
+	if q.readOnly || dst.readOnly {
+		return 0, ErrReadOnly
+	} else if dst.closing {
+		return 0, ErrClosing
+	}
+
+	// Check if the destination uses priorities.
+	if dst.priorities {
+		return 0, ErrPriorities
+	}
+
+	// Limit to the number of items available.
+	if n > q.length() {
+		n = q.length()
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	// Check if the destination has room for the items.
+	if dst.isFull(n) {
+		return 0, ErrFull
+	}
+	if math.MaxUint64-dst.tail < n {
+		return 0, ErrIDExhausted
+	}
+
+	// Add the items to batches for both queues.
+	var moved, last, size uint64
+	srcBatch := new(leveldb.Batch)
+	dstBatch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	for moved < n && iter.Next() {
+		item := q.newItemFromIterator(iter)
+		moved++
+		dstBatch.Put(dst.key(dst.tail+moved), iter.Value())
+		srcBatch.Delete(item.Key)
+		last = item.ID
+		size += uint64(len(item.Value))
+
+		// Move the entries in the index of unique items as well.
+		if item.unique {
+			dstBatch.Put(dst.uniqueKey(item.Value), nil)
+			q.unindex(srcBatch, item)
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// Add the items to the destination first.
+	dst.putState(dstBatch, dst.state().addItems(moved, size))
+	if err := dst.db.Write(dstBatch, dst.writeOptions); err != nil {
+		return 0, fmt.Errorf("goque: write batch: %w", err)
+	}
+	dst.tail += moved
+	dst.count += moved
+	dst.size += size
+	dst.broadcast()
+
+	// Then remove them from the source.
+	q.putState(srcBatch, q.state().removeHead(last, moved, size))
+	if err := q.db.Write(srcBatch, q.writeOptions); err != nil {
+		return 0, fmt.Errorf("goque: write batch: %w", err)
+	}
+	q.head = last
+	q.count -= moved
+	q.size -= size
+
+	return moved, q.skipGaps()
+}
+
+// Concat moves all items of src to the tail of the queue, keeping their
+// order, and returns the number of items moved, leaving src empty. The
+// items are given new IDs in the queue.
+//
+// Concat is the same as src.TransferTo(q, src.Length()), including its
+// at-least-once semantics: as the two queues use separate databases, a
+// crash part way through may leave the items in both queues. Both
+// queues are locked in a consistent order, so concatenating two queues
+// in opposite directions at the same time cannot deadlock.
+func (q *Queue) Concat(src *Queue) (uint64, error) {
+	return src.TransferTo(q, math.MaxUint64)
+}
+
+// SplitAt moves the items from the given offset from the head of the
+// queue onward into a new queue created at dstDir, keeping their IDs
+// and order, leaving the first offset items in the queue. It returns
+// the new queue, which is open. If dstDir already exists, ErrExists is
+// returned, and if offset is past the tail of the queue, ErrOutOfBounds.
+//
+// The two queues use separate databases, so the split cannot be a
+// single atomic write. The items are first added to the new queue in
+// one batch and only then removed from the queue in another, giving
+// at-least-once semantics as for TransferTo: if the process crashes in
+// between, the items end up in both queues, but are never lost. If
+// adding the items to the new queue fails, it is removed again.
+func (q *Queue) SplitAt(offset uint64, dstDir string) (*Queue, error) {
+	// Check if the destination already exists.
+	if _, err := os.Stat(dstDir); err == nil {
+		return nil, ErrExists
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	// Check if queue is closed.
+	if !q.isOpen {
+		return nil, ErrDBClosed
+	}
+
+	// Check if queue is read-only or closing.
+	if q.readOnly {
+		return nil, ErrReadOnly
+	} else if q.closing {
+		return nil, ErrClosing
+	}
+
+	// Check if the offset is within the queue.
+	if offset > q.length() {
+		return nil, ErrOutOfBounds
+	}
+
+	dst, err := OpenQueue(dstDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the items from the offset to batches for both queues.
+	var moved, first, last, size uint64
+	srcBatch := new(leveldb.Batch)
+	dstBatch := new(leveldb.Batch)
+	iter := q.db.NewIterator(q.itemRange(), nil)
+	for ok := q.seekOffset(iter, offset); ok; ok = iter.Next() {
+		item := q.newItemFromIterator(iter)
+		if item.ID > q.tail {
+			break
+		}
+		if first == 0 {
+			first = item.ID
+		}
+		moved++
+		dstBatch.Put(dst.key(item.ID), iter.Value())
+		srcBatch.Delete(item.Key)
+		last = item.ID
+		size += uint64(len(item.Value))
+
+		// Move the entries in the index of unique items as well.
+		if item.unique {
+			dstBatch.Put(dst.uniqueKey(item.Value), nil)
+			q.unindex(srcBatch, item)
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		q.dropSplit(dst)
+		return nil, fmt.Errorf("goque: iterate items: %w", err)
+	}
+	if moved == 0 {
+		return dst, nil
+	}
+
+	// Add the items to the new queue first.
+	dst.putState(dstBatch, queueState{head: first - 1, tail: last, count: moved, size: size})
+	if err := dst.db.Write(dstBatch, dst.writeOptions); err != nil {
+		q.dropSplit(dst)
+		return nil, fmt.Errorf("goque: write batch: %w", err)
+	}
+	dst.Lock()
+	dst.head, dst.tail, dst.count, dst.size = first-1, last, moved, size
+	dst.Unlock()
+
+	// Then remove them from the queue.
+	q.putState(srcBatch, q.state().removeItems(moved, size))
+	if err := q.db.Write(srcBatch, q.writeOptions); err != nil {
+		return dst, fmt.Errorf("goque: write batch: %w", err)
+	}
+	q.count -= moved
+	q.size -= size
+	return dst, q.fixBounds(q.head)
+}
+
+// dropSplit drops the new queue of a failed SplitAt, logging any error.
+// The caller must hold the lock.
+func (q *Queue) dropSplit(dst *Queue) {
+	if err := dst.Drop(); err != nil {
+		q.log(LogError, "drop split", "dataDir", dst.DataDir, "error", err)
+	}
+}
+
+// lockPair write locks both of the given queues in a consistent order,
+// so that two goroutines locking the same pair of queues in opposite
+// roles cannot deadlock. It returns a function unlocking both queues.
+func lockPair(a, b *Queue) func() {
+	if a.lockOrder > b.lockOrder {
+		a, b = b, a
+	}
+	a.Lock()
+	b.Lock()
+	return func() {
+		b.Unlock()
+		a.Unlock()
+	}
+}
+
+// rlockPair read locks both of the given queues using rlock, in the
+// same order as lockPair. It returns a function unlocking both queues.
+func rlockPair(a, b *Queue) func() {
+	if a.lockOrder > b.lockOrder {
+		a, b = b, a
+	}
+	a.rlock()
+	b.rlock()
+	return func() {
+		b.runlock()
+		a.runlock()
+	}
+}
@@ -0,0 +1,267 @@
+package goque
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueTransferTo(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	src, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer src.Drop()
+
+	file2 := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	dst, err := OpenQueue(file2)
+	if err != nil {
+		t.Error(err)
+	}
+	defer dst.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = src.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = dst.EnqueueString("existing item"); err != nil {
+		t.Error(err)
+	}
+
+	moved, err := src.TransferTo(dst, 4)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if moved != 4 {
+		t.Errorf("Expected 4 items to be moved, got %d", moved)
+	}
+
+	if src.Length() != 6 {
+		t.Errorf("Expected source queue length of 6, got %d", src.Length())
+	}
+
+	if dst.Length() != 5 {
+		t.Errorf("Expected destination queue length of 5, got %d", dst.Length())
+	}
+
+	if _, err = dst.Dequeue(); err != nil {
+		t.Error(err)
+	}
+
+	for i := 1; i <= 4; i++ {
+		deqItem, err := dst.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+
+	// Asking for more than available moves the rest.
+	if moved, err = src.TransferTo(dst, 100); err != nil {
+		t.Error(err)
+	}
+
+	if moved != 6 {
+		t.Errorf("Expected 6 items to be moved, got %d", moved)
+	}
+
+	if src.Length() != 0 {
+		t.Errorf("Expected source queue length of 0, got %d", src.Length())
+	}
+
+	if _, err = src.TransferTo(src, 1); err != ErrSameQueue {
+		t.Errorf("Expected to get same queue error, got %v", err)
+	}
+}
+
+func TestQueueTransferToOppositeDirections(t *testing.T) {
+	a, err := OpenMemQueue()
+	if err != nil {
+		t.Error(err)
+	}
+	defer a.Drop()
+
+	b, err := OpenMemQueue()
+	if err != nil {
+		t.Error(err)
+	}
+	defer b.Drop()
+
+	for i := 1; i <= 100; i++ {
+		if _, err = a.EnqueueString("value"); err != nil {
+			t.Error(err)
+		}
+		if _, err = b.EnqueueString("value"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if _, err := a.TransferTo(b, 1); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if _, err := b.TransferTo(a, 1); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if total := a.Length() + b.Length(); total != 200 {
+		t.Errorf("Expected 200 items in total, got %d", total)
+	}
+}
+
+func TestQueueConcat(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	file2 := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	src, err := OpenQueue(file2)
+	if err != nil {
+		t.Error(err)
+	}
+	defer src.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+		if _, err = src.EnqueueString(fmt.Sprintf("value for item %d", i+10)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	moved, err := q.Concat(src)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if moved != 10 {
+		t.Errorf("Expected 10 items to be moved, got %d", moved)
+	}
+
+	if src.Length() != 0 {
+		t.Errorf("Expected source queue length of 0, got %d", src.Length())
+	}
+
+	for i := 1; i <= 20; i++ {
+		deqItem, err := q.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if deqItem.ID != uint64(i) {
+			t.Errorf("Expected item ID to be %d, got %d", i, deqItem.ID)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+		if deqItem.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+		}
+	}
+
+	// Concatenating an empty queue moves nothing.
+	if moved, err = q.Concat(src); err != nil || moved != 0 {
+		t.Errorf("Expected 0 items to be moved, got %d and %v", moved, err)
+	}
+
+	// Concatenating a queue onto itself fails.
+	if _, err = q.Concat(q); err != ErrSameQueue {
+		t.Errorf("Expected to get same queue error, got %v", err)
+	}
+}
+
+func TestQueueSplitAt(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	dstFile := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	dst, err := q.SplitAt(4, dstFile)
+	if err != nil {
+		t.Error(err)
+	}
+	defer dst.Drop()
+
+	if q.Length() != 4 {
+		t.Errorf("Expected queue length of 4, got %d", q.Length())
+	}
+
+	if dst.Length() != 6 {
+		t.Errorf("Expected queue length of 6, got %d", dst.Length())
+	}
+
+	for i := 1; i <= 10; i++ {
+		from := q
+		if i > 4 {
+			from = dst
+		}
+
+		item, err := from.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	if q.Length() != 0 || dst.Length() != 0 {
+		t.Errorf("Expected queue lengths of 0, got %d and %d", q.Length(), dst.Length())
+	}
+
+	// The queue continues after its old tail, so the IDs of the moved
+	// items are not reused.
+	item, err := q.EnqueueString("value for item 11")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if item.ID != 11 {
+		t.Errorf("Expected item ID to be 11, got %d", item.ID)
+	}
+
+	if _, err = q.SplitAt(0, dstFile); err != ErrExists {
+		t.Errorf("Expected to get exists error, got %v", err)
+	}
+
+	if _, err = q.SplitAt(2, fmt.Sprintf("test_db_%d", time.Now().UnixNano())); err != ErrOutOfBounds {
+		t.Errorf("Expected to get queue out of bounds error, got %v", err)
+	}
+}
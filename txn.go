@@ -0,0 +1,293 @@
+package goque
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Txn is a transaction grouping several Enqueue and Dequeue operations
+// on a queue, which are then applied using a single atomic write by
+// Commit, or discarded by Rollback. The transaction sees its own
+// changes, so an item enqueued within it can be dequeued within it.
+//
+// A transaction holds the write lock of the queue from Begin until it
+// is committed or rolled back, so it is fully isolated: no other
+// operation on the queue runs in the meantime, and other goroutines
+// using the queue block until the transaction is done. A transaction
+// is not safe for concurrent use.
+type Txn struct {
+	q     *Queue
+	batch *leveldb.Batch
+	err   error
+	done  bool
+
+	// head, tail, count and size are the state of the queue with the
+	// changes of the transaction applied.
+	head, tail, count, size uint64
+
+	// origTail is the tail of the queue when the transaction began.
+	// The items after it were enqueued within the transaction and
+	// are held in pending.
+	origTail uint64
+	pending  []*Item
+
+	// enqueued and dequeued are the items passed to the hooks of the
+	// queue once the transaction is committed.
+	enqueued []*Item
+	dequeued []*Item
+}
+
+// Begin starts a transaction on the queue, taking its write lock until
+// the transaction is committed or rolled back.
+//
+// Items of a queue limited using WithMaxLength are rejected with
+// ErrFull once it is full, whatever its overflow policy, and with
+// ErrClosing while the queue is shut down using Shutdown. In-flight
+// items that are due are not put back into the queue within a
+// transaction.
+func (q *Queue) Begin() *Txn {
+	q.Lock()
+
+	t := &Txn{q: q, batch: new(leveldb.Batch)}
+
+	// Check if queue is closed or read-only.
+	if !q.isOpen {
+		t.err = ErrDBClosed
+		return t
+	} else if q.readOnly {
+		t.err = ErrReadOnly
+		return t
+	} else if q.priorities {
+		t.err = ErrPriorities
+		return t
+	}
+
+	t.head, t.tail, t.count, t.size = q.head, q.tail, q.count, q.size
+	t.origTail = q.tail
+	return t
+}
+
+// Enqueue adds an item to the queue once the transaction is committed.
+func (t *Txn) Enqueue(value []byte) (*Item, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	q := t.q
+
+	// Check if queue is closing.
+	if q.closing {
+		return nil, ErrClosing
+	}
+
+	// Check the size of the value, and if there is room for the item
+	// and an ID left for it.
+	if err := q.checkValueSize(value); err != nil {
+		return nil, err
+	}
+	if q.maxLength > 0 && t.count >= q.maxLength {
+		return nil, ErrFull
+	}
+	if t.tail == math.MaxUint64 {
+		return nil, ErrIDExhausted
+	}
+
+	// Create new Item.
+	var h itemHeader
+	if q.enqueueTime {
+		h.enqueuedAt = time.Now()
+	}
+	item := &Item{
+		ID:         t.tail + 1,
+		Key:        q.key(t.tail + 1),
+		Value:      value,
+		EnqueuedAt: h.enqueuedAt,
+		codec:      q.codec,
+	}
+
+	// Add it to the batch.
+	t.batch.Put(item.Key, q.encodeValue(h, value))
+	t.tail++
+	t.count++
+	t.size += uint64(len(value))
+	t.pending = append(t.pending, item)
+	t.enqueued = append(t.enqueued, item)
+
+	return item, nil
+}
+
+// Dequeue removes the next item in the queue, including the items
+// enqueued within the transaction, once the transaction is committed,
+// and returns it. Expired items in front of it are removed as well. If
+// the next item is not ready yet, ErrEmpty is returned.
+func (t *Txn) Dequeue() (*Item, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	item, err := t.next()
+	if err != nil {
+		return nil, err
+	}
+	t.remove(item)
+	t.dequeued = append(t.dequeued, item)
+
+	return item, nil
+}
+
+// Peek returns the next item in the queue as seen by the transaction
+// without removing it. Expired items in front of it are removed once
+// the transaction is committed. If the next item is not ready yet,
+// ErrEmpty is returned.
+func (t *Txn) Peek() (*Item, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	return t.next()
+}
+
+// Length returns the number of items in the queue as seen by the
+// transaction.
+func (t *Txn) Length() uint64 {
+	return t.count
+}
+
+// Commit applies the changes of the transaction to the queue using a
+// single atomic write and releases the lock of the queue. If the write
+// fails, none of the changes are applied.
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	q := t.q
+
+	err := t.commit()
+	q.Unlock()
+	if err != nil {
+		return q.logError("commit transaction", err)
+	}
+
+	q.enqueued(t.enqueued...)
+	q.dequeued(t.dequeued...)
+	return nil
+}
+
+// commit writes the batch of the transaction and updates the state of
+// the queue. The caller must hold the write lock.
+func (t *Txn) commit() error {
+	if t.err != nil {
+		return t.err
+	}
+	q := t.q
+
+	// Nothing to write.
+	if t.batch.Len() == 0 {
+		return nil
+	}
+	q.putState(t.batch, queueState{head: t.head, tail: t.tail, count: t.count, size: t.size})
+
+	// Archive the dequeued items in the same write.
+	var archived uint64
+	for _, item := range t.dequeued {
+		archived = q.archiveStored(t.batch, archived, item)
+	}
+
+	if err := q.retry(func() error { return q.db.Write(t.batch, q.writeOptions) }); err != nil {
+		return fmt.Errorf("goque: write batch: %w", err)
+	}
+	q.archiveTail += archived
+
+	// Move the head and tail past the removed and added items.
+	q.head, q.tail, q.count, q.size = t.head, t.tail, t.count, t.size
+	if err := q.skipGaps(); err != nil {
+		return err
+	}
+
+	// Wake any goroutines waiting for an item.
+	if len(t.enqueued) > 0 {
+		q.broadcast()
+	}
+
+	return nil
+}
+
+// Rollback discards the changes of the transaction and releases the
+// lock of the queue. Calling Rollback after Commit does nothing, so it
+// can be deferred right after Begin.
+func (t *Txn) Rollback() {
+	if t.done {
+		return
+	}
+	t.done = true
+	t.q.Unlock()
+}
+
+// check returns an error if the transaction cannot be used.
+func (t *Txn) check() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	return t.err
+}
+
+// next returns the next item in the queue as seen by the transaction,
+// adding the removal of any expired items in front of it to the batch.
+func (t *Txn) next() (*Item, error) {
+	now := time.Now()
+	for t.count > 0 {
+		item, err := t.headItem()
+		if err != nil {
+			return nil, err
+		}
+		if item.isExpired(now) {
+			t.remove(item)
+			continue
+		}
+		if !item.isReady(now) {
+			return nil, ErrEmpty
+		}
+		return item, nil
+	}
+	return nil, ErrEmpty
+}
+
+// headItem returns the first item after the head of the transaction,
+// skipping any gaps.
+func (t *Txn) headItem() (*Item, error) {
+	if t.head >= t.origTail {
+		return t.pending[t.head-t.origTail], nil
+	}
+
+	q := t.q
+	iter := q.db.NewIterator(q.rangeFrom(t.head+1), nil)
+	defer iter.Release()
+
+	if iter.First() && q.keyID(iter.Key()) <= t.origTail {
+		item := q.newItemFromIterator(iter)
+		if err := checkItem(item); err != nil {
+			return nil, err
+		}
+		return item, nil
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("goque: iterate items: %w", err)
+	}
+
+	// The remaining items were enqueued within the transaction.
+	t.head = t.origTail
+	return t.pending[0], nil
+}
+
+// remove adds the removal of the given item at the head of the
+// transaction to the batch.
+func (t *Txn) remove(item *Item) {
+	t.batch.Delete(item.Key)
+	t.q.unindex(t.batch, item)
+	t.head = item.ID
+	t.count--
+	t.size -= uint64(len(item.Value))
+}
@@ -0,0 +1,205 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTxnCommit(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err = q.DequeueByID(2); err != nil {
+		t.Error(err)
+	}
+
+	txn := q.Begin()
+	defer txn.Rollback()
+
+	for i := 4; i <= 5; i++ {
+		if _, err = txn.Enqueue([]byte(fmt.Sprintf("value for item %d", i))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The transaction sees its own changes, including across gaps.
+	for _, i := range []int{1, 3, 4} {
+		item, err := txn.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compStr := fmt.Sprintf("value for item %d", i)
+
+		if item.ToString() != compStr {
+			t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+		}
+	}
+
+	if txn.Length() != 1 {
+		t.Errorf("Expected transaction length of 1, got %d", txn.Length())
+	}
+
+	if err = txn.Commit(); err != nil {
+		t.Error(err)
+	}
+
+	if err = txn.Commit(); err != ErrTxnDone {
+		t.Errorf("Expected to get transaction done error, got %v", err)
+	}
+
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 5"
+
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+	}
+
+	if q.SizeBytes() != 0 {
+		t.Errorf("Expected size of 0 bytes, got %d", q.SizeBytes())
+	}
+
+	// Committed items survive reopening the queue.
+	txn = q.Begin()
+	if _, err = txn.Enqueue([]byte("value for item 6")); err != nil {
+		t.Error(err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Error(err)
+	}
+
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = q.Open(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 1 {
+		t.Errorf("Expected queue length of 1, got %d", q.Length())
+	}
+}
+
+func TestTxnRollback(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 3; i++ {
+		if _, err = q.EnqueueString(fmt.Sprintf("value for item %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	txn := q.Begin()
+	if _, err = txn.Dequeue(); err != nil {
+		t.Error(err)
+	}
+	if _, err = txn.Enqueue([]byte("value for item 4")); err != nil {
+		t.Error(err)
+	}
+	txn.Rollback()
+
+	if _, err = txn.Dequeue(); err != ErrTxnDone {
+		t.Errorf("Expected to get transaction done error, got %v", err)
+	}
+
+	if q.Length() != 3 {
+		t.Errorf("Expected queue length of 3, got %d", q.Length())
+	}
+
+	item, err := q.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+
+	if item.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	// The next item gets the ID the rolled back item would have had.
+	if item, err = q.EnqueueString("value for item 4"); err != nil {
+		t.Error(err)
+	}
+
+	if item.ID != 4 {
+		t.Errorf("Expected item ID to be 4, got %d", item.ID)
+	}
+}
+
+func TestTxnLimits(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file, WithMaxLength(2))
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	txn := q.Begin()
+	defer txn.Rollback()
+
+	if _, err = txn.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if _, err = txn.Enqueue([]byte(fmt.Sprintf("value for item %d", i))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = txn.Enqueue([]byte("value for item 3")); err != ErrFull {
+		t.Errorf("Expected to get queue full error, got %v", err)
+	}
+
+	// Dequeuing within the transaction makes room.
+	if _, err = txn.Dequeue(); err != nil {
+		t.Error(err)
+	}
+	if _, err = txn.Enqueue([]byte("value for item 3")); err != nil {
+		t.Error(err)
+	}
+
+	if err = txn.Commit(); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 2 {
+		t.Errorf("Expected queue length of 2, got %d", q.Length())
+	}
+
+	if err = q.Close(); err != nil {
+		t.Error(err)
+	}
+
+	txn = q.Begin()
+	if _, err = txn.Enqueue([]byte("value")); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+	if err = txn.Commit(); err != ErrDBClosed {
+		t.Errorf("Expected to get database closed error, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package goque
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec converts values of type T to and from the bytes stored in a
+// queue.
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(data []byte, v *T) error
+}
+
+// GobCodec is a Codec using encoding/gob. It is the default codec of
+// a TypedQueue.
+type GobCodec[T any] struct{}
+
+// Marshal encodes the given value using encoding/gob.
+func (GobCodec[T]) Marshal(v T) ([]byte, error) {
+	var buffer bytes.Buffer
+	enc := gob.NewEncoder(&buffer)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Unmarshal decodes the given data into v using encoding/gob.
+func (GobCodec[T]) Unmarshal(data []byte, v *T) error {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	return dec.Decode(v)
+}
+
+// JSONCodec is a Codec using encoding/json.
+type JSONCodec[T any] struct{}
+
+// Marshal encodes the given value using encoding/json.
+func (JSONCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the given data into v using encoding/json.
+func (JSONCodec[T]) Unmarshal(data []byte, v *T) error {
+	return json.Unmarshal(data, v)
+}
+
+// TypedQueue is a queue holding values of type T, which are converted
+// to and from their stored bytes using a Codec.
+type TypedQueue[T any] struct {
+	queue *Queue
+	codec Codec[T]
+}
+
+// OpenTypedQueue opens a typed queue if one exists at the given
+// directory. If one does not already exist, a new queue is created.
+// A nil codec uses GobCodec.
+func OpenTypedQueue[T any](dataDir string, codec Codec[T], opts ...QueueOption) (*TypedQueue[T], error) {
+	q, err := OpenQueue(dataDir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewTypedQueue[T](q, codec), nil
+}
+
+// NewTypedQueue returns a typed queue using the given, already opened
+// queue. A nil codec uses GobCodec.
+func NewTypedQueue[T any](q *Queue, codec Codec[T]) *TypedQueue[T] {
+	if codec == nil {
+		codec = GobCodec[T]{}
+	}
+	return &TypedQueue[T]{queue: q, codec: codec}
+}
+
+// Queue returns the underlying queue.
+func (tq *TypedQueue[T]) Queue() *Queue {
+	return tq.queue
+}
+
+// Enqueue encodes the given value and adds it to the queue.
+func (tq *TypedQueue[T]) Enqueue(v T) (*Item, error) {
+	data, err := tq.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return tq.queue.Enqueue(data)
+}
+
+// Dequeue removes the next value in the queue and returns it. If the
+// value cannot be decoded, it is still removed from the queue and the
+// decoding error is returned.
+func (tq *TypedQueue[T]) Dequeue() (T, error) {
+	item, err := tq.queue.Dequeue()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return tq.decode(item)
+}
+
+// Peek returns the next value in the queue without removing it.
+func (tq *TypedQueue[T]) Peek() (T, error) {
+	item, err := tq.queue.Peek()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return tq.decode(item)
+}
+
+// Length returns the total number of values in the queue.
+func (tq *TypedQueue[T]) Length() uint64 {
+	return tq.queue.Length()
+}
+
+// Close closes the LevelDB database of the queue.
+func (tq *TypedQueue[T]) Close() error {
+	return tq.queue.Close()
+}
+
+// Drop closes and deletes the LevelDB database of the queue.
+func (tq *TypedQueue[T]) Drop() error {
+	return tq.queue.Drop()
+}
+
+// decode decodes the value of the given item.
+func (tq *TypedQueue[T]) decode(item *Item) (T, error) {
+	var v T
+	err := tq.codec.Unmarshal(item.Value, &v)
+	return v, err
+}
@@ -0,0 +1,97 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type typedObject struct {
+	Name  string
+	Value int
+}
+
+func TestTypedQueue(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	tq, err := OpenTypedQueue[typedObject](file, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	defer tq.Drop()
+
+	for i := 1; i <= 10; i++ {
+		if _, err = tq.Enqueue(typedObject{fmt.Sprintf("item %d", i), i}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The values should survive reopening the queue.
+	if err = tq.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if tq, err = OpenTypedQueue[typedObject](file, nil); err != nil {
+		t.Error(err)
+	}
+
+	if tq.Length() != 10 {
+		t.Errorf("Expected queue length of 10, got %d", tq.Length())
+	}
+
+	peekObj, err := tq.Peek()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compObj := typedObject{"item 1", 1}
+
+	if peekObj != compObj {
+		t.Errorf("Expected object to be '%+v', got '%+v'", compObj, peekObj)
+	}
+
+	for i := 1; i <= 10; i++ {
+		obj, err := tq.Dequeue()
+		if err != nil {
+			t.Error(err)
+		}
+
+		compObj := typedObject{fmt.Sprintf("item %d", i), i}
+
+		if obj != compObj {
+			t.Errorf("Expected object to be '%+v', got '%+v'", compObj, obj)
+		}
+	}
+
+	if _, err = tq.Dequeue(); err != ErrEmpty {
+		t.Errorf("Expected to get empty error, got %v", err)
+	}
+}
+
+func TestTypedQueueJSONCodec(t *testing.T) {
+	q, err := OpenMemQueue()
+	if err != nil {
+		t.Error(err)
+	}
+	tq := NewTypedQueue[typedObject](q, JSONCodec[typedObject]{})
+	defer tq.Drop()
+
+	item, err := tq.Enqueue(typedObject{"item 1", 1})
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := `{"Name":"item 1","Value":1}`
+
+	if item.ToString() != compStr {
+		t.Errorf("Expected stored value to be '%s', got '%s'", compStr, item.ToString())
+	}
+
+	obj, err := tq.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if obj != (typedObject{"item 1", 1}) {
+		t.Errorf("Expected object to be '%+v', got '%+v'", typedObject{"item 1", 1}, obj)
+	}
+}
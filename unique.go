@@ -0,0 +1,56 @@
+package goque
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// uniqueKind follows the metadata prefix of the queue in the keys in
+// the index of unique items, which are followed by the SHA-256 hash of
+// the item value.
+const uniqueKind = 'u'
+
+// errDuplicate is returned by enqueue for a unique item whose value is
+// already in the queue.
+var errDuplicate = errors.New("goque: Duplicate unique item")
+
+// EnqueueUnique adds an item to the queue unless an item with the same
+// value that was also added using EnqueueUnique is still in the queue,
+// in which case nothing is added and false is returned. This allows a
+// producer to retry an enqueue without adding the value twice.
+//
+// The queue keeps an index of the SHA-256 hashes of the values of these
+// items, which is updated in the same atomic write as the items, so a
+// crash cannot leave the index and the queue out of sync. Items added
+// using the other enqueue methods are not part of the index. As a stack
+// does not know about the index, a queue holding unique items should
+// not be opened as a stack.
+func (q *Queue) EnqueueUnique(value []byte) (*Item, bool, error) {
+	item, evicted, _, err := q.enqueue(value, itemHeader{unique: true})
+	if err == errDuplicate {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, q.logError("enqueue", err)
+	}
+	q.evicted(evicted...)
+	q.enqueued(item)
+	return item, true, nil
+}
+
+// uniqueKey returns the key of the given value in the index of unique
+// items of the queue.
+func (q *Queue) uniqueKey(value []byte) []byte {
+	sum := sha256.Sum256(value)
+	key := append(append([]byte(nil), q.keys.meta...), uniqueKind)
+	return append(key, sum[:]...)
+}
+
+// unindex adds the removal of the given item from the index of unique
+// items to the batch, if it was added using EnqueueUnique.
+func (q *Queue) unindex(batch *leveldb.Batch, item *Item) {
+	if item.unique {
+		batch.Delete(q.uniqueKey(item.Value))
+	}
+}
@@ -0,0 +1,142 @@
+package goque
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueEnqueueUnique(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	item, ok, err := q.EnqueueUnique([]byte("value for item 1"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !ok || item.ID != 1 {
+		t.Errorf("Expected item 1 to be added, got %v and %v", ok, item)
+	}
+
+	// A retry with the same value is skipped.
+	if item, ok, err = q.EnqueueUnique([]byte("value for item 1")); err != nil {
+		t.Error(err)
+	}
+
+	if ok || item != nil {
+		t.Errorf("Expected duplicate item to be skipped, got %v and %v", ok, item)
+	}
+
+	if _, ok, err = q.EnqueueUnique([]byte("value for item 2")); err != nil || !ok {
+		t.Errorf("Expected item 2 to be added, got %v and %v", ok, err)
+	}
+
+	// Items added using Enqueue are not part of the index.
+	if _, err = q.EnqueueString("value for item 2"); err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 3 {
+		t.Errorf("Expected queue length of 3, got %d", q.Length())
+	}
+
+	// The index survives reopening, without counting as items.
+	q.Close()
+	q, err = OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if q.Length() != 3 {
+		t.Errorf("Expected queue length of 3, got %d", q.Length())
+	}
+
+	if _, ok, err = q.EnqueueUnique([]byte("value for item 2")); err != nil || ok {
+		t.Errorf("Expected duplicate item to be skipped, got %v and %v", ok, err)
+	}
+
+	// A dequeued value can be added again.
+	deqItem, err := q.Dequeue()
+	if err != nil {
+		t.Error(err)
+	}
+
+	compStr := "value for item 1"
+	if deqItem.ToString() != compStr {
+		t.Errorf("Expected string to be '%s', got '%s'", compStr, deqItem.ToString())
+	}
+
+	if item, ok, err = q.EnqueueUnique([]byte("value for item 1")); err != nil || !ok {
+		t.Errorf("Expected item to be added again, got %v and %v", ok, err)
+	}
+
+	if item.ID != 4 {
+		t.Errorf("Expected item ID to be 4, got %d", item.ID)
+	}
+
+	// Updating a unique item moves it in the index.
+	if _, err = q.UpdateString(4, "new value for item 4"); err != nil {
+		t.Error(err)
+	}
+
+	if _, ok, err = q.EnqueueUnique([]byte("value for item 1")); err != nil || !ok {
+		t.Errorf("Expected old value to be added again, got %v and %v", ok, err)
+	}
+
+	if _, ok, err = q.EnqueueUnique([]byte("new value for item 4")); err != nil || ok {
+		t.Errorf("Expected new value to be skipped, got %v and %v", ok, err)
+	}
+}
+
+func TestQueueEnqueueUniqueRemoval(t *testing.T) {
+	file := fmt.Sprintf("test_db_%d", time.Now().UnixNano())
+	q, err := OpenQueue(file)
+	if err != nil {
+		t.Error(err)
+	}
+	defer q.Drop()
+
+	for i := 1; i <= 4; i++ {
+		if _, _, err = q.EnqueueUnique([]byte(fmt.Sprintf("value for item %d", i))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err = q.DequeueByID(3); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.DequeueBatch(1); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = q.Trim(1); err != nil {
+		t.Error(err)
+	}
+
+	// Only the remaining item is still in the index.
+	for i := 1; i <= 4; i++ {
+		_, ok, err := q.EnqueueUnique([]byte(fmt.Sprintf("value for item %d", i)))
+		if err != nil {
+			t.Error(err)
+		}
+
+		if ok != (i != 4) {
+			t.Errorf("Expected item %d to be added to be %v, got %v", i, i != 4, ok)
+		}
+	}
+
+	// Clear removes the index as well.
+	if err = q.Clear(); err != nil {
+		t.Error(err)
+	}
+
+	if _, ok, err := q.EnqueueUnique([]byte("value for item 4")); err != nil || !ok {
+		t.Errorf("Expected item to be added after clear, got %v and %v", ok, err)
+	}
+}